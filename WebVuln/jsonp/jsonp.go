@@ -10,6 +10,7 @@ import (
 	"strings"
 
 	"github.com/BreakOnCrash/opendast/WebVuln/jsonp/js"
+	"github.com/BreakOnCrash/opendast/pkg/httpx"
 )
 
 var (
@@ -37,6 +38,13 @@ var (
 	}
 )
 
+var httpclient = httpx.Default()
+
+// SetClient 设置检测使用的 HTTP 客户端
+func SetClient(c *httpx.Client) {
+	httpclient = c
+}
+
 func AuditJSONPHijacking(urlStr string) error {
 	URL, err := url.Parse(urlStr)
 	if err != nil {
@@ -99,7 +107,7 @@ func fetchWithReferer(URL, referer string) (string, error) {
 		req.Header.Set("Referer", referer)
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := httpclient.Do(req)
 	if err != nil {
 		return "", err
 	}
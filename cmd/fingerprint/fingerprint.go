@@ -1,35 +1,322 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
-	"time"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"unicode/utf8"
 
 	"github.com/BreakOnCrash/opendast/fingerprint"
+	"github.com/BreakOnCrash/opendast/other/tire"
+	"github.com/BreakOnCrash/opendast/pkg/httpx"
 )
 
 var (
-	urlFlag  = flag.String("url", "", "target url")
-	exprFlag = flag.String("expr", "", "expression")
+	urlFlag     = flag.String("url", "", "target url, host or host:port")
+	listFlag    = flag.String("l", "", "file with one target per line, - for stdin")
+	exprFlag    = flag.String("expr", "", "evaluate a single expression instead of the rule database")
+	rulesFlag   = flag.String("rules", "", "comma separated rule files or directories, loaded after the built-in rules")
+	outputFlag  = flag.String("o", "table", "output format: table or jsonl")
+	threadsFlag = flag.Int("c", 20, "concurrent targets")
+	timeoutFlag = flag.Int("timeout", httpx.DefaultTimeout, "request timeout in seconds")
+	proxyFlag   = flag.String("proxy", "", "http or socks5 proxy, e.g. socks5://127.0.0.1:1080")
+	envFlag     = flag.Bool("proxy-env", false, "use the proxy from HTTP_PROXY/HTTPS_PROXY/NO_PROXY when -proxy is empty")
+	scopeFlag   = flag.String("scope", "", "file with scope rules (example.com, *.example.com, .example.com, !excluded.example.com), out of scope targets are skipped")
+	testFlag    = flag.Bool("test", false, "run the fixture tests of the rules given by -rules (built-in rules if empty) without network access")
 )
 
+// output 单个服务的输出
+type output struct {
+	URL          string                   `json:"url"`
+	Status       int                      `json:"status"`
+	Title        string                   `json:"title,omitempty"`
+	Server       string                   `json:"server,omitempty"`
+	Results      []fingerprint.Result     `json:"results"`
+	Technologies []fingerprint.Technology `json:"technologies,omitempty"`
+}
+
 func main() {
 	flag.Parse()
 
-	if *urlFlag == "" || *exprFlag == "" {
+	if *testFlag {
+		os.Exit(runTests())
+	}
+
+	var targets []string
+	switch {
+	case *urlFlag != "":
+		targets = []string{*urlFlag}
+	case *listFlag != "":
+		var err error
+		if targets, err = readTargets(*listFlag); err != nil {
+			log.Fatalln(err)
+		}
+	case stdinIsPipe():
+		var err error
+		if targets, err = readTargets("-"); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if len(targets) == 0 || (*outputFlag != "table" && *outputFlag != "jsonl") {
 		fmt.Println("Usage: fingerprint [options]")
 		flag.PrintDefaults()
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	var scope *tire.DomainTrie
+	if *scopeFlag != "" {
+		var err error
+		if scope, err = tire.LoadDomains(*scopeFlag); err != nil {
+			log.Fatalln(err)
+		}
+		targets = slices.DeleteFunc(targets, func(t string) bool {
+			if !scope.Allow(t) {
+				log.Printf("%s: out of scope", t)
+				return true
+			}
+			return false
+		})
+	}
+
+	client, err := httpx.New(&httpx.Config{
+		Proxy:    *proxyFlag,
+		ProxyEnv: *envFlag,
+		Timeout:  *timeoutFlag,
+		Scope:    scope,
+	})
+	if err != nil {
+		log.Fatalln(err)
+	}
+	fingerprint.SetClient(client)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer cancel()
-	v, err := fingerprint.Match(ctx, *urlFlag, *exprFlag)
+
+	if *exprFlag != "" {
+		for _, t := range targets {
+			for _, u := range candidates(t) {
+				v, err := fingerprint.Match(ctx, u, *exprFlag)
+				if err != nil {
+					log.Printf("%s: %v", u, err)
+					continue
+				}
+				fmt.Printf("taget: %s\n\t `%s` => %v \n", u, *exprFlag, v)
+				break
+			}
+		}
+		return
+	}
+
+	db, err := fingerprint.DefaultRules()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if *rulesFlag != "" {
+		extra, err := fingerprint.LoadRules(strings.Split(*rulesFlag, ",")...)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if err := db.Add(extra.Rules()...); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	w := newWriter(os.Stdout, *outputFlag)
+	defer w.Flush()
+
+	var (
+		wg   sync.WaitGroup
+		jobs = make(chan string)
+	)
+	for i := 0; i < max(*threadsFlag, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range jobs {
+				scan(ctx, db, t, w)
+			}
+		}()
+	}
+	for _, t := range targets {
+		select {
+		case jobs <- t:
+		case <-ctx.Done():
+		}
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// runTests 运行规则的 fixture 测试, 有失败时返回 1
+func runTests() int {
+	var (
+		db  *fingerprint.RuleDB
+		err error
+	)
+	if *rulesFlag != "" {
+		db, err = fingerprint.LoadRules(strings.Split(*rulesFlag, ",")...)
+	} else {
+		db, err = fingerprint.DefaultRules()
+	}
 	if err != nil {
 		log.Fatalln(err)
 	}
 
-	fmt.Printf("taget: %s\n\t `%s` => %v \n", *urlFlag, *exprFlag, v)
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	code := 0
+	reports := db.RunTests(ctx)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "RULE\tTESTS\tFAILED\tAMBIGUOUS\tEVAL")
+	for _, r := range reports {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s\n", r.Rule, r.Tests, len(r.Failures), len(r.Ambiguous), r.Eval)
+		if len(r.Failures) > 0 {
+			code = 1
+		}
+	}
+	tw.Flush()
+
+	for _, r := range reports {
+		for _, f := range r.Failures {
+			fmt.Printf("FAIL %s: %s\n", r.Rule, f)
+		}
+		for _, a := range r.Ambiguous {
+			fmt.Printf("WARN %s: %s\n", r.Rule, a)
+		}
+	}
+	return code
+}
+
+// scan 依次尝试目标的候选地址, host:port 只输出第一个成功的, 裸主机名 http 和 https 都输出
+func scan(ctx context.Context, db *fingerprint.RuleDB, target string, w *writer) {
+	urls := candidates(target)
+	bare := !strings.Contains(target, "://") && !hasPort(target)
+
+	for _, u := range urls {
+		if ctx.Err() != nil {
+			return
+		}
+		report, err := db.Scan(ctx, u)
+		if err != nil {
+			log.Printf("%s: %v", u, err)
+			// 规则出错时其他规则的结果仍然输出
+			var re *fingerprint.RuleError
+			if !errors.As(err, &re) {
+				continue
+			}
+		}
+		s := report.Sample
+		w.Write(&output{
+			URL:          s.URL,
+			Status:       int(s.StatusCode),
+			Title:        s.Title,
+			Server:       s.Server,
+			Results:      report.Results,
+			Technologies: report.Technologies,
+		})
+		if !bare {
+			return
+		}
+	}
+}
+
+// writer 并发安全的输出, 表格在结束时统一对齐输出
+type writer struct {
+	mux sync.Mutex
+	enc *json.Encoder
+	tw  *tabwriter.Writer
+}
+
+func newWriter(out io.Writer, format string) *writer {
+	w := &writer{}
+	if format == "jsonl" {
+		w.enc = json.NewEncoder(out)
+		w.enc.SetEscapeHTML(false)
+		return w
+	}
+	w.tw = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w.tw, "URL\tSTATUS\tTITLE\tSERVER\tPRODUCTS")
+	return w
+}
+
+func (w *writer) Write(o *output) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	if w.enc != nil {
+		w.enc.Encode(o)
+		return
+	}
+
+	products := make([]string, 0, len(o.Results)+len(o.Technologies))
+	for _, r := range o.Results {
+		products = append(products, join(r.Product, r.Version))
+	}
+	for _, t := range o.Technologies {
+		products = append(products, join(t.Name, t.Version))
+	}
+	fmt.Fprintf(w.tw, "%s\t%d\t%s\t%s\t%s\n", o.URL, o.Status, truncate(o.Title, 40), o.Server, strings.Join(products, ","))
+}
+
+func (w *writer) Flush() {
+	if w.tw != nil {
+		w.tw.Flush()
+	}
+}
+
+func join(name, version string) string {
+	if version == "" {
+		return name
+	}
+	return name + "/" + version
+}
+
+func truncate(s string, n int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n-3]) + "..."
+}
+
+func stdinIsPipe() bool {
+	fi, err := os.Stdin.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice == 0
+}
+
+// readTargets 读取目标列表, 忽略空行和 # 注释
+func readTargets(name string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var targets []string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// 兼容 "host:port open" 之类带说明的端口扫描输出
+		targets = append(targets, strings.Fields(line)[0])
+	}
+	return targets, s.Err()
 }
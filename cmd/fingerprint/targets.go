@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net"
+	"strings"
+)
+
+// httpsPorts 优先尝试 https 的端口
+var httpsPorts = map[string]bool{
+	"443": true, "4443": true, "8443": true, "9443": true, "10443": true,
+}
+
+// candidates 返回目标的候选地址:
+// URL 原样返回; host:port 按端口决定 https 和 http 的尝试顺序; 裸主机名返回 http 和 https
+func candidates(target string) []string {
+	if strings.Contains(target, "://") {
+		return []string{target}
+	}
+	if ip := net.ParseIP(target); ip != nil && ip.To4() == nil {
+		target = "[" + target + "]"
+	}
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return []string{"http://" + target, "https://" + target}
+	}
+
+	addr := net.JoinHostPort(host, port)
+	// 省略默认端口时 IPv6 地址也需要方括号
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	switch {
+	case port == "80":
+		return []string{"http://" + host}
+	case port == "443":
+		return []string{"https://" + host, "http://" + addr}
+	case httpsPorts[port]:
+		return []string{"https://" + addr, "http://" + addr}
+	}
+	return []string{"http://" + addr, "https://" + addr}
+}
+
+func hasPort(target string) bool {
+	_, _, err := net.SplitHostPort(target)
+	return err == nil
+}
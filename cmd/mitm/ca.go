@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/BreakOnCrash/opendast/mitm"
+)
+
+const caUsage = `Usage: mitm ca <command> [options]
+
+Commands:
+  gen      generate a new root CA, fails if one already exists
+  show     print the subject, validity and fingerprint of the root CA
+  export   write the root CA as PEM, DER or PKCS#12 (with the private key)
+  rotate   generate a new root CA and keep the old one as *.bak
+
+Browsers using the proxy can also download the CA from http://` + mitm.CAHost + `
+`
+
+// runCA 处理 ca 子命令, 返回退出码
+func runCA(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, caUsage)
+		return 2
+	}
+	defaultDir, err := mitm.DefaultDir()
+	if err != nil {
+		defaultDir = "."
+	}
+
+	fs := flag.NewFlagSet("ca "+args[0], flag.ExitOnError)
+	dir := fs.String("dir", defaultDir, "directory of ca.pem and ca.key")
+	alg := fs.String("alg", mitm.AlgRSA, "key algorithm for gen and rotate: rsa or ecdsa")
+	name := fs.String("name", mitm.DefaultCAName, "common name for gen and rotate")
+	days := fs.Int("days", int(mitm.DefaultCAValidity/(24*time.Hour)), "validity in days for gen and rotate")
+	format := fs.String("format", "pem", "export format: pem, der or p12")
+	out := fs.String("o", "", "export output file, stdout if empty")
+	password := fs.String("password", "", "PKCS#12 export password")
+	fs.Parse(args[1:])
+	validity := time.Duration(*days) * 24 * time.Hour
+
+	var ca *mitm.CA
+	switch args[0] {
+	case "gen":
+		// 只有不存在时才生成, 权限错误或文件损坏时不能覆盖已有的根证书
+		if _, err := mitm.LoadCADir(*dir); err == nil {
+			return fail(fmt.Errorf("a root CA already exists in %s, use rotate to replace it", *dir))
+		} else if !errors.Is(err, mitm.ErrCANotFound) {
+			return fail(fmt.Errorf("existing root CA in %s: %w", *dir, err))
+		}
+		if ca, err = mitm.NewCA(*name, *alg, validity); err != nil {
+			return fail(err)
+		}
+		if err := ca.Save(*dir); err != nil {
+			return fail(err)
+		}
+	case "rotate":
+		if ca, err = mitm.Rotate(*dir, *name, *alg, validity); err != nil {
+			return fail(err)
+		}
+	case "show":
+		if ca, err = mitm.LoadCADir(*dir); err != nil {
+			return fail(err)
+		}
+	case "export":
+		if ca, err = mitm.LoadCADir(*dir); err != nil {
+			return fail(err)
+		}
+		var data []byte
+		switch strings.ToLower(*format) {
+		case "pem":
+			data = ca.PEM()
+		case "der", "crt", "cer":
+			data = ca.DER()
+		case "p12", "pfx", "pkcs12":
+			if data, err = ca.PKCS12(*password); err != nil {
+				return fail(err)
+			}
+		default:
+			return fail(fmt.Errorf("unknown export format %q", *format))
+		}
+		if *out == "" {
+			os.Stdout.Write(data)
+			return 0
+		}
+		// PKCS#12 包含私钥
+		if err := os.WriteFile(*out, data, 0o600); err != nil {
+			return fail(err)
+		}
+		return 0
+	default:
+		fmt.Fprint(os.Stderr, caUsage)
+		return 2
+	}
+
+	fmt.Printf("Directory:   %s\n", *dir)
+	fmt.Printf("Subject:     %s\n", ca.Cert.Subject)
+	fmt.Printf("Key:         %s\n", ca.Cert.PublicKeyAlgorithm)
+	fmt.Printf("Not before:  %s\n", ca.Cert.NotBefore.Format(time.RFC3339))
+	fmt.Printf("Not after:   %s\n", ca.Cert.NotAfter.Format(time.RFC3339))
+	fmt.Printf("SHA-256:     %s\n", ca.Fingerprint())
+	return 0
+}
+
+func fail(err error) int {
+	fmt.Fprintln(os.Stderr, err)
+	return 1
+}
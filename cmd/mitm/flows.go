@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/BreakOnCrash/opendast/mitm/capture"
+)
+
+// runFlows 查询保存的 Flow, 返回退出码
+func runFlows(args []string) int {
+	fs := flag.NewFlagSet("flows", flag.ExitOnError)
+	db := fs.String("db", "", "SQLite database of captured flows (default flows.db in the per-user config dir)")
+	host := fs.String("host", "", "host, *.example.com for subdomains, .example.com for the domain and subdomains")
+	path := fs.String("path", "", "path prefix")
+	method := fs.String("method", "", "request method")
+	status := fs.String("status", "", "comma separated status codes")
+	contentType := fs.String("type", "", "content type prefix, e.g. text/html or application/")
+	since := fs.Duration("since", 0, "only flows captured within this duration, e.g. 1h")
+	limit := fs.Int("n", 100, "maximum number of flows, 0 for all")
+	output := fs.String("o", "table", "output format: table or jsonl (with headers and bodies)")
+	fs.Parse(args)
+
+	q := capture.Query{
+		Host:        *host,
+		Path:        *path,
+		Method:      *method,
+		ContentType: *contentType,
+		Limit:       *limit,
+	}
+	if *since > 0 {
+		q.Since = time.Now().Add(-*since)
+	}
+	for _, s := range strings.Split(*status, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		code, err := strconv.Atoi(s)
+		if err != nil {
+			return fail(fmt.Errorf("invalid status %q", s))
+		}
+		q.Status = append(q.Status, code)
+	}
+
+	store, err := openStore(*db)
+	if err != nil {
+		return fail(err)
+	}
+	defer store.Close()
+	flows, err := store.Query(context.Background(), q)
+	if err != nil {
+		return fail(err)
+	}
+
+	if *output == "jsonl" {
+		enc := json.NewEncoder(os.Stdout)
+		for _, f := range flows {
+			enc.Encode(f)
+		}
+		return 0
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTIME\tMETHOD\tSTATUS\tTYPE\tSIZE\tDURATION\tURL")
+	for _, f := range flows {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%s\t%d\t%s\t%s\n", f.ID, f.Time.Format(time.DateTime), f.Method, f.Status,
+			f.ContentType, len(f.ResponseBody), f.Duration.Round(time.Millisecond), f.URL)
+	}
+	w.Flush()
+	return 0
+}
@@ -1,10 +1,176 @@
 package main
 
-import "github.com/BreakOnCrash/opendast/mitm"
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+
+	"github.com/BreakOnCrash/opendast/mitm"
+	"github.com/BreakOnCrash/opendast/mitm/capture"
+	"github.com/BreakOnCrash/opendast/other/tire"
+)
+
+var (
+	addrFlag     = flag.String("addr", mitm.DefaultAddr, "listen address")
+	dirFlag      = flag.String("ca-dir", "", "directory of the root CA, created on first run (default per-user config dir, see mitm ca show)")
+	certFlag     = flag.String("ca", "", "root CA certificate file, overrides -ca-dir")
+	keyFlag      = flag.String("key", "", "root CA private key file, overrides -ca-dir")
+	tunnelFlag   = flag.Bool("tunnel", false, "tunnel HTTPS without decryption")
+	upstreamFlag = flag.String("upstream", "", "upstream proxy, e.g. http://127.0.0.1:8080")
+	verifyFlag   = flag.Bool("verify", false, "verify the certificates of target servers")
+	timeoutFlag  = flag.Int("timeout", mitm.DefaultTimeout, "TLS handshake and response header timeout in seconds")
+	idleFlag     = flag.Int("idle-timeout", mitm.DefaultIdleTimeout, "client connection idle timeout in seconds")
+	scopeFlag    = flag.String("scope", "", "file with scope rules, requests to other hosts are forwarded without logging")
+	dbFlag       = flag.String("db", "", "SQLite database for captured flows (default flows.db in the per-user config dir), - to disable")
+	maxBodyFlag  = flag.Int64("max-body", capture.DefaultMaxBody, "bytes of each request and response body to capture")
+)
 
 func main() {
-	certFile := "./ca.pem"
-	keyFile := "./ca.key"
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "ca":
+			os.Exit(runCA(os.Args[2:]))
+		case "flows":
+			os.Exit(runFlows(os.Args[2:]))
+		}
+	}
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: mitm [options]\n       mitm ca <gen|show|export|rotate> [options]\n       mitm flows [options]\n\nOptions:")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	os.Exit(runProxy())
+}
+
+// runProxy 运行代理直到收到中断信号或代理出错, 返回退出码.
+// 不调用 log.Fatal, 保证退出前关闭数据库
+func runProxy() int {
+	cfg := &mitm.Config{
+		Addr:        *addrFlag,
+		CertFile:    *certFlag,
+		KeyFile:     *keyFlag,
+		Upstream:    *upstreamFlag,
+		Verify:      *verifyFlag,
+		Timeout:     *timeoutFlag,
+		IdleTimeout: *idleFlag,
+	}
+	switch {
+	case *tunnelFlag:
+		cfg.CertFile, cfg.KeyFile = "", ""
+	case *certFlag == "" && *keyFlag == "":
+		ca, err := loadOrCreateCA(*dirFlag)
+		if err != nil {
+			return fail(err)
+		}
+		cfg.CA = ca
+	}
+	if *scopeFlag != "" {
+		scope, err := tire.LoadDomains(*scopeFlag)
+		if err != nil {
+			return fail(err)
+		}
+		cfg.Scope = scope
+	}
+
+	p, err := mitm.New(cfg)
+	if err != nil {
+		return fail(err)
+	}
+	p.OnRequest(func(req *http.Request) error {
+		log.Printf("[mitm] request - method: %s url: %s", req.Method, req.URL.String())
+		return nil
+	})
+	p.OnResponse(func(res *http.Response) error {
+		log.Printf("[mitm] response - method: %s url: %s status: %d", res.Request.Method, res.Request.URL.String(), res.StatusCode)
+		return nil
+	})
+	if *dbFlag != "-" {
+		store, err := openStore(*dbFlag)
+		if err != nil {
+			return fail(err)
+		}
+		defer store.Close()
+		rec := capture.NewRecorder(store, *maxBodyFlag)
+		// 在关闭数据库之前写入队列中的 Flow
+		defer func() {
+			if err := rec.Close(); err != nil {
+				log.Println(err)
+			}
+		}()
+		p.OnRequest(rec.Request)
+		p.OnResponse(rec.Response)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	if err := p.Start(context.Background()); err != nil {
+		return fail(err)
+	}
+	log.Printf("proxy server listen on %s, download the CA from http://%s", p.Addr(), mitm.CAHost)
+
+	served := make(chan error, 1)
+	go func() {
+		served <- p.Wait()
+	}()
+	select {
+	case err := <-served:
+		// 代理意外退出, 如 accept 出错
+		if err == nil {
+			err = errors.New("proxy server stopped")
+		}
+		return fail(err)
+	case <-ctx.Done():
+	}
+
+	log.Println("shutting down")
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := p.Shutdown(ctx); err != nil {
+		return fail(err)
+	}
+	return 0
+}
+
+// loadOrCreateCA 读取 dir 中的根证书, 不存在时生成
+func loadOrCreateCA(dir string) (*mitm.CA, error) {
+	if dir == "" {
+		var err error
+		if dir, err = mitm.DefaultDir(); err != nil {
+			return nil, err
+		}
+	}
+	ca, err := mitm.LoadCADir(dir)
+	if !errors.Is(err, mitm.ErrCANotFound) {
+		return ca, err
+	}
+	if ca, err = mitm.NewCA(mitm.DefaultCAName, mitm.AlgRSA, mitm.DefaultCAValidity); err != nil {
+		return nil, err
+	}
+	if err := ca.Save(dir); err != nil {
+		return nil, err
+	}
+	log.Printf("generated root CA in %s, SHA-256 %s", dir, ca.Fingerprint())
+	return ca, nil
+}
 
-	mitm.RunDemo(certFile, keyFile, "")
+// openStore 打开保存 Flow 的数据库, name 为空时使用用户配置目录中的 flows.db
+func openStore(name string) (*capture.Store, error) {
+	if name == "" {
+		dir, err := mitm.DefaultDir()
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, err
+		}
+		name = filepath.Join(dir, "flows.db")
+	}
+	return capture.Open(name)
 }
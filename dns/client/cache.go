@@ -0,0 +1,276 @@
+package client
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const DefaultCacheEntries = 100000
+
+// Store 缓存的持久化存储, 由 Cache 在内存未命中时查询
+type Store interface {
+	Get(key string) (msg []byte, expire time.Time, ok bool)
+	Set(key string, msg []byte, expire time.Time) error
+}
+
+type cacheEntry struct {
+	msg    *dns.Msg
+	expire time.Time
+}
+
+type call struct {
+	wg    sync.WaitGroup
+	entry *cacheEntry
+	err   error
+}
+
+// Cache 按记录 TTL 缓存查询结果, NXDOMAIN/NODATA 按 SOA 做否定缓存,
+// 同一问题的并发查询只发出一次
+type Cache struct {
+	mux        sync.Mutex
+	entries    map[string]cacheEntry
+	inflight   map[string]*call
+	maxEntries int
+	store      Store
+}
+
+func NewCache(maxEntries int, store Store) *Cache {
+	if maxEntries <= 0 {
+		maxEntries = DefaultCacheEntries
+	}
+	return &Cache{
+		entries:    make(map[string]cacheEntry),
+		inflight:   make(map[string]*call),
+		maxEntries: maxEntries,
+		store:      store,
+	}
+}
+
+func (c *Cache) do(msg *dns.Msg, exchange func(*dns.Msg) (*dns.Msg, error)) (*dns.Msg, error) {
+	if len(msg.Question) != 1 {
+		return exchange(msg)
+	}
+	key := cacheKey(msg.Question[0])
+
+	c.mux.Lock()
+	if e, ok := c.lookup(key); ok {
+		c.mux.Unlock()
+		return reply(msg, e)
+	}
+	if cl, ok := c.inflight[key]; ok {
+		c.mux.Unlock()
+		cl.wg.Wait()
+		if cl.entry == nil {
+			return nil, cl.err
+		}
+		return reply(msg, cl.entry)
+	}
+	cl := &call{}
+	cl.wg.Add(1)
+	c.inflight[key] = cl
+	c.mux.Unlock()
+
+	// 持久化存储的读写在锁外进行, 避免所有查询等待磁盘
+	if e, ok := c.load(key); ok {
+		cl.entry = e
+		c.finish(key, cl, true)
+		return reply(msg, e)
+	}
+
+	resp, err := exchange(msg)
+	cl.err = err
+	if resp == nil {
+		c.finish(key, cl, false)
+		return nil, err
+	}
+	cl.entry = &cacheEntry{msg: resp.Copy()}
+	ttl, ok := cacheTTL(cl.entry.msg)
+	if ok {
+		cl.entry.expire = time.Now().Add(ttl)
+	}
+	c.finish(key, cl, ok)
+	if ok && c.store != nil {
+		if data, err := cl.entry.msg.Pack(); err == nil {
+			_ = c.store.Set(key, data, cl.entry.expire)
+		}
+	}
+	// 缓存中的报文与调用方修改的报文分开
+	return cl.entry.msg.Copy(), err
+}
+
+// finish 结束查询并唤醒等待的调用方, cache 为 true 时将结果写入内存缓存
+func (c *Cache) finish(key string, cl *call, cache bool) {
+	c.mux.Lock()
+	if cache {
+		c.insert(key, *cl.entry)
+	}
+	delete(c.inflight, key)
+	c.mux.Unlock()
+	cl.wg.Done()
+}
+
+// lookup 查询内存缓存, 调用方需持有锁
+func (c *Cache) lookup(key string) (*cacheEntry, bool) {
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !time.Now().Before(e.expire) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return &e, true
+}
+
+// load 查询持久化存储, 不需要持有锁
+func (c *Cache) load(key string) (*cacheEntry, bool) {
+	if c.store == nil {
+		return nil, false
+	}
+	data, expire, ok := c.store.Get(key)
+	if !ok || !time.Now().Before(expire) {
+		return nil, false
+	}
+	m := &dns.Msg{}
+	if err := m.Unpack(data); err != nil {
+		return nil, false
+	}
+	return &cacheEntry{msg: m, expire: expire}, true
+}
+
+// insert 写入内存缓存, 超出容量时先清理过期项, 仍超出则随机淘汰, 调用方需持有锁
+func (c *Cache) insert(key string, e cacheEntry) {
+	if len(c.entries) >= c.maxEntries {
+		now := time.Now()
+		for k, e := range c.entries {
+			if !now.Before(e.expire) {
+				delete(c.entries, k)
+			}
+		}
+		for k := range c.entries {
+			if len(c.entries) < c.maxEntries {
+				break
+			}
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = e
+}
+
+// Purge 清空内存缓存
+func (c *Cache) Purge() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.entries = make(map[string]cacheEntry)
+}
+
+func cacheKey(q dns.Question) string {
+	return strings.ToLower(dns.CanonicalName(q.Name)) + "/" +
+		dns.TypeToString[q.Qtype] + "/" + dns.ClassToString[q.Qclass]
+}
+
+// cacheTTL 计算应答的缓存时间:
+// 有应答记录时取最小 TTL; NXDOMAIN 或无记录时取 SOA 的 TTL 和 MINIMUM 中较小值 (RFC 2308)
+func cacheTTL(resp *dns.Msg) (time.Duration, bool) {
+	if resp == nil || resp.Truncated {
+		return 0, false
+	}
+
+	var (
+		ttl   uint32
+		found bool
+	)
+	min := func(v uint32) {
+		if !found || v < ttl {
+			ttl = v
+		}
+		found = true
+	}
+
+	switch {
+	case resp.Rcode == dns.RcodeSuccess && len(resp.Answer) > 0:
+		for _, rr := range resp.Answer {
+			min(rr.Header().Ttl)
+		}
+	case resp.Rcode == dns.RcodeSuccess || resp.Rcode == dns.RcodeNameError:
+		for _, rr := range resp.Ns {
+			if soa, ok := rr.(*dns.SOA); ok {
+				min(soa.Hdr.Ttl)
+				min(soa.Minttl)
+			}
+		}
+	}
+
+	if !found || ttl == 0 {
+		return 0, false
+	}
+	return time.Duration(ttl) * time.Second, true
+}
+
+// reply 用缓存构造应答, 按剩余时间调整 TTL
+func reply(req *dns.Msg, e *cacheEntry) (*dns.Msg, error) {
+	resp := e.msg.Copy()
+	resp.Id = req.Id
+
+	if remain := time.Until(e.expire); remain > 0 {
+		ttl := uint32(remain / time.Second)
+		for _, section := range [][]dns.RR{resp.Answer, resp.Ns, resp.Extra} {
+			for _, rr := range section {
+				if h := rr.Header(); h.Rrtype != dns.TypeOPT && h.Ttl > ttl {
+					h.Ttl = ttl
+				}
+			}
+		}
+	}
+
+	if resp.Rcode != dns.RcodeSuccess {
+		return resp, ErrMaxRetries
+	}
+	return resp, nil
+}
+
+// FileStore 将缓存按 key 的 md5 保存在目录下, 文件内容为 8 字节过期时间 + 报文
+type FileStore struct {
+	dir string
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (s *FileStore) Get(key string) ([]byte, time.Time, bool) {
+	data, err := os.ReadFile(s.path(key))
+	if err != nil || len(data) < 8 {
+		return nil, time.Time{}, false
+	}
+	expire := time.Unix(int64(binary.BigEndian.Uint64(data[:8])), 0)
+	return data[8:], expire, true
+}
+
+func (s *FileStore) Set(key string, msg []byte, expire time.Time) error {
+	if len(msg) == 0 {
+		return errors.New("empty message")
+	}
+	data := make([]byte, 8+len(msg))
+	binary.BigEndian.PutUint64(data[:8], uint64(expire.Unix()))
+	copy(data[8:], msg)
+	return os.WriteFile(s.path(key), data, 0o600)
+}
+
+func (s *FileStore) path(key string) string {
+	h := md5.Sum([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(h[:]))
+}
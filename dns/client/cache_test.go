@@ -0,0 +1,116 @@
+package client
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestCacheCoalesce(t *testing.T) {
+	var calls int32
+	exchange := func(m *dns.Msg) (*dns.Msg, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(50 * time.Millisecond)
+		resp := new(dns.Msg)
+		resp.SetReply(m)
+		rr, _ := dns.NewRR("example.com. 300 IN A 127.0.0.1")
+		resp.Answer = append(resp.Answer, rr)
+		return resp, nil
+	}
+
+	c := NewCache(0, nil)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			msg := new(dns.Msg)
+			msg.SetQuestion("example.com.", dns.TypeA)
+			resp, err := c.do(msg, exchange)
+			if err != nil || len(resp.Answer) != 1 || resp.Id != msg.Id {
+				t.Errorf("unexpected response: %v %v", resp, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	msg := new(dns.Msg)
+	msg.SetQuestion("EXAMPLE.com.", dns.TypeA)
+	if _, err := c.do(msg, exchange); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Fatalf("exchange called %d times, want 1", calls)
+	}
+}
+
+func TestCacheCopy(t *testing.T) {
+	exchange := func(m *dns.Msg) (*dns.Msg, error) {
+		resp := new(dns.Msg)
+		resp.SetReply(m)
+		rr, _ := dns.NewRR("example.com. 300 IN A 127.0.0.1")
+		resp.Answer = append(resp.Answer, rr)
+		return resp, nil
+	}
+
+	c := NewCache(0, nil)
+	for i := 0; i < 2; i++ {
+		msg := new(dns.Msg)
+		msg.SetQuestion("example.com.", dns.TypeA)
+		resp, err := c.do(msg, exchange)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Answer) != 1 || resp.Answer[0].Header().Ttl < 299 {
+			t.Fatalf("cached response modified by caller: %v", resp)
+		}
+		// 调用方修改应答不能影响缓存
+		resp.Answer[0].Header().Ttl = 0
+		resp.Answer = nil
+	}
+}
+
+func TestCacheNegative(t *testing.T) {
+	soa, _ := dns.NewRR("example.com. 3600 IN SOA ns.example.com. admin.example.com. 1 7200 3600 1209600 60")
+	resp := new(dns.Msg)
+	resp.Rcode = dns.RcodeNameError
+	resp.Ns = []dns.RR{soa}
+	if ttl, ok := cacheTTL(resp); !ok || ttl != 60*time.Second {
+		t.Fatalf("got %v %v, want 60s", ttl, ok)
+	}
+
+	resp.Ns = nil
+	if _, ok := cacheTTL(resp); ok {
+		t.Fatal("NXDOMAIN without SOA should not be cached")
+	}
+
+	resp.Rcode = dns.RcodeServerFailure
+	resp.Ns = []dns.RR{soa}
+	if _, ok := cacheTTL(resp); ok {
+		t.Fatal("SERVFAIL should not be cached")
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	s, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion("example.com.", dns.TypeA)
+	data, _ := msg.Pack()
+	expire := time.Now().Add(time.Minute).Truncate(time.Second)
+	if err := s.Set("k", data, expire); err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewCache(0, s)
+	e, ok := c.load("k")
+	if !ok || !e.expire.Equal(expire) || e.msg.Question[0].Name != "example.com." {
+		t.Fatalf("unexpected entry: %v %v", e, ok)
+	}
+}
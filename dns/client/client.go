@@ -3,6 +3,7 @@ package client
 import (
 	"errors"
 	"net"
+	"strings"
 	"time"
 
 	"github.com/miekg/dns"
@@ -12,6 +13,9 @@ type Config struct {
 	Resolvers  []string `yaml:"resolvers" json:"resolvers"`
 	Timeout    int      `yaml:"timeout" json:"timeout"`
 	MaxRetries int      `yaml:"max-retries" json:"max-retries"`
+	Cache      bool     `yaml:"cache" json:"cache"`           // 开启查询缓存
+	CacheSize  int      `yaml:"cache-size" json:"cache-size"` // 内存缓存最大条目数
+	CacheDir   string   `yaml:"cache-dir" json:"cache-dir"`   // 缓存持久化目录, 为空则只缓存在内存
 }
 
 type DNSRecord struct {
@@ -29,6 +33,7 @@ type Client struct {
 	resolvers  []Resolver  // DNS服务器
 	udpClient  *dns.Client // udp连接
 	tcpClient  *dns.Client // tcp连接
+	cache      *Cache      // 查询缓存
 }
 
 func NewClient(cfg *Config) *Client {
@@ -43,7 +48,7 @@ func NewClient(cfg *Config) *Client {
 	}
 
 	timeout := time.Duration(cfg.Timeout) * time.Second
-	return &Client{
+	c := &Client{
 		maxRetries: cfg.MaxRetries,
 		resolvers:  ParseResolvers(cfg.Resolvers),
 		udpClient: &dns.Client{
@@ -57,6 +62,18 @@ func NewClient(cfg *Config) *Client {
 			Dialer:  &net.Dialer{},
 		},
 	}
+
+	if cfg.Cache {
+		var store Store
+		if cfg.CacheDir != "" {
+			// 持久化目录不可用时退化为内存缓存
+			if fs, err := NewFileStore(cfg.CacheDir); err == nil {
+				store = fs
+			}
+		}
+		c.cache = NewCache(cfg.CacheSize, store)
+	}
+	return c
 }
 
 func (c *Client) Resolve(domain string) ([]string, error) {
@@ -94,7 +111,58 @@ func (c *Client) QueryMultiple(host string, types []uint16) ([]DNSRecord, error)
 	return res, nil
 }
 
+// Reverse 查询 IP 的 PTR 记录, 返回去掉末尾 '.' 的主机名
+func (c *Client) Reverse(ip string) ([]string, error) {
+	arpa, err := dns.ReverseAddr(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &dns.Msg{}
+	msg.SetQuestion(arpa, dns.TypePTR)
+	resp, err := c.do(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []string
+	for _, rr := range resp.Answer {
+		if ptr, ok := rr.(*dns.PTR); ok {
+			res = append(res, strings.TrimSuffix(ptr.Ptr, "."))
+		}
+	}
+	return res, nil
+}
+
+// TXT 查询 TXT 记录, 每条记录的多段字符串拼接为一个; 域名不存在时返回空结果
+func (c *Client) TXT(name string) ([]string, error) {
+	msg := &dns.Msg{}
+	msg.SetQuestion(dns.CanonicalName(name), dns.TypeTXT)
+	resp, err := c.do(msg)
+	if err != nil {
+		if resp != nil && resp.Rcode == dns.RcodeNameError {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var res []string
+	for _, rr := range resp.Answer {
+		if txt, ok := rr.(*dns.TXT); ok {
+			res = append(res, strings.Join(txt.Txt, ""))
+		}
+	}
+	return res, nil
+}
+
 func (c *Client) do(msg *dns.Msg) (*dns.Msg, error) {
+	if c.cache != nil {
+		return c.cache.do(msg, c.exchange)
+	}
+	return c.exchange(msg)
+}
+
+func (c *Client) exchange(msg *dns.Msg) (*dns.Msg, error) {
 	var resp *dns.Msg
 	var err error
 
@@ -0,0 +1,129 @@
+// Package mailaudit 审计域名的邮件安全记录: SPF、DMARC、DKIM、MTA-STS 和 TLS-RPT
+package mailaudit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/BreakOnCrash/opendast/dns/client"
+	"github.com/BreakOnCrash/opendast/pkg/httpx"
+)
+
+type Severity string
+
+const (
+	SeverityInfo     Severity = "info"
+	SeverityLow      Severity = "low"
+	SeverityMedium   Severity = "medium"
+	SeverityHigh     Severity = "high"
+	SeverityCritical Severity = "critical"
+)
+
+var ErrEmptyDomain = errors.New("empty domain")
+
+// DefaultSelectors 常见的 DKIM selector
+var DefaultSelectors = []string{
+	"default", "dkim", "mail", "email", "smtp",
+	"google", "selector1", "selector2", // Google Workspace, Microsoft 365
+	"k1", "k2", "mandrill", "mailjet", "mxvault",
+	"s1", "s2", "sig1", "everlytic", "zoho",
+}
+
+type Config struct {
+	Selectors []string `json:"selectors" yaml:"selectors"` // DKIM selector 字典, 为空使用 DefaultSelectors
+	Timeout   int      `json:"timeout" yaml:"timeout"`     // 获取 MTA-STS 策略的超时, 秒
+}
+
+type Finding struct {
+	Record   string   `json:"record"` // spf, dmarc, dkim, mta-sts, tls-rpt
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+}
+
+type Report struct {
+	Domain   string        `json:"domain"`
+	SPF      *SPFRecord    `json:"spf,omitempty"`
+	DMARC    *DMARCRecord  `json:"dmarc,omitempty"`
+	DKIM     []*DKIMRecord `json:"dkim,omitempty"`
+	MTASTS   *MTASTSRecord `json:"mta-sts,omitempty"`
+	TLSRPT   string        `json:"tls-rpt,omitempty"`
+	Findings []Finding     `json:"findings"`
+}
+
+func (r *Report) add(record string, severity Severity, format string, args ...any) {
+	r.Findings = append(r.Findings, Finding{
+		Record:   record,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// txtResolver 由 client.Client 实现
+type txtResolver interface {
+	TXT(name string) ([]string, error)
+}
+
+type Auditor struct {
+	cfg   *Config
+	dnsc  txtResolver
+	httpc *httpx.Client
+}
+
+func New(cfg *Config, client *client.Client) *Auditor {
+	if len(cfg.Selectors) == 0 {
+		cfg.Selectors = DefaultSelectors
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10
+	}
+
+	// RFC 8461: 获取策略时必须校验证书且不能跟随重定向
+	httpc, _ := httpx.New(&httpx.Config{
+		Timeout:    cfg.Timeout,
+		NoRedirect: true,
+		Verify:     true,
+		KeepAlive:  true,
+	})
+	return &Auditor{
+		cfg:   cfg,
+		dnsc:  client,
+		httpc: httpc,
+	}
+}
+
+func (a *Auditor) Audit(ctx context.Context, domain string) (*Report, error) {
+	domain = strings.ToLower(strings.Trim(strings.TrimSpace(domain), "."))
+	if domain == "" {
+		return nil, ErrEmptyDomain
+	}
+
+	r := &Report{Domain: domain, Findings: make([]Finding, 0)}
+	a.auditSPF(r)
+	a.auditDMARC(r)
+	a.auditDKIM(ctx, r)
+	a.auditMTASTS(ctx, r)
+	a.auditTLSRPT(r)
+	return r, nil
+}
+
+// lookup 查询以版本标签 version (如 v=spf1) 开头的 TXT 记录, 忽略大小写
+func (a *Auditor) lookup(name, version string) ([]string, error) {
+	txts, err := a.dnsc.TXT(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []string
+	for _, txt := range txts {
+		txt = strings.TrimSpace(txt)
+		if len(txt) < len(version) || !strings.EqualFold(txt[:len(version)], version) {
+			continue
+		}
+		if rest := txt[len(version):]; rest == "" || rest[0] == ' ' || rest[0] == ';' {
+			res = append(res, txt)
+		}
+	}
+	return res, nil
+}
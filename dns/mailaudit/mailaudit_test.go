@@ -0,0 +1,128 @@
+package mailaudit
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/BreakOnCrash/opendast/dns/client"
+)
+
+type fakeTXT map[string][]string
+
+func (f fakeTXT) TXT(name string) ([]string, error) {
+	return f[name], nil
+}
+
+func newTestAuditor(records fakeTXT) *Auditor {
+	a := New(&Config{Selectors: []string{"s1", "s2"}}, nil)
+	a.dnsc = records
+	return a
+}
+
+func hasFinding(r *Report, record string, severity Severity, substr string) bool {
+	for _, f := range r.Findings {
+		if f.Record == record && f.Severity == severity && strings.Contains(f.Message, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSPFLookups(t *testing.T) {
+	records := fakeTXT{
+		"example.com":   {"v=spf1 include:a.example.com include:b.example.com ~all", "google-site-verification=xxx"},
+		"a.example.com": {"v=spf1 a mx ip4:10.0.0.0/8 include:c.example.com -all"},
+		"b.example.com": {"v=spf1 exists:%{i}.x.example.com ptr a:mail.example.com mx:mx.example.com -all"},
+		"c.example.com": {"v=spf1 include:a.example.com redirect=d.example.com -all"},
+	}
+	a := newTestAuditor(records)
+	r := &Report{Domain: "example.com"}
+	a.auditSPF(r)
+
+	// include a, b, c (3) + a, mx (2) + exists, ptr, a, mx (4) + include a 循环 (1), 存在 all 时 redirect 不计数
+	if r.SPF == nil || r.SPF.Lookups != 10 {
+		t.Fatalf("got %+v, want 10 lookups", r.SPF)
+	}
+	if r.SPF.effectiveAll() != "~" || !hasFinding(r, "spf", SeverityLow, "softfail") {
+		t.Fatalf("missing softfail finding: %v", r.Findings)
+	}
+	if !hasFinding(r, "spf", SeverityMedium, "loop") || !hasFinding(r, "spf", SeverityLow, "ptr") {
+		t.Fatalf("missing loop/ptr findings: %v", r.Findings)
+	}
+	if hasFinding(r, "spf", SeverityHigh, "exceeding") {
+		t.Fatalf("unexpected lookup limit finding: %v", r.Findings)
+	}
+
+	records["b.example.com"] = append(records["b.example.com"][:0], "v=spf1 a a a a a a a a -all")
+	r = &Report{Domain: "example.com"}
+	a.auditSPF(r)
+	if !hasFinding(r, "spf", SeverityHigh, "exceeding") {
+		t.Fatalf("missing lookup limit finding: %v", r.Findings)
+	}
+}
+
+func TestSPFRedirect(t *testing.T) {
+	a := newTestAuditor(fakeTXT{
+		"example.com":      {"v=spf1 redirect=_spf.example.com"},
+		"_spf.example.com": {"v=spf1 +all"},
+	})
+	r := &Report{Domain: "example.com"}
+	a.auditSPF(r)
+	if !hasFinding(r, "spf", SeverityCritical, "+all") {
+		t.Fatalf("missing +all finding: %v", r.Findings)
+	}
+}
+
+func TestDMARC(t *testing.T) {
+	for _, tt := range []struct {
+		records  []string
+		severity Severity
+		substr   string
+	}{
+		{nil, SeverityHigh, "no DMARC"},
+		{[]string{"v=DMARC1; p=none; rua=mailto:a@example.com"}, SeverityMedium, "none"},
+		{[]string{"v=DMARC1; p=reject; sp=none; rua=mailto:a@example.com"}, SeverityMedium, "subdomain"},
+		{[]string{"v=DMARC1; p=reject; pct=20; rua=mailto:a@example.com"}, SeverityLow, "20%"},
+		{[]string{"v=DMARC1; p=reject"}, SeverityInfo, "rua"},
+		{[]string{"v=DMARC1; p=reject", "v=DMARC1; p=none"}, SeverityHigh, "multiple"},
+		{[]string{"v=DMARC1; p=bogus"}, SeverityHigh, "invalid"},
+	} {
+		a := newTestAuditor(fakeTXT{"_dmarc.example.com": tt.records})
+		r := &Report{Domain: "example.com"}
+		a.auditDMARC(r)
+		if !hasFinding(r, "dmarc", tt.severity, tt.substr) {
+			t.Errorf("%v: missing %s %q finding: %v", tt.records, tt.severity, tt.substr, r.Findings)
+		}
+	}
+}
+
+func TestDKIM(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+
+	a := newTestAuditor(fakeTXT{
+		"s1._domainkey.example.com": {"v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der)},
+		"s2._domainkey.example.com": {"v=DKIM1; p="},
+	})
+	r := &Report{Domain: "example.com"}
+	a.auditDKIM(context.Background(), r)
+	if len(r.DKIM) != 2 || r.DKIM[0].KeyBits != 1024 || !r.DKIM[1].Revoked {
+		t.Fatalf("unexpected dkim records: %+v", r.DKIM)
+	}
+	if !hasFinding(r, "dkim", SeverityLow, "1024-bit") {
+		t.Fatalf("missing key size finding: %v", r.Findings)
+	}
+}
+
+func TestAudit(t *testing.T) {
+	a := New(&Config{}, client.NewClient(&client.Config{}))
+	t.Log(a.Audit(context.TODO(), "google.com"))
+}
@@ -0,0 +1,275 @@
+package mailaudit
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// parseTags 解析 "k=v; k=v" 形式的标签列表 (DMARC, DKIM, MTA-STS, TLS-RPT)
+func parseTags(raw string) map[string]string {
+	tags := make(map[string]string)
+	for _, part := range strings.Split(raw, ";") {
+		k, v, ok := strings.Cut(part, "=")
+		if !ok {
+			continue
+		}
+		tags[strings.ToLower(strings.TrimSpace(k))] = strings.TrimSpace(v)
+	}
+	return tags
+}
+
+type DMARCRecord struct {
+	Raw             string `json:"raw"`
+	Policy          string `json:"policy"`
+	SubdomainPolicy string `json:"subdomain-policy,omitempty"`
+	Percent         int    `json:"percent"`
+	RUA             string `json:"rua,omitempty"`
+	RUF             string `json:"ruf,omitempty"`
+}
+
+func (a *Auditor) auditDMARC(r *Report) {
+	records, err := a.lookup("_dmarc."+r.Domain, "v=DMARC1")
+	if err != nil {
+		r.add("dmarc", SeverityInfo, "dmarc lookup failed: %v", err)
+		return
+	}
+	switch {
+	case len(records) == 0:
+		r.add("dmarc", SeverityHigh, "no DMARC record, receivers have no policy for spoofed mail")
+		return
+	case len(records) > 1:
+		r.add("dmarc", SeverityHigh, "multiple DMARC records (%d), receivers will ignore DMARC", len(records))
+		return
+	}
+
+	tags := parseTags(records[0])
+	d := &DMARCRecord{
+		Raw:             records[0],
+		Policy:          strings.ToLower(tags["p"]),
+		SubdomainPolicy: strings.ToLower(tags["sp"]),
+		Percent:         100,
+		RUA:             tags["rua"],
+		RUF:             tags["ruf"],
+	}
+	if pct, ok := tags["pct"]; ok {
+		if n, err := strconv.Atoi(pct); err == nil {
+			d.Percent = n
+		}
+	}
+	r.DMARC = d
+
+	switch d.Policy {
+	case "reject":
+	case "quarantine":
+		r.add("dmarc", SeverityLow, "DMARC policy is quarantine, spoofed mail is delivered to spam")
+	case "none":
+		r.add("dmarc", SeverityMedium, "DMARC policy is none (monitoring only)")
+	default:
+		r.add("dmarc", SeverityHigh, "DMARC policy is missing or invalid: %q", tags["p"])
+	}
+	if d.SubdomainPolicy == "none" && d.Policy != "none" {
+		r.add("dmarc", SeverityMedium, "DMARC subdomain policy is none, subdomains can be spoofed")
+	}
+	if d.Percent < 100 {
+		r.add("dmarc", SeverityLow, "DMARC policy applies to only %d%% of mail", d.Percent)
+	}
+	if d.RUA == "" {
+		r.add("dmarc", SeverityInfo, "DMARC has no aggregate report address (rua)")
+	}
+}
+
+type DKIMRecord struct {
+	Selector string `json:"selector"`
+	Raw      string `json:"raw"`
+	KeyType  string `json:"key-type"`
+	KeyBits  int    `json:"key-bits,omitempty"`
+	Revoked  bool   `json:"revoked,omitempty"`
+}
+
+func (a *Auditor) auditDKIM(ctx context.Context, r *Report) {
+	for _, sel := range a.cfg.Selectors {
+		if ctx.Err() != nil {
+			return
+		}
+
+		txts, err := a.dnsc.TXT(sel + "._domainkey." + r.Domain)
+		if err != nil {
+			continue
+		}
+		for _, txt := range txts {
+			tags := parseTags(txt)
+			p, ok := tags["p"]
+			if !ok {
+				continue
+			}
+
+			d := &DKIMRecord{Selector: sel, Raw: txt, KeyType: strings.ToLower(tags["k"])}
+			if d.KeyType == "" {
+				d.KeyType = "rsa"
+			}
+			r.DKIM = append(r.DKIM, d)
+
+			if p == "" {
+				d.Revoked = true
+				continue
+			}
+			bits, err := keyBits(strings.ReplaceAll(p, " ", ""))
+			if err != nil {
+				r.add("dkim", SeverityLow, "DKIM selector %s has an unparsable public key: %v", sel, err)
+				continue
+			}
+			d.KeyBits = bits
+			if d.KeyType == "rsa" && bits < 1024 {
+				r.add("dkim", SeverityHigh, "DKIM selector %s uses a weak %d-bit RSA key", sel, bits)
+			} else if d.KeyType == "rsa" && bits < 2048 {
+				r.add("dkim", SeverityLow, "DKIM selector %s uses a %d-bit RSA key, 2048 bits is recommended", sel, bits)
+			}
+			if strings.Contains(tags["t"], "y") {
+				r.add("dkim", SeverityLow, "DKIM selector %s is in testing mode (t=y)", sel)
+			}
+		}
+	}
+
+	if len(r.DKIM) == 0 {
+		r.add("dkim", SeverityInfo, "no DKIM key found for %d common selectors", len(a.cfg.Selectors))
+	}
+}
+
+func keyBits(p string) (int, error) {
+	der, err := base64.StdEncoding.DecodeString(p)
+	if err != nil {
+		return 0, err
+	}
+	// ed25519 公钥直接以 32 字节原始格式发布 (RFC 8463)
+	if len(der) == ed25519.PublicKeySize {
+		return 256, nil
+	}
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		// 部分记录直接发布 PKCS#1 RSA 公钥
+		if rsaPub, err1 := x509.ParsePKCS1PublicKey(der); err1 == nil {
+			return rsaPub.N.BitLen(), nil
+		}
+		return 0, err
+	}
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return k.N.BitLen(), nil
+	case *ecdsa.PublicKey:
+		return k.Curve.Params().BitSize, nil
+	case ed25519.PublicKey:
+		return 256, nil
+	}
+	return 0, fmt.Errorf("unsupported key type %T", pub)
+}
+
+type MTASTSRecord struct {
+	Raw     string   `json:"raw"`
+	ID      string   `json:"id"`
+	Mode    string   `json:"mode,omitempty"`
+	MaxAge  int      `json:"max-age,omitempty"`
+	MX      []string `json:"mx,omitempty"`
+	Fetched bool     `json:"fetched"`
+}
+
+func (a *Auditor) auditMTASTS(ctx context.Context, r *Report) {
+	records, err := a.lookup("_mta-sts."+r.Domain, "v=STSv1")
+	if err != nil {
+		r.add("mta-sts", SeverityInfo, "mta-sts lookup failed: %v", err)
+		return
+	}
+	if len(records) == 0 {
+		r.add("mta-sts", SeverityLow, "no MTA-STS record, inbound SMTP TLS can be downgraded")
+		return
+	}
+
+	m := &MTASTSRecord{Raw: records[0], ID: parseTags(records[0])["id"]}
+	r.MTASTS = m
+	if m.ID == "" {
+		r.add("mta-sts", SeverityLow, "MTA-STS record has no id")
+	}
+
+	if err := a.fetchSTSPolicy(ctx, r.Domain, m); err != nil {
+		r.add("mta-sts", SeverityMedium, "MTA-STS policy could not be fetched: %v", err)
+		return
+	}
+	switch m.Mode {
+	case "enforce":
+	case "testing":
+		r.add("mta-sts", SeverityLow, "MTA-STS policy is in testing mode")
+	case "none":
+		r.add("mta-sts", SeverityMedium, "MTA-STS policy mode is none")
+	default:
+		r.add("mta-sts", SeverityMedium, "MTA-STS policy mode is invalid: %q", m.Mode)
+	}
+	if len(m.MX) == 0 && m.Mode != "none" {
+		r.add("mta-sts", SeverityMedium, "MTA-STS policy lists no mx patterns")
+	}
+}
+
+// fetchSTSPolicy 获取并解析 https://mta-sts.<domain>/.well-known/mta-sts.txt
+func (a *Auditor) fetchSTSPolicy(ctx context.Context, domain string, m *MTASTSRecord) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://mta-sts."+domain+"/.well-known/mta-sts.txt", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := a.httpc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status code %d", resp.StatusCode)
+	}
+
+	// 策略文件最大 64KB
+	s := bufio.NewScanner(io.LimitReader(resp.Body, 64*1024))
+	for s.Scan() {
+		k, v, ok := strings.Cut(s.Text(), ":")
+		if !ok {
+			continue
+		}
+		v = strings.TrimSpace(v)
+		switch strings.ToLower(strings.TrimSpace(k)) {
+		case "mode":
+			m.Mode = strings.ToLower(v)
+		case "max_age":
+			m.MaxAge, _ = strconv.Atoi(v)
+		case "mx":
+			m.MX = append(m.MX, v)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	m.Fetched = true
+	return nil
+}
+
+func (a *Auditor) auditTLSRPT(r *Report) {
+	records, err := a.lookup("_smtp._tls."+r.Domain, "v=TLSRPTv1")
+	if err != nil {
+		r.add("tls-rpt", SeverityInfo, "tls-rpt lookup failed: %v", err)
+		return
+	}
+	if len(records) == 0 {
+		r.add("tls-rpt", SeverityInfo, "no TLS-RPT record, SMTP TLS failures are not reported")
+		return
+	}
+
+	r.TLSRPT = records[0]
+	if parseTags(records[0])["rua"] == "" {
+		r.add("tls-rpt", SeverityLow, "TLS-RPT record has no rua")
+	}
+}
@@ -0,0 +1,159 @@
+package mailaudit
+
+import (
+	"strings"
+)
+
+// MaxSPFLookups RFC 7208 4.6.4 规定的 DNS 查询上限
+const MaxSPFLookups = 10
+
+type SPFRecord struct {
+	Domain   string       `json:"domain"`
+	Raw      string       `json:"raw"`
+	All      string       `json:"all,omitempty"` // all 机制的限定符: + - ~ ?
+	Includes []*SPFRecord `json:"includes,omitempty"`
+	Redirect *SPFRecord   `json:"redirect,omitempty"`
+	Lookups  int          `json:"lookups"` // 展开后的总查询次数
+}
+
+type spfTerm struct {
+	qualifier byte
+	name      string
+	value     string
+}
+
+// parseSPF 解析 SPF 记录为机制和修饰符列表
+func parseSPF(raw string) []spfTerm {
+	fields := strings.Fields(raw)
+	if len(fields) == 0 {
+		return nil
+	}
+
+	terms := make([]spfTerm, 0, len(fields)-1)
+	for _, f := range fields[1:] {
+		t := spfTerm{qualifier: '+'}
+		switch f[0] {
+		case '+', '-', '~', '?':
+			t.qualifier = f[0]
+			f = f[1:]
+		}
+		if i := strings.IndexAny(f, ":="); i >= 0 {
+			t.name, t.value = strings.ToLower(f[:i]), f[i+1:]
+		} else if i := strings.IndexByte(f, '/'); i >= 0 {
+			t.name, t.value = strings.ToLower(f[:i]), f[i:]
+		} else {
+			t.name = strings.ToLower(f)
+		}
+		terms = append(terms, t)
+	}
+	return terms
+}
+
+type spfWalker struct {
+	a       *Auditor
+	r       *Report
+	lookups int
+	visited map[string]bool // 当前展开路径, 用于检测循环引用
+}
+
+// auditSPF 递归展开 include 和 redirect 并统计 DNS 查询次数
+func (a *Auditor) auditSPF(r *Report) {
+	records, err := a.lookup(r.Domain, "v=spf1")
+	if err != nil {
+		r.add("spf", SeverityInfo, "spf lookup failed: %v", err)
+		return
+	}
+	switch {
+	case len(records) == 0:
+		r.add("spf", SeverityHigh, "no SPF record, any host can send mail as %s", r.Domain)
+		return
+	case len(records) > 1:
+		r.add("spf", SeverityHigh, "multiple SPF records (%d), receivers will return permerror", len(records))
+	}
+
+	w := &spfWalker{a: a, r: r, visited: map[string]bool{r.Domain: true}}
+	spf := w.walk(r.Domain, records[0], 0)
+	spf.Lookups = w.lookups
+	r.SPF = spf
+
+	all := spf.effectiveAll()
+	switch all {
+	case "+":
+		r.add("spf", SeverityCritical, "SPF ends with +all, any host is authorized")
+	case "?":
+		r.add("spf", SeverityMedium, "SPF ends with ?all (neutral), spoofed mail is not rejected")
+	case "~":
+		r.add("spf", SeverityLow, "SPF ends with ~all (softfail), spoofed mail is usually accepted")
+	case "":
+		r.add("spf", SeverityMedium, "SPF has no all mechanism, unmatched senders default to neutral")
+	}
+	if w.lookups > MaxSPFLookups {
+		r.add("spf", SeverityHigh, "SPF requires %d DNS lookups, exceeding the limit of %d (permerror)", w.lookups, MaxSPFLookups)
+	}
+}
+
+func (w *spfWalker) walk(domain, raw string, depth int) *SPFRecord {
+	spf := &SPFRecord{Domain: domain, Raw: raw}
+
+	var redirect string
+	for _, t := range parseSPF(raw) {
+		switch t.name {
+		case "all":
+			spf.All = string(t.qualifier)
+		case "a", "mx", "exists":
+			w.lookups++
+		case "ptr":
+			w.lookups++
+			w.r.add("spf", SeverityLow, "%s uses the deprecated ptr mechanism", domain)
+		case "include":
+			w.lookups++
+			if inc := w.follow(t.value, depth); inc != nil {
+				spf.Includes = append(spf.Includes, inc)
+			}
+		case "redirect":
+			redirect = t.value
+		}
+	}
+
+	// RFC 7208 6.1: 存在 all 机制时忽略 redirect
+	if redirect != "" && spf.All == "" {
+		w.lookups++
+		spf.Redirect = w.follow(redirect, depth)
+	}
+	return spf
+}
+
+func (w *spfWalker) follow(target string, depth int) *SPFRecord {
+	target = strings.ToLower(strings.Trim(target, "."))
+	// 包含宏的域名无法静态展开
+	if target == "" || strings.Contains(target, "%") {
+		return nil
+	}
+	if w.visited[target] {
+		w.r.add("spf", SeverityMedium, "SPF include loop on %s", target)
+		return nil
+	}
+	// 超出上限后不再继续查询, 避免构造的记录放大查询量
+	if depth >= MaxSPFLookups || w.lookups > MaxSPFLookups {
+		return nil
+	}
+	w.visited[target] = true
+	defer delete(w.visited, target)
+
+	records, err := w.a.lookup(target, "v=spf1")
+	if err != nil || len(records) == 0 {
+		w.r.add("spf", SeverityMedium, "SPF include %s has no SPF record (permerror)", target)
+		return nil
+	}
+	return w.walk(target, records[0], depth+1)
+}
+
+// effectiveAll 返回最终生效的 all 限定符, 会沿 redirect 查找
+func (s *SPFRecord) effectiveAll() string {
+	for cur := s; cur != nil; cur = cur.Redirect {
+		if cur.All != "" {
+			return cur.All
+		}
+	}
+	return ""
+}
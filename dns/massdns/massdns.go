@@ -0,0 +1,466 @@
+// Package massdns 实现大规模异步 DNS 解析:
+// 少量 UDP socket 流水线发送, 接收端按 ID + 问题匹配应答, 时间轮处理超时重试
+package massdns
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/BreakOnCrash/opendast/dns/client"
+	"github.com/miekg/dns"
+)
+
+const (
+	DefaultSockets     = 4
+	DefaultConcurrency = 10000
+	DefaultTimeout     = 1500 // ms
+	DefaultMaxRetries  = 5
+
+	maxPendingPerSocket = 60000
+	wheelTick           = 10 * time.Millisecond
+)
+
+var (
+	ErrTimeout     = errors.New("query timeout, max retries exceeded")
+	ErrNoResolvers = errors.New("no udp resolvers available")
+	ErrNoFreeID    = errors.New("no free message id on socket")
+)
+
+type Config struct {
+	Resolvers   []string `yaml:"resolvers" json:"resolvers"`
+	Sockets     int      `yaml:"sockets" json:"sockets"`         // UDP socket 数量
+	Concurrency int      `yaml:"concurrency" json:"concurrency"` // 最大在途查询数
+	Rate        int      `yaml:"rate" json:"rate"`               // 每秒最大发送数, 0 表示不限制
+	Timeout     int      `yaml:"timeout" json:"timeout"`         // 单次查询超时, 毫秒
+	MaxRetries  *int     `yaml:"max-retries" json:"max-retries"` // 超时或 SERVFAIL/REFUSED 后的重试次数, 未设置时为 DefaultMaxRetries, 0 表示不重试
+}
+
+type Query struct {
+	Name string
+	Type uint16
+}
+
+type Result struct {
+	Query
+	Msg *dns.Msg
+	Err error
+}
+
+type pending struct {
+	query    Query
+	data     []byte // 已打包的查询报文
+	tries    int
+	resolver int
+	gen      uint64 // 每次发送取全局递增的值, 用于丢弃时间轮中过期的定时项
+}
+
+type conn struct {
+	pc      *net.UDPConn
+	mux     sync.Mutex
+	pending map[uint16]*pending
+	nextID  uint16
+	slots   chan struct{} // 单个 socket 的在途查询信号量, 保证有空闲 ID
+}
+
+type Resolver struct {
+	cfg        *Config
+	resolvers  []*net.UDPAddr
+	timeout    time.Duration
+	maxRetries int
+	maxPending int // 每个 socket 的最大在途查询数
+}
+
+func New(cfg *Config) (*Resolver, error) {
+	if cfg.Sockets <= 0 {
+		cfg.Sockets = DefaultSockets
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = DefaultConcurrency
+	}
+	if max := cfg.Sockets * maxPendingPerSocket; cfg.Concurrency > max {
+		cfg.Concurrency = max
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if cfg.MaxRetries == nil || *cfg.MaxRetries < 0 {
+		n := DefaultMaxRetries
+		cfg.MaxRetries = &n
+	}
+	if len(cfg.Resolvers) == 0 {
+		cfg.Resolvers = client.DefaultResolvers
+	}
+
+	var addrs []*net.UDPAddr
+	for _, r := range client.ParseResolvers(cfg.Resolvers) {
+		if r.Proto() != "udp" {
+			continue
+		}
+		addr, err := net.ResolveUDPAddr("udp", r.Addr())
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, addr)
+	}
+	if len(addrs) == 0 {
+		return nil, ErrNoResolvers
+	}
+
+	return &Resolver{
+		cfg:        cfg,
+		resolvers:  addrs,
+		timeout:    time.Duration(cfg.Timeout) * time.Millisecond,
+		maxRetries: *cfg.MaxRetries,
+		maxPending: maxPendingPerSocket,
+	}, nil
+}
+
+// Resolve 解析 queries 中的所有查询, 结果按应答到达顺序输出,
+// queries 关闭且全部查询完成 (或 ctx 取消) 后关闭结果通道
+func (r *Resolver) Resolve(ctx context.Context, queries <-chan Query) (<-chan Result, error) {
+	conns := make([]*conn, 0, r.cfg.Sockets)
+	for i := 0; i < r.cfg.Sockets; i++ {
+		pc, err := net.ListenUDP("udp", nil)
+		if err != nil {
+			for _, c := range conns {
+				c.pc.Close()
+			}
+			return nil, err
+		}
+		conns = append(conns, &conn{
+			pc:      pc,
+			pending: make(map[uint16]*pending),
+			nextID:  uint16(rand.Intn(1 << 16)),
+			slots:   make(chan struct{}, r.maxPending),
+		})
+	}
+
+	e := &engine{
+		Resolver: r,
+		ctx:      ctx,
+		conns:    conns,
+		results:  make(chan Result, 1024),
+		slots:    make(chan struct{}, r.cfg.Concurrency),
+		wheel:    newWheel(r.timeout),
+		stop:     make(chan struct{}),
+		ready:    make(chan struct{}, 1),
+		flush:    make(chan struct{}),
+	}
+	e.run(queries)
+	return e.results, nil
+}
+
+type engine struct {
+	*Resolver
+
+	ctx     context.Context
+	conns   []*conn
+	results chan Result
+	slots   chan struct{} // 在途查询信号量
+	wheel   *wheel
+	stop    chan struct{}
+	wg      sync.WaitGroup // 未输出结果的查询
+	gen     atomic.Uint64  // 发送代数, 同一 ID 被重用时旧的定时项也不会匹配
+
+	// 接收端只把结果放入 out, 由 deliverLoop 输出, 使用方读得慢时不影响读取 socket.
+	// 查询在结果输出后才释放在途位置, 所以 out 的长度不超过 Concurrency
+	outMux sync.Mutex
+	out    []Result
+	ready  chan struct{} // out 有新结果
+	flush  chan struct{} // 接收和超时循环已退出, 输出剩余结果后退出
+}
+
+func (e *engine) run(queries <-chan Query) {
+	var loops sync.WaitGroup
+
+	loops.Add(len(e.conns) + 1)
+	for _, c := range e.conns {
+		go func(c *conn) {
+			defer loops.Done()
+			e.recvLoop(c)
+		}(c)
+	}
+	go func() {
+		defer loops.Done()
+		e.timeoutLoop()
+	}()
+
+	delivered := make(chan struct{})
+	go func() {
+		defer close(delivered)
+		e.deliverLoop()
+	}()
+
+	go func() {
+		e.sendLoop(queries)
+
+		done := make(chan struct{})
+		go func() {
+			e.wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-e.ctx.Done():
+		}
+
+		close(e.stop)
+		for _, c := range e.conns {
+			c.pc.Close()
+		}
+		loops.Wait()
+		close(e.flush)
+		<-delivered
+
+		// ctx 取消时还在等待应答的查询不再输出, 结束计数以便等待的协程退出
+		for _, c := range e.conns {
+			c.mux.Lock()
+			for id := range c.pending {
+				delete(c.pending, id)
+				e.wg.Done()
+			}
+			c.mux.Unlock()
+		}
+		<-done
+		close(e.results)
+	}()
+}
+
+func (e *engine) sendLoop(queries <-chan Query) {
+	limit := newLimiter(e.cfg.Rate)
+
+	for i := 0; ; i++ {
+		var (
+			q  Query
+			ok bool
+		)
+		select {
+		case q, ok = <-queries:
+			if !ok {
+				return
+			}
+		case <-e.ctx.Done():
+			return
+		}
+
+		select {
+		case e.slots <- struct{}{}:
+		case <-e.ctx.Done():
+			return
+		}
+		if !limit.wait(e.ctx) {
+			<-e.slots
+			return
+		}
+
+		c, ok := e.acquire(i)
+		if !ok {
+			<-e.slots
+			return
+		}
+
+		e.wg.Add(1)
+		p := &pending{query: q, resolver: i % len(e.resolvers), gen: e.gen.Add(1)}
+
+		c.mux.Lock()
+		id, err := c.allocID()
+		if err != nil {
+			c.mux.Unlock()
+			<-c.slots
+			e.finish(Result{Query: q, Err: err})
+			continue
+		}
+		msg := &dns.Msg{}
+		msg.Id = id
+		msg.RecursionDesired = true
+		msg.Question = []dns.Question{{Name: dns.CanonicalName(q.Name), Qtype: q.Type, Qclass: dns.ClassINET}}
+		data, err := msg.Pack()
+		if err != nil {
+			c.mux.Unlock()
+			<-c.slots
+			e.finish(Result{Query: q, Err: err})
+			continue
+		}
+		p.data = data
+		c.pending[id] = p
+		gen, addr := p.gen, e.resolvers[p.resolver]
+		c.mux.Unlock()
+
+		e.send(c, id, data, gen, addr)
+	}
+}
+
+// acquire 从第 i 个开始选择有空闲位置的 socket, 都已满时等待第 i 个,
+// 避免慢的解析服务器占满单个 socket 的 ID 空间
+func (e *engine) acquire(i int) (*conn, bool) {
+	for j := range e.conns {
+		c := e.conns[(i+j)%len(e.conns)]
+		select {
+		case c.slots <- struct{}{}:
+			return c, true
+		default:
+		}
+	}
+
+	c := e.conns[i%len(e.conns)]
+	select {
+	case c.slots <- struct{}{}:
+		return c, true
+	case <-e.ctx.Done():
+		return nil, false
+	}
+}
+
+// send 发送报文并登记超时, 参数需在持有 c.mux 时从 pending 中取出
+func (e *engine) send(c *conn, id uint16, data []byte, gen uint64, addr *net.UDPAddr) {
+	e.wheel.add(timer{conn: c, id: id, gen: gen})
+	// UDP 发送失败与丢包同等处理, 由超时重试兜底
+	_, _ = c.pc.WriteToUDP(data, addr)
+}
+
+func (e *engine) recvLoop(c *conn) {
+	buf := make([]byte, dns.MaxMsgSize)
+	for {
+		n, _, err := c.pc.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+
+		msg := &dns.Msg{}
+		if err := msg.Unpack(buf[:n]); err != nil || !msg.Response || len(msg.Question) != 1 {
+			continue
+		}
+
+		c.mux.Lock()
+		p, ok := c.pending[msg.Id]
+		if !ok || !match(p.query, msg.Question[0]) {
+			c.mux.Unlock()
+			continue
+		}
+		if (msg.Rcode == dns.RcodeServerFailure || msg.Rcode == dns.RcodeRefused) && p.tries < e.maxRetries {
+			e.retry(p)
+			gen, addr := p.gen, e.resolvers[p.resolver]
+			c.mux.Unlock()
+			e.send(c, msg.Id, p.data, gen, addr)
+			continue
+		}
+		delete(c.pending, msg.Id)
+		c.mux.Unlock()
+		<-c.slots
+
+		e.finish(Result{Query: p.query, Msg: msg})
+	}
+}
+
+func (e *engine) timeoutLoop() {
+	ticker := time.NewTicker(wheelTick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-e.stop:
+			return
+		}
+
+		for _, t := range e.wheel.advance() {
+			c := t.conn
+			c.mux.Lock()
+			p, ok := c.pending[t.id]
+			if !ok || p.gen != t.gen {
+				c.mux.Unlock()
+				continue
+			}
+			if p.tries < e.maxRetries {
+				e.retry(p)
+				gen, addr := p.gen, e.resolvers[p.resolver]
+				c.mux.Unlock()
+				e.send(c, t.id, p.data, gen, addr)
+				continue
+			}
+			delete(c.pending, t.id)
+			c.mux.Unlock()
+			<-c.slots
+
+			e.finish(Result{Query: p.query, Err: ErrTimeout})
+		}
+	}
+}
+
+// retry 切换到下一个解析服务器, 调用方需持有 c.mux
+func (e *engine) retry(p *pending) {
+	p.tries++
+	p.gen = e.gen.Add(1)
+	p.resolver = (p.resolver + 1) % len(e.resolvers)
+}
+
+// finish 将结果交给 deliverLoop, 不会阻塞
+func (e *engine) finish(res Result) {
+	e.outMux.Lock()
+	e.out = append(e.out, res)
+	e.outMux.Unlock()
+	select {
+	case e.ready <- struct{}{}:
+	default:
+	}
+}
+
+// deliverLoop 按完成顺序输出结果, 输出后释放在途位置
+func (e *engine) deliverLoop() {
+	for {
+		select {
+		case <-e.ready:
+			e.deliver()
+		case <-e.flush:
+			e.deliver()
+			return
+		}
+	}
+}
+
+// deliver 输出 out 中的所有结果, ctx 取消后丢弃
+func (e *engine) deliver() {
+	for {
+		e.outMux.Lock()
+		batch := e.out
+		e.out = nil
+		e.outMux.Unlock()
+		if len(batch) == 0 {
+			return
+		}
+
+		for _, res := range batch {
+			select {
+			case e.results <- res:
+			case <-e.ctx.Done():
+			}
+			<-e.slots
+			e.wg.Done()
+		}
+	}
+}
+
+// allocID 分配未被占用的报文 ID, 调用方需持有 c.mux
+func (c *conn) allocID() (uint16, error) {
+	for i := 0; i < 1<<16; i++ {
+		c.nextID++
+		if _, used := c.pending[c.nextID]; !used {
+			return c.nextID, nil
+		}
+	}
+	return 0, ErrNoFreeID
+}
+
+func match(q Query, question dns.Question) bool {
+	return question.Qtype == q.Type &&
+		strings.EqualFold(question.Name, dns.CanonicalName(q.Name))
+}
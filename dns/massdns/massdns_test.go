@@ -0,0 +1,349 @@
+package massdns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// startServer 启动本地 UDP DNS 服务, 前 drops 个问题的第一次请求直接丢弃以触发重试
+func startServer(t testing.TB, drops int) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mux     sync.Mutex
+		seen    = make(map[string]bool)
+		dropped int
+	)
+	server := &dns.Server{
+		PacketConn: pc,
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			q := r.Question[0]
+			mux.Lock()
+			drop := !seen[q.Name] && dropped < drops
+			if drop {
+				seen[q.Name] = true
+				dropped++
+			}
+			mux.Unlock()
+			if drop {
+				return
+			}
+
+			m := new(dns.Msg)
+			m.SetReply(r)
+			if q.Name == "nx.test." {
+				m.Rcode = dns.RcodeNameError
+			} else {
+				rr, _ := dns.NewRR(q.Name + " 60 IN A 127.0.0.1")
+				m.Answer = append(m.Answer, rr)
+			}
+			w.WriteMsg(m)
+		}),
+	}
+	go server.ActivateAndServe()
+	t.Cleanup(func() { server.Shutdown() })
+
+	return pc.LocalAddr().String()
+}
+
+func TestResolve(t *testing.T) {
+	addr := startServer(t, 100)
+
+	// 本地服务的 UDP 接收缓冲区有限, 控制在途数量避免内核丢包
+	r, err := New(&Config{
+		Resolvers:   []string{addr},
+		Timeout:     100,
+		Concurrency: 256,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const total = 5000
+	queries := make(chan Query)
+	go func() {
+		defer close(queries)
+		for i := 0; i < total; i++ {
+			queries <- Query{Name: fmt.Sprintf("n%d.test", i), Type: dns.TypeA}
+		}
+		queries <- Query{Name: "nx.test", Type: dns.TypeA}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	results, err := r.Resolve(ctx, queries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var answered, nx int
+	for res := range results {
+		if res.Err != nil {
+			t.Fatalf("%s: %v", res.Name, res.Err)
+		}
+		switch res.Msg.Rcode {
+		case dns.RcodeSuccess:
+			if len(res.Msg.Answer) != 1 {
+				t.Fatalf("%s: unexpected answer %v", res.Name, res.Msg.Answer)
+			}
+			answered++
+		case dns.RcodeNameError:
+			nx++
+		}
+	}
+	if answered != total || nx != 1 {
+		t.Fatalf("answered %d nxdomain %d, want %d 1", answered, nx, total)
+	}
+	t.Logf("%d queries in %s", total+1, time.Since(start))
+}
+
+func TestTimeout(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	retries := 2
+	r, err := New(&Config{
+		Resolvers:  []string{pc.LocalAddr().String()},
+		Timeout:    20,
+		MaxRetries: &retries,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queries := make(chan Query, 1)
+	queries <- Query{Name: "example.com", Type: dns.TypeA}
+	close(queries)
+
+	results, err := r.Resolve(context.Background(), queries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, ok := <-results
+	if !ok || res.Err != ErrTimeout {
+		t.Fatalf("got %v, want %v", res.Err, ErrTimeout)
+	}
+	if _, ok := <-results; ok {
+		t.Fatal("results should be closed")
+	}
+}
+
+func TestNoRetries(t *testing.T) {
+	addr := startServer(t, 1)
+
+	// MaxRetries 为 0 时第一次请求被丢弃即超时, 未设置时使用默认重试次数
+	for _, tt := range []struct {
+		retries *int
+		want    error
+	}{
+		{new(int), ErrTimeout},
+		{nil, nil},
+	} {
+		r, err := New(&Config{Resolvers: []string{addr}, Timeout: 50, MaxRetries: tt.retries})
+		if err != nil {
+			t.Fatal(err)
+		}
+		queries := make(chan Query, 1)
+		queries <- Query{Name: "retry.test", Type: dns.TypeA}
+		close(queries)
+		results, err := r.Resolve(context.Background(), queries)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res := <-results; res.Err != tt.want {
+			t.Fatalf("max retries %d: got %v, want %v", *r.cfg.MaxRetries, res.Err, tt.want)
+		}
+	}
+}
+
+func TestCancel(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	before := runtime.NumGoroutine()
+	r, err := New(&Config{Resolvers: []string{pc.LocalAddr().String()}, Timeout: 5000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	queries := make(chan Query, 10)
+	for i := 0; i < 10; i++ {
+		queries <- Query{Name: fmt.Sprintf("n%d.test", i), Type: dns.TypeA}
+	}
+	close(queries)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	results, err := r.Resolve(ctx, queries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(50*time.Millisecond, cancel)
+	for range results {
+	}
+
+	// 取消后所有协程退出
+	for deadline := time.Now().Add(5 * time.Second); runtime.NumGoroutine() > before; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines left, %d before", runtime.NumGoroutine(), before)
+		}
+	}
+}
+
+func TestSlowConsumer(t *testing.T) {
+	addr := startServer(t, 0)
+
+	// 使用方暂停读取时仍然读取 socket, 应答不会因为接收缓冲区满被丢弃而超时
+	retries := 0
+	r, err := New(&Config{
+		Resolvers:   []string{addr},
+		Sockets:     1,
+		Timeout:     1000,
+		MaxRetries:  &retries,
+		Concurrency: 3000,
+		Rate:        5000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 超过结果通道的缓冲
+	const total = 3000
+	queries := make(chan Query, total)
+	for i := 0; i < total; i++ {
+		queries <- Query{Name: fmt.Sprintf("n%d.test", i), Type: dns.TypeA}
+	}
+	close(queries)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	results, err := r.Resolve(ctx, queries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Second)
+
+	n := 0
+	for res := range results {
+		if res.Err != nil {
+			t.Fatalf("%s: %v", res.Name, res.Err)
+		}
+		n++
+	}
+	if n != total {
+		t.Fatalf("got %d results, want %d", n, total)
+	}
+}
+
+func TestPendingPerSocket(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	// 不应答的解析服务器占满单个 socket 时, 后续查询等待空闲位置而不是分配重复的 ID
+	retries := 1
+	r, err := New(&Config{
+		Resolvers:  []string{pc.LocalAddr().String()},
+		Sockets:    1,
+		Timeout:    20,
+		MaxRetries: &retries,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.maxPending = 4
+
+	const total = 20
+	queries := make(chan Query, total)
+	for i := 0; i < total; i++ {
+		queries <- Query{Name: fmt.Sprintf("n%d.test", i), Type: dns.TypeA}
+	}
+	close(queries)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	results, err := r.Resolve(ctx, queries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for res := range results {
+		if res.Err != ErrTimeout {
+			t.Fatalf("%s: got %v, want %v", res.Name, res.Err, ErrTimeout)
+		}
+		n++
+	}
+	if n != total {
+		t.Fatalf("got %d results, want %d", n, total)
+	}
+}
+
+func TestAllocID(t *testing.T) {
+	c := &conn{pending: make(map[uint16]*pending)}
+	for i := 0; i < 1<<16-1; i++ {
+		c.pending[uint16(i)] = &pending{}
+	}
+	if id, err := c.allocID(); err != nil || id != 1<<16-1 {
+		t.Fatalf("got %d %v, want the last free id", id, err)
+	}
+	c.pending[1<<16-1] = &pending{}
+	if _, err := c.allocID(); err != ErrNoFreeID {
+		t.Fatalf("got %v, want %v", err, ErrNoFreeID)
+	}
+}
+
+// BenchmarkResolve 本地 UDP 服务的吞吐量, 以 qps 报告
+func BenchmarkResolve(b *testing.B) {
+	addr := startServer(b, 0)
+	r, err := New(&Config{
+		Resolvers:   []string{addr},
+		Timeout:     500,
+		Concurrency: 512,
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	queries := make(chan Query, 1024)
+	go func() {
+		defer close(queries)
+		for i := 0; i < b.N; i++ {
+			queries <- Query{Name: fmt.Sprintf("n%d.test", i), Type: dns.TypeA}
+		}
+	}()
+
+	b.ResetTimer()
+	start := time.Now()
+	results, err := r.Resolve(context.Background(), queries)
+	if err != nil {
+		b.Fatal(err)
+	}
+	failed := 0
+	for res := range results {
+		if res.Err != nil {
+			failed++
+		}
+	}
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "qps")
+	if failed > 0 {
+		b.Logf("%d of %d queries failed", failed, b.N)
+	}
+}
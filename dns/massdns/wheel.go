@@ -0,0 +1,100 @@
+package massdns
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type timer struct {
+	conn *conn
+	id   uint16
+	gen  uint64
+}
+
+// wheel 简单时间轮, 每个槽位对应一个 wheelTick
+type wheel struct {
+	mux   sync.Mutex
+	slots [][]timer
+	pos   int
+	ticks int // 超时对应的槽位跨度
+}
+
+func newWheel(timeout time.Duration) *wheel {
+	ticks := int(timeout / wheelTick)
+	if ticks < 1 {
+		ticks = 1
+	}
+	return &wheel{
+		slots: make([][]timer, ticks+1),
+		ticks: ticks,
+	}
+}
+
+func (w *wheel) add(t timer) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	idx := (w.pos + w.ticks) % len(w.slots)
+	w.slots[idx] = append(w.slots[idx], t)
+}
+
+// advance 前进一格并返回到期的定时项
+func (w *wheel) advance() []timer {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	w.pos = (w.pos + 1) % len(w.slots)
+	expired := w.slots[w.pos]
+	w.slots[w.pos] = nil
+	return expired
+}
+
+// limiter 令牌桶限速, rate <= 0 时不限速
+type limiter struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(rate int) *limiter {
+	// 允许 10ms 的突发
+	burst := float64(rate) / 100
+	if burst < 1 {
+		burst = 1
+	}
+	return &limiter{
+		rate:   float64(rate),
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+func (l *limiter) wait(ctx context.Context) bool {
+	if l.rate <= 0 {
+		return true
+	}
+
+	for {
+		now := time.Now()
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+		l.last = now
+
+		if l.tokens >= 1 {
+			l.tokens--
+			return true
+		}
+
+		d := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
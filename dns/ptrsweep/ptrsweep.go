@@ -0,0 +1,189 @@
+package ptrsweep
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/BreakOnCrash/opendast/dns/client"
+)
+
+const (
+	DefaultPool     = 20
+	DefaultMaxHosts = 1 << 16
+)
+
+var ErrTooManyHosts = errors.New("cidr contains too many hosts")
+
+type Config struct {
+	Pool     int      `json:"pool" yaml:"pool"`           // 处理池子数量
+	MaxHosts int      `json:"max-hosts" yaml:"max-hosts"` // 单个网段最多展开的 IP 数量
+	Suffixes []string `json:"suffixes" yaml:"suffixes"`   // 候选域名后缀过滤, 为空则不过滤
+}
+
+type Sweeper struct {
+	cfg  *Config
+	dnsc *client.Client
+}
+
+func New(cfg *Config, client *client.Client) *Sweeper {
+	if cfg.Pool <= 0 {
+		cfg.Pool = DefaultPool
+	}
+	if cfg.MaxHosts <= 0 {
+		cfg.MaxHosts = DefaultMaxHosts
+	}
+
+	return &Sweeper{
+		cfg:  cfg,
+		dnsc: client,
+	}
+}
+
+// Sweep 并发查询网段内每个 IP 的 PTR 记录, 返回 IP → 主机名 映射
+// targets 支持 CIDR (10.0.0.0/24) 和单个 IP
+func (s *Sweeper) Sweep(ctx context.Context, targets ...string) (map[string][]string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	prefixes := make([]netip.Prefix, 0, len(targets))
+	for _, target := range targets {
+		p, err := parsePrefix(target)
+		if err != nil {
+			return nil, err
+		}
+		if hosts(p) > s.cfg.MaxHosts {
+			return nil, ErrTooManyHosts
+		}
+		prefixes = append(prefixes, p)
+	}
+
+	ips := produceIPs(ctx, prefixes)
+
+	type result struct {
+		ip    string
+		names []string
+	}
+
+	var wg sync.WaitGroup
+	out := make(chan result)
+
+	wg.Add(s.cfg.Pool)
+	for i := 0; i < s.cfg.Pool; i++ {
+		go func(ctx context.Context) {
+			defer wg.Done()
+
+			for {
+				select {
+				case ip, ok := <-ips:
+					if !ok {
+						return
+					}
+					names, err := s.dnsc.Reverse(ip.String())
+					if err != nil || len(names) == 0 {
+						continue
+					}
+					select {
+					case out <- result{ip: ip.String(), names: names}:
+					case <-ctx.Done():
+						return
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(ctx)
+	}
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	res := make(map[string][]string)
+	for r := range out {
+		res[r.ip] = r.names
+	}
+
+	return res, ctx.Err()
+}
+
+// Domains 从扫描结果中提取候选域名, 按 Config.Suffixes 过滤并去重
+func (s *Sweeper) Domains(res map[string][]string) []string {
+	seen := make(map[string]struct{})
+	for _, names := range res {
+		for _, name := range names {
+			name = strings.ToLower(strings.TrimSuffix(name, "."))
+			if name == "" || !s.inScope(name) {
+				continue
+			}
+			seen[name] = struct{}{}
+		}
+	}
+
+	domains := make([]string, 0, len(seen))
+	for name := range seen {
+		domains = append(domains, name)
+	}
+	sort.Strings(domains)
+	return domains
+}
+
+func (s *Sweeper) inScope(name string) bool {
+	if len(s.cfg.Suffixes) == 0 {
+		return true
+	}
+	for _, suffix := range s.cfg.Suffixes {
+		suffix = strings.ToLower(strings.Trim(suffix, "."))
+		if name == suffix || strings.HasSuffix(name, "."+suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func parsePrefix(target string) (netip.Prefix, error) {
+	if strings.Contains(target, "/") {
+		p, err := netip.ParsePrefix(target)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return p.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(target)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// hosts 返回网段内的 IP 数量, 超过 int 范围时返回最大值
+func hosts(p netip.Prefix) int {
+	bits := p.Addr().BitLen() - p.Bits()
+	if bits >= 62 {
+		return int(^uint(0) >> 1)
+	}
+	return 1 << bits
+}
+
+func produceIPs(ctx context.Context, prefixes []netip.Prefix) <-chan netip.Addr {
+	ips := make(chan netip.Addr)
+	go func() {
+		defer close(ips)
+
+		for _, p := range prefixes {
+			for addr := p.Addr(); addr.IsValid() && p.Contains(addr); addr = addr.Next() {
+				select {
+				case ips <- addr:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return ips
+}
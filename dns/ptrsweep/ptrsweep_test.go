@@ -0,0 +1,45 @@
+package ptrsweep
+
+import (
+	"context"
+	"testing"
+
+	"github.com/BreakOnCrash/opendast/dns/client"
+)
+
+func TestSweep(t *testing.T) {
+	s := New(&Config{}, client.NewClient(&client.Config{}))
+
+	res, err := s.Sweep(context.TODO(), "8.8.8.0/30", "1.1.1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(res)
+	t.Log(s.Domains(res))
+}
+
+func TestDomains(t *testing.T) {
+	s := New(&Config{Suffixes: []string{"example.com", ".corp.test."}}, nil)
+
+	got := s.Domains(map[string][]string{
+		"10.0.0.1": {"www.example.com", "mail.EXAMPLE.com."},
+		"10.0.0.2": {"example.com", "notexample.com"},
+		"10.0.0.3": {"db.corp.test", "static.cdn.net"},
+	})
+	want := []string{"db.corp.test", "example.com", "mail.example.com", "www.example.com"}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestTooManyHosts(t *testing.T) {
+	s := New(&Config{MaxHosts: 256}, nil)
+	if _, err := s.Sweep(context.TODO(), "10.0.0.0/23"); err != ErrTooManyHosts {
+		t.Fatalf("got %v, want %v", err, ErrTooManyHosts)
+	}
+}
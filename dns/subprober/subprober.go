@@ -10,13 +10,19 @@ import (
 	"sync"
 
 	"github.com/BreakOnCrash/opendast/dns/client"
+	"github.com/BreakOnCrash/opendast/dns/massdns"
+	"github.com/BreakOnCrash/opendast/other/tire"
+	"github.com/miekg/dns"
 )
 
 const DefaultPool = 10
 
 type Config struct {
-	Dict string `json:"dict" yaml:"dict"` // 字典文件路径
-	Pool int    `json:"pool" yaml:"pool"` // 处理池子数量
+	Dict string          `json:"dict" yaml:"dict"` // 字典文件路径
+	Pool int             `json:"pool" yaml:"pool"` // 处理池子数量
+	Mass *massdns.Config `json:"mass" yaml:"mass"` // 设置后使用异步解析引擎, Pool 不再生效
+
+	Scope *tire.DomainTrie `json:"-" yaml:"-"` // 扫描范围, 范围外的子域名不解析
 }
 
 type Prober struct {
@@ -44,6 +50,10 @@ func (p *Prober) Probe(ctx context.Context, domain string) ([]string, error) {
 		return nil, err
 	}
 
+	if p.cfg.Mass != nil {
+		return p.probeMass(ctx, domain, subs)
+	}
+
 	var wg sync.WaitGroup
 	out := make(chan string)
 
@@ -59,6 +69,9 @@ func (p *Prober) Probe(ctx context.Context, domain string) ([]string, error) {
 						return
 					}
 					sub = fmt.Sprintf("%s.%s", sub, domain)
+					if !p.cfg.Scope.Allow(sub) {
+						continue
+					}
 					res, err := p.dnsc.Resolve(sub)
 					if err != nil {
 						continue
@@ -86,6 +99,56 @@ func (p *Prober) Probe(ctx context.Context, domain string) ([]string, error) {
 	return res, nil
 }
 
+// probeMass 使用 massdns 引擎同时查询 A 和 AAAA 记录
+func (p *Prober) probeMass(ctx context.Context, domain string, subs <-chan string) ([]string, error) {
+	r, err := massdns.New(p.cfg.Mass)
+	if err != nil {
+		return nil, err
+	}
+
+	queries := make(chan massdns.Query)
+	go func() {
+		defer close(queries)
+
+		for sub := range subs {
+			if sub == "" {
+				continue
+			}
+			name := fmt.Sprintf("%s.%s", sub, domain)
+			if !p.cfg.Scope.Allow(name) {
+				continue
+			}
+			for _, t := range []uint16{dns.TypeA, dns.TypeAAAA} {
+				select {
+				case queries <- massdns.Query{Name: name, Type: t}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	results, err := r.Resolve(ctx, queries)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]struct{})
+	res := make([]string, 0)
+	for r := range results {
+		if r.Err != nil || r.Msg.Rcode != dns.RcodeSuccess || len(r.Msg.Answer) == 0 {
+			continue
+		}
+		if _, ok := seen[r.Name]; ok {
+			continue
+		}
+		seen[r.Name] = struct{}{}
+		res = append(res, r.Name)
+	}
+
+	return res, nil
+}
+
 func (p *Prober) productSubs(ctx context.Context) (<-chan string, error) {
 	// TODO
 	// 目前将字典内容一次性读取出来
@@ -0,0 +1,374 @@
+package dsl
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/BreakOnCrash/opendast/other/tire"
+)
+
+type opcode uint8
+
+const (
+	opText      opcode = iota // acc = 文本比较
+	opNumber                  // acc = 数值比较
+	opIn                      // acc = 字段在列表中
+	opNot                     // acc = !acc
+	opJumpFalse               // acc 为 false 时跳转, 用于 && 短路
+	opJumpTrue                // acc 为 true 时跳转, 用于 || 短路
+)
+
+// instr 规则程序的一条指令, 所有指令读写同一个布尔累加器
+type instr struct {
+	op      opcode
+	cmp     string // 比较运算符
+	slot    int    // 文本字段在 RuleSet.fields 中的下标
+	field   string // 数值字段
+	fold    bool   // 不区分大小写, 比较小写后的字段
+	text    string // fold 时已转为小写
+	regex   *regexp.Regexp
+	values  []string // opIn 的文本, folds[i] 时已转为小写
+	folds   []bool
+	numbers []int
+	jump    int
+}
+
+// program 编译后的规则, 顺序执行指令, 结束时累加器即为结果
+type program []instr
+
+// fieldRef 文本字段, key 为 header["Name"] 中的头名称或 meta["name"] 中的 name
+type fieldRef struct {
+	name, key string
+}
+
+// literal 规则匹配时字段中必然出现的小写常量
+type literal struct {
+	slot int
+	text string
+}
+
+// RuleSet 编译后的规则集合.
+// 每个样本的字段只转换一次小写; 所有规则中必须出现的常量放入同一个 Aho-Corasick 自动机,
+// 每个有常量的字段扫描一次, 只有常量出现在对应字段中的规则 (以及提取不出常量的规则) 才会执行
+type RuleSet struct {
+	programs []program
+	fields   []fieldRef
+	slots    map[fieldRef]int
+
+	always  []int             // 没有必需常量的规则
+	matcher *tire.Trie        // 所有字段的常量
+	scan    []int             // 有常量的字段, 按 slot 排序
+	needs   map[literal][]int // 常量 → 需要它的规则
+}
+
+// NewRuleSet 编译规则集合, Match 返回的下标与 rules 对应
+func NewRuleSet(rules []*Rule) (*RuleSet, error) {
+	s := &RuleSet{
+		slots:   make(map[fieldRef]int),
+		matcher: tire.NewTrie(),
+		needs:   make(map[literal][]int),
+	}
+	scanned := make(map[int]bool)
+	for i, r := range rules {
+		if r == nil || r.root == nil {
+			return nil, fmt.Errorf("rule %d: empty rule", i)
+		}
+		var p program
+		if err := s.emit(&p, r.root); err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+		s.programs = append(s.programs, p)
+
+		lits := s.literals(r.root)
+		if lits == nil {
+			s.always = append(s.always, i)
+			continue
+		}
+		for _, l := range lits {
+			s.matcher.Insert(l.text)
+			if !scanned[l.slot] {
+				scanned[l.slot] = true
+				s.scan = append(s.scan, l.slot)
+			}
+			s.needs[l] = append(s.needs[l], i)
+		}
+	}
+	s.matcher.Build()
+	sort.Ints(s.scan)
+	return s, nil
+}
+
+// Len 返回规则数量
+func (s *RuleSet) Len() int {
+	return len(s.programs)
+}
+
+func (s *RuleSet) slot(name, key string) int {
+	ref := fieldRef{name: name, key: key}
+	if i, ok := s.slots[ref]; ok {
+		return i
+	}
+	s.fields = append(s.fields, ref)
+	s.slots[ref] = len(s.fields) - 1
+	return len(s.fields) - 1
+}
+
+// emit 将表达式编译为指令追加到 p
+func (s *RuleSet) emit(p *program, expr Expr) error {
+	switch e := expr.(type) {
+	case *matchExpr:
+		if !textFields[e.left] {
+			return fmt.Errorf("unknown text field %s", e.left)
+		}
+		if !textOps[e.op] {
+			return fmt.Errorf("unknown text operator %s", e.op)
+		}
+		// 正则已按需用 (?i) 编译, 比较原始字段
+		in := instr{op: opText, cmp: e.op, slot: s.slot(e.left, e.key), fold: !e.cs && e.op != tokenRegexEqual, text: e.right, regex: e.cacheRegx}
+		if in.fold {
+			in.text = strings.ToLower(in.text)
+		}
+		if e.op == tokenRegexEqual && in.regex == nil {
+			return fmt.Errorf("regex %q is not compiled", e.right)
+		}
+		*p = append(*p, in)
+	case *matchNumberExpr:
+		if !numberFields[e.left] {
+			return fmt.Errorf("unknown numeric field %s", e.left)
+		}
+		if !numberOps[e.op] {
+			return fmt.Errorf("unknown numeric operator %s", e.op)
+		}
+		*p = append(*p, instr{op: opNumber, cmp: e.op, field: e.left, numbers: []int{e.right}})
+	case *inExpr:
+		in := instr{op: opIn}
+		switch {
+		case numberFields[e.left]:
+			in.field = e.left
+			for _, v := range e.values {
+				in.numbers = append(in.numbers, v.number)
+			}
+		case textFields[e.left]:
+			in.slot = s.slot(e.left, e.key)
+			for _, v := range e.values {
+				text := v.content
+				if !v.cs {
+					text = strings.ToLower(text)
+				}
+				in.values = append(in.values, text)
+				in.folds = append(in.folds, !v.cs)
+			}
+		default:
+			return fmt.Errorf("unknown field %s", e.left)
+		}
+		*p = append(*p, in)
+	case *logicExpr:
+		op := opJumpFalse
+		switch e.op {
+		case tokenAnd:
+		case tokenOr:
+			op = opJumpTrue
+		default:
+			return fmt.Errorf("unknown logic operator %s", e.op)
+		}
+		if err := s.emit(p, e.left); err != nil {
+			return err
+		}
+		jump := len(*p)
+		*p = append(*p, instr{op: op})
+		if err := s.emit(p, e.right); err != nil {
+			return err
+		}
+		(*p)[jump].jump = len(*p)
+	case *notExpr:
+		if err := s.emit(p, e.inner); err != nil {
+			return err
+		}
+		*p = append(*p, instr{op: opNot})
+	case *bracketExpr:
+		return s.emit(p, e.inner)
+	default:
+		return fmt.Errorf("unknown expression %T", expr)
+	}
+	return nil
+}
+
+// literals 返回规则匹配时至少出现其中一个的常量, nil 表示无法确定.
+// && 取区分度更高的一侧, || 需要两侧都能确定
+func (s *RuleSet) literals(expr Expr) []literal {
+	switch e := expr.(type) {
+	case *matchExpr:
+		switch e.op {
+		case tokenContains, tokenFullEqual, tokenStartsWith, tokenEndsWith:
+			if e.right == "" {
+				return nil
+			}
+			// 区分大小写的常量也在小写字段中查找, 只用于过滤
+			return []literal{{slot: s.slot(e.left, e.key), text: strings.ToLower(e.right)}}
+		}
+	case *inExpr:
+		if !textFields[e.left] {
+			return nil
+		}
+		lits := make([]literal, 0, len(e.values))
+		for _, v := range e.values {
+			if v.content == "" {
+				return nil
+			}
+			lits = append(lits, literal{slot: s.slot(e.left, e.key), text: strings.ToLower(v.content)})
+		}
+		return lits
+	case *logicExpr:
+		left, right := s.literals(e.left), s.literals(e.right)
+		if e.op == tokenOr {
+			if left == nil || right == nil {
+				return nil
+			}
+			return append(left, right...)
+		}
+		if left == nil || (right != nil && selectivity(right) > selectivity(left)) {
+			return right
+		}
+		return left
+	case *bracketExpr:
+		return s.literals(e.inner)
+	}
+	return nil
+}
+
+// selectivity 常量集合的区分度, 最短的常量越长越好, 常量越少越好
+func selectivity(lits []literal) int {
+	shortest := len(lits[0].text)
+	for _, l := range lits[1:] {
+		shortest = min(shortest, len(l.text))
+	}
+	return shortest*16 - len(lits)
+}
+
+// view 单个样本的字段, 小写只转换一次
+type view struct {
+	config *Config
+	fields []fieldRef
+	raw    []string
+	lower  []string
+	loaded []uint8 // 1 raw 已加载, 2 lower 已加载
+}
+
+func (v *view) text(slot int, fold bool) string {
+	if v.loaded[slot]&1 == 0 {
+		v.raw[slot], _ = v.config.text(v.fields[slot].name, v.fields[slot].key)
+		v.loaded[slot] |= 1
+	}
+	if !fold {
+		return v.raw[slot]
+	}
+	if v.loaded[slot]&2 == 0 {
+		v.lower[slot] = strings.ToLower(v.raw[slot])
+		v.loaded[slot] |= 2
+	}
+	return v.lower[slot]
+}
+
+// Match 返回在 config 上匹配的规则下标, 按下标排序
+func (s *RuleSet) Match(config *Config) ([]int, error) {
+	if config == nil {
+		return nil, fmt.Errorf("nil config")
+	}
+	n := len(s.fields)
+	v := &view{
+		config: config,
+		fields: s.fields,
+		raw:    make([]string, n),
+		lower:  make([]string, n),
+		loaded: make([]uint8, n),
+	}
+
+	candidates := make([]bool, len(s.programs))
+	for _, i := range s.always {
+		candidates[i] = true
+	}
+	// 其他字段的常量也会命中, 按 (slot, 常量) 查找时忽略
+	for _, slot := range s.scan {
+		s.matcher.Scan(v.text(slot, true), func(m tire.Match) bool {
+			for _, i := range s.needs[literal{slot: slot, text: m.Word}] {
+				candidates[i] = true
+			}
+			return true
+		})
+	}
+
+	var matched []int
+	for i, ok := range candidates {
+		if ok && s.programs[i].run(v) {
+			matched = append(matched, i)
+		}
+	}
+	return matched, nil
+}
+
+func (p program) run(v *view) bool {
+	acc := false
+	for pc := 0; pc < len(p); pc++ {
+		in := &p[pc]
+		switch in.op {
+		case opText:
+			s := v.text(in.slot, in.fold)
+			switch in.cmp {
+			case tokenFullEqual:
+				acc = s == in.text
+			case tokenContains:
+				acc = strings.Contains(s, in.text)
+			case tokenNotEqual:
+				acc = !strings.Contains(s, in.text)
+			case tokenStartsWith:
+				acc = strings.HasPrefix(s, in.text)
+			case tokenEndsWith:
+				acc = strings.HasSuffix(s, in.text)
+			case tokenRegexEqual:
+				acc = in.regex.MatchString(s)
+			}
+		case opNumber:
+			n, _ := v.config.number(in.field)
+			right := in.numbers[0]
+			switch in.cmp {
+			case tokenFullEqual:
+				acc = n == right
+			case tokenNotEqual:
+				acc = n != right
+			case tokenGt:
+				acc = n > right
+			case tokenGte:
+				acc = n >= right
+			case tokenLt:
+				acc = n < right
+			case tokenLte:
+				acc = n <= right
+			}
+		case opIn:
+			acc = false
+			if in.field != "" {
+				n, _ := v.config.number(in.field)
+				for _, x := range in.numbers {
+					acc = acc || n == x
+				}
+				break
+			}
+			for i, x := range in.values {
+				acc = acc || v.text(in.slot, in.folds[i]) == x
+			}
+		case opNot:
+			acc = !acc
+		case opJumpFalse:
+			if !acc {
+				pc = in.jump - 1
+			}
+		case opJumpTrue:
+			if acc {
+				pc = in.jump - 1
+			}
+		}
+	}
+	return acc
+}
@@ -0,0 +1,121 @@
+package dsl
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestRuleSet(t *testing.T) {
+	var (
+		rules []*Rule
+		want  []int
+	)
+	for _, tt := range evalTests {
+		if tt.err {
+			continue
+		}
+		r, err := Compile(tt.rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.want {
+			want = append(want, len(rules))
+		}
+		rules = append(rules, r)
+	}
+
+	set, err := NewRuleSet(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := set.Match(evalConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		for _, i := range got {
+			t.Logf("matched %s", rules[i].root)
+		}
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestRuleSetLiterals(t *testing.T) {
+	set := &RuleSet{slots: make(map[fieldRef]int)}
+	for rule, want := range map[string]string{
+		`body="Foo"`:                              `body:foo`,
+		`body="foo" && title="longer"`:            `title:longer`,
+		`body="foo" || header["X-A"]^=c"Bar"`:     `body:foo header[X-A]:bar`,
+		`body="foo" || status==200`:               ``,
+		`!body="foo"`:                             ``,
+		`body!="foo"`:                             ``,
+		`body~="foo" && server in ["a", "b"]`:     `server:a server:b`,
+		`(body="" && title="x") || cookie=="sid"`: `title:x cookie:sid`,
+	} {
+		r, err := Compile(rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, l := range set.literals(r.root) {
+			f := set.fields[l.slot]
+			name := f.name
+			if f.key != "" {
+				name += "[" + f.key + "]"
+			}
+			got = append(got, name+":"+l.text)
+		}
+		if strings.Join(got, " ") != want {
+			t.Errorf("%s: got %v, want %s", rule, got, want)
+		}
+	}
+}
+
+// BenchmarkRuleSet 10k 条规则在单个页面上的匹配
+func BenchmarkRuleSet(b *testing.B) {
+	rules := make([]*Rule, 0, 10000)
+	for i := 0; i < 10000; i++ {
+		var s string
+		switch i % 4 {
+		case 0:
+			s = fmt.Sprintf(`body="product-%d" && status==200`, i)
+		case 1:
+			s = fmt.Sprintf(`title=="Product %d" || header["X-Product"]=="p%d"`, i, i)
+		case 2:
+			s = fmt.Sprintf(`(body="vendor-%d" || body="vendor %d") && !server="iis"`, i, i)
+		default:
+			s = fmt.Sprintf(`server in ["server-%d", "srv%d"] && body~="v[0-9]+"`, i, i)
+		}
+		r, err := Compile(s)
+		if err != nil {
+			b.Fatal(err)
+		}
+		rules = append(rules, r)
+	}
+	config := &Config{
+		Status:  200,
+		Body:    strings.Repeat("<div class=\"Product-1234\">Lorem ipsum dolor sit amet</div>\n", 2000),
+		Title:   "Product 4321",
+		Server:  "nginx",
+		Headers: map[string]string{"X-Product": "p17"},
+	}
+
+	b.Run("Eval", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, r := range rules {
+				r.Eval(config)
+			}
+		}
+	})
+	b.Run("RuleSet", func(b *testing.B) {
+		set, err := NewRuleSet(rules)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			set.Match(config)
+		}
+	})
+}
@@ -3,8 +3,58 @@ package dsl
 
 // Config 定义了进行指纹匹配时需要的配置信息
 type Config struct {
-	Status int
-	Body   string
-	Header string
-	Icon   int32
+	Status        int
+	Body          string
+	Header        string // 原始响应头文本
+	Icon          int32  // 图标的 Shodan/FOFA mmh3 哈希
+	Title         string
+	Server        string
+	Cookie        string            // Set-Cookie 文本, 多个以换行分隔
+	Headers       map[string]string // 响应头, key 为规范化的头名称
+	Meta          map[string]string // <meta name> 内容, key 为小写的 name
+	URL           string
+	Path          string
+	Cert          string // 证书主题、颁发者和 SAN, 以换行分隔
+	ContentLength int    // 响应体长度
+}
+
+// text 返回文本字段的值, key 为 header["Name"] 中的头名称或 meta["name"] 中的 name
+func (c *Config) text(field, key string) (string, bool) {
+	switch field {
+	case tokenBody:
+		return c.Body, true
+	case tokenHeader:
+		if key != "" {
+			return c.Headers[key], true
+		}
+		return c.Header, true
+	case tokenTitle:
+		return c.Title, true
+	case tokenServer:
+		return c.Server, true
+	case tokenCookie:
+		return c.Cookie, true
+	case tokenMeta:
+		return c.Meta[key], true
+	case tokenURL:
+		return c.URL, true
+	case tokenPath:
+		return c.Path, true
+	case tokenCert:
+		return c.Cert, true
+	}
+	return "", false
+}
+
+// number 返回数值字段的值
+func (c *Config) number(field string) (int, bool) {
+	switch field {
+	case tokenStatus:
+		return c.Status, true
+	case tokenIcon:
+		return int(c.Icon), true
+	case tokenContentLength:
+		return c.ContentLength, true
+	}
+	return 0, false
 }
@@ -2,51 +2,153 @@ package dsl
 
 import "testing"
 
-func TestEval(t *testing.T) {
-	config := &Config{
-		Status: 200,
-		Header: "Server: Nginx",
-		Body:   "<h1>hello nginx!<h1>",
-		Icon:   123,
-	}
+// evalConfig 和 evalTests 为 Eval 和 RuleSet 共用的一致性用例
+var evalConfig = &Config{
+	Status:        200,
+	Header:        "Server: Nginx",
+	Body:          "<h1>hello nginx!<h1>",
+	Icon:          123,
+	Title:         "Welcome to Nginx",
+	Server:        "nginx/1.24.0",
+	Cookie:        "JSESSIONID=abc; Path=/",
+	Headers:       map[string]string{"X-Powered-By": "PHP/8.1.2"},
+	Meta:          map[string]string{"generator": "WordPress 6.4"},
+	URL:           "https://example.com/index.php",
+	Path:          "/index.php",
+	Cert:          "CN=example.com\nCN=R3,O=Let's Encrypt",
+	ContentLength: 20,
+}
 
-	// test number
-	// for _, rule := range []string{
-	// 	"status==200",
-	// 	"status!=200",
-	// 	"status>200",
-	// 	"status<=200",
-	// 	"status>=200",
-
-	// 	"icon>200",
-	// 	"icon==123",
-	// 	"icon!=123",
-
-	// 	`icon="123"`,     // error
-	// 	"status=\"200\"", // error
-	// 	"status=200",     // error
-	// } {
-	// 	eval(t, config, rule)
-	// }
-
-	for _, rule := range []string{
-		`status==200 && (header="nginx" || body="nginx")`,
-		`status==200 && (header="nginx" && body="nginx" && icon==123)`,
-	} {
-		eval(t, config, rule)
-	}
+var evalTests = []struct {
+	rule string
+	want bool
+	err  bool // 解析失败
+}{
+	// 数值比较, 字段在左
+	{rule: "status==200", want: true},
+	{rule: "status!=200", want: false},
+	{rule: "status>200", want: false},
+	{rule: "status>199", want: true},
+	{rule: "status>=200", want: true},
+	{rule: "status<200", want: false},
+	{rule: "status<201", want: true},
+	{rule: "status<=200", want: true},
+	{rule: "icon>200", want: false},
+	{rule: "icon>-1", want: true},
+	{rule: "icon==123", want: true},
+	{rule: "icon!=123", want: false},
+	{rule: "content_length<=20", want: true},
+	{rule: "status in [301, 200]", want: true},
+	{rule: "status in [301]", want: false},
+	{rule: `icon="123"`, err: true},
+	{rule: `status="200"`, err: true},
+	{rule: "status=200", err: true},
+	{rule: `status in ["200"]`, err: true},
+
+	// 文本比较, 默认不区分大小写
+	{rule: `body="NGINX"`, want: true},
+	{rule: `body=c"NGINX"`, want: false},
+	{rule: `body!="apache"`, want: true},
+	{rule: `body!=c"Nginx"`, want: true},
+	{rule: `title=="welcome to nginx"`, want: true},
+	{rule: `title==c"welcome to nginx"`, want: false},
+	{rule: `title=="welcome"`, want: false},
+	{rule: `server^="NGINX/"`, want: true},
+	{rule: `server$=".0"`, want: true},
+	{rule: `server~="^nginx/[\\d.]+$"`, want: true},
+	{rule: `server~=c"^Nginx"`, want: false},
+	{rule: `title~="^WELCOME to"`, want: true},
+	{rule: `title~=c"^Welcome to Nginx$"`, want: true},
+	{rule: `header="server: nginx"`, want: true},
+	{rule: `header["x-powered-by"]=="php/8.1.2"`, want: true},
+	{rule: `header["X-Missing"]==""`, want: true},
+	{rule: `meta["Generator"]^="wordpress"`, want: true},
+	{rule: `meta["generator"] in [c"WordPress 6.4"]`, want: true},
+	{rule: `meta["author"]!=""`, want: false},
+	{rule: `meta="wordpress"`, err: true},
+	{rule: `cookie="jsessionid"`, want: true},
+	{rule: `url^="https://" && path$=".php"`, want: true},
+	{rule: `cert="let's encrypt"`, want: true},
+	{rule: `server in ["apache", "NGINX/1.24.0"]`, want: true},
+	{rule: `server in [c"NGINX/1.24.0"]`, want: false},
+	{rule: `body~="("`, err: true},
+	{rule: `body=1`, err: true},
+
+	// 逻辑运算
+	{rule: `status==200 && (header="nginx" || body="nginx")`, want: true},
+	{rule: `status==200 && (header="nginx" && body="nginx" && icon==123)`, want: true},
+	{rule: `header="123" || (body="abc" && (header="efg" || icon==123))`, want: false},
+	{rule: `body="apache" || body="nginx" && status==404`, want: false},
+	{rule: `(body="apache" || body="nginx") && status==200`, want: true},
+	{rule: `!(status==404) && !body="apache"`, want: true},
+	{rule: `!!status==200`, want: true},
+	{rule: `status==200 &&`, err: true},
+	{rule: `(status==200`, err: true},
+	{rule: `status==200)`, err: true},
+	{rule: `!`, err: true},
+	{rule: ``, err: true},
 }
 
-func eval(t *testing.T, config *Config, rule string) {
-	lexer, err := NewLexer(rule)
-	if err != nil {
-		t.Fatal(err)
+func TestEval(t *testing.T) {
+	config := evalConfig
+	for _, tt := range evalTests {
+		r, err := Compile(tt.rule)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: parse error %v, want error %v", tt.rule, err, tt.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		got, err := r.Eval(config)
+		if err != nil || got != tt.want {
+			t.Errorf("%s: got %v %v, want %v", tt.rule, got, err, tt.want)
+		}
 	}
 
-	dsl, err := TransFormExpr(lexer)
-	if err != nil {
-		t.Fatal(err)
+	if _, err := (&Rule{}).Eval(config); err == nil {
+		t.Error("empty rule should fail")
+	}
+	if _, err := (&Rule{root: &matchNumberExpr{left: tokenStatus, op: "=", right: 1}}).Eval(config); err == nil {
+		t.Error("unknown operator should fail")
+	}
+	if _, err := (&Rule{root: &matchExpr{left: tokenStatus, op: "="}}).Eval(config); err == nil {
+		t.Error("unknown field should fail")
 	}
+}
 
-	t.Log(dsl.Eval(config, true))
+func TestToGval(t *testing.T) {
+	for rule, want := range map[string]string{
+		`title="Admin" && status==200`:    `(contains(resp.title, "Admin") && resp.status == 200)`,
+		`server=="NGINX" || body!="x\"y"`: `(lower(resp.server) == "nginx" || !contains(resp.body, "x\"y"))`,
+		`cookie~="jsessionid=[a-z]+"`:     `match(resp.cookie, "(?i)jsessionid=[a-z]+")`,
+		`(icon==-247388890)`:              `(resp.icon_hash == -247388890)`,
+		`icon=="-247388890"`:              ``,
+		`(header="a" || icon==116323821)`: `((contains(resp.header, "a") || resp.icon_hash == 116323821))`,
+		`status in [200, 302]`:            `resp.status in [200, 302]`,
+		`server in ["NGINX", c"IIS"]`:     `(lower(resp.server) in ["nginx"] || resp.server in ["IIS"])`,
+		`header["x-generator"]^="Drupal"`: `starts(lower(resp.headers["X-Generator"]), "drupal")`,
+		`meta["Generator"]=c"Joomla"`:     `includes(resp.meta["generator"], "Joomla")`,
+		`path$=c".JSP"`:                   `ends(resp.path, ".JSP")`,
+		`content_length>=0`:               `resp.content_length >= 0`,
+		`body in [200]`:                   ``,
+		`status^="2"`:                     ``,
+		`header["a"`:                      ``,
+		`title in []`:                     ``,
+	} {
+		r, err := Compile(rule)
+		if want == "" {
+			if err == nil {
+				t.Errorf("%s: expected parse error", rule)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := r.ToGval()
+		if err != nil || got != want {
+			t.Errorf("%s: got %s %v, want %s", rule, got, err, want)
+		}
+	}
 }
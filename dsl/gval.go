@@ -0,0 +1,159 @@
+package dsl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// gvalFields dsl 关键字对应的 fingerprint.Sample 表达式字段
+var gvalFields = map[string]string{
+	tokenStatus:        "resp.status",
+	tokenBody:          "resp.body",
+	tokenHeader:        "resp.header",
+	tokenIcon:          "resp.icon_hash",
+	tokenTitle:         "resp.title",
+	tokenServer:        "resp.server",
+	tokenCookie:        "resp.cookie",
+	tokenURL:           "resp.url",
+	tokenPath:          "resp.path",
+	tokenContentLength: "resp.content_length",
+}
+
+// Compile 解析 dsl 规则
+func Compile(s string) (*Rule, error) {
+	lexer, err := NewLexer(s)
+	if err != nil {
+		return nil, err
+	}
+	return TransFormExpr(lexer)
+}
+
+// ToGval 将规则转换为 fingerprint 使用的 gval 表达式, 文本比较与 dsl 一致不区分大小写
+func (r *Rule) ToGval() (string, error) {
+	if r.root == nil {
+		return "", fmt.Errorf("empty rule")
+	}
+	return toGval(r.root)
+}
+
+// gvalField 返回字段对应的表达式, cert 由多个证书字段组成, 没有对应的表达式
+func gvalField(name, key string) (string, error) {
+	if name == tokenHeader && key != "" {
+		return fmt.Sprintf("resp.headers[%s]", strconv.Quote(key)), nil
+	}
+	if name == tokenMeta {
+		return fmt.Sprintf("resp.meta[%s]", strconv.Quote(key)), nil
+	}
+	field, ok := gvalFields[name]
+	if !ok {
+		return "", fmt.Errorf("field %s is not supported in gval", name)
+	}
+	return field, nil
+}
+
+func toGval(expr Expr) (string, error) {
+	switch e := expr.(type) {
+	case *matchExpr:
+		field, err := gvalField(e.left, e.key)
+		if err != nil {
+			return "", err
+		}
+		right := strconv.Quote(e.right)
+		if e.cs {
+			switch e.op {
+			case tokenContains:
+				return fmt.Sprintf("includes(%s, %s)", field, right), nil
+			case tokenNotEqual:
+				return fmt.Sprintf("!includes(%s, %s)", field, right), nil
+			case tokenFullEqual:
+				return fmt.Sprintf("%s == %s", field, right), nil
+			case tokenStartsWith:
+				return fmt.Sprintf("starts(%s, %s)", field, right), nil
+			case tokenEndsWith:
+				return fmt.Sprintf("ends(%s, %s)", field, right), nil
+			case tokenRegexEqual:
+				return fmt.Sprintf("match(%s, %s)", field, right), nil
+			}
+			return "", fmt.Errorf("unknown op %s", e.op)
+		}
+
+		lower := strconv.Quote(strings.ToLower(e.right))
+		switch e.op {
+		case tokenContains:
+			return fmt.Sprintf("contains(%s, %s)", field, right), nil
+		case tokenNotEqual:
+			return fmt.Sprintf("!contains(%s, %s)", field, right), nil
+		case tokenFullEqual:
+			return fmt.Sprintf("lower(%s) == %s", field, lower), nil
+		case tokenStartsWith:
+			return fmt.Sprintf("starts(lower(%s), %s)", field, lower), nil
+		case tokenEndsWith:
+			return fmt.Sprintf("ends(lower(%s), %s)", field, lower), nil
+		case tokenRegexEqual:
+			// 与 dsl 一致使用标准 regexp 加 (?i), regex 函数带有 DotNL 且不匹配空字符串
+			return fmt.Sprintf("match(%s, %s)", field, strconv.Quote("(?i)"+e.right)), nil
+		}
+		return "", fmt.Errorf("unknown op %s", e.op)
+	case *matchNumberExpr:
+		field, err := gvalField(e.left, "")
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s %s %d", field, e.op, e.right), nil
+	case *inExpr:
+		field, err := gvalField(e.left, e.key)
+		if err != nil {
+			return "", err
+		}
+		var numbers, fold, exact []string
+		for _, v := range e.values {
+			switch {
+			case v.name == tokenNumber:
+				numbers = append(numbers, v.content)
+			case v.cs:
+				exact = append(exact, strconv.Quote(v.content))
+			default:
+				fold = append(fold, strconv.Quote(strings.ToLower(v.content)))
+			}
+		}
+		var parts []string
+		if len(numbers) > 0 {
+			parts = append(parts, fmt.Sprintf("%s in [%s]", field, strings.Join(numbers, ", ")))
+		}
+		if len(fold) > 0 {
+			parts = append(parts, fmt.Sprintf("lower(%s) in [%s]", field, strings.Join(fold, ", ")))
+		}
+		if len(exact) > 0 {
+			parts = append(parts, fmt.Sprintf("%s in [%s]", field, strings.Join(exact, ", ")))
+		}
+		if len(parts) == 1 {
+			return parts[0], nil
+		}
+		return "(" + strings.Join(parts, " || ") + ")", nil
+	case *logicExpr:
+		left, err := toGval(e.left)
+		if err != nil {
+			return "", err
+		}
+		right, err := toGval(e.right)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("(%s %s %s)", left, e.op, right), nil
+	case *notExpr:
+		inner, err := toGval(e.inner)
+		if err != nil {
+			return "", err
+		}
+		// gval 的 ! 只作用于第一个操作数, 需要加括号
+		return "!(" + inner + ")", nil
+	case *bracketExpr:
+		inner, err := toGval(e.inner)
+		if err != nil {
+			return "", err
+		}
+		return "(" + inner + ")", nil
+	}
+	return "", fmt.Errorf("unknown expression %T", expr)
+}
@@ -0,0 +1,116 @@
+package dsl_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/BreakOnCrash/opendast/dsl"
+	"github.com/BreakOnCrash/opendast/fingerprint"
+)
+
+// gvalAgrees 用 fingerprint 的 gval 语言执行转换后的表达式, 结果需要与 dsl 一致.
+// 没有对应 gval 表达式的规则 (如 cert) 跳过
+func gvalAgrees(t *testing.T, r *dsl.Rule, sample *fingerprint.Sample, want bool) {
+	t.Helper()
+	expr, err := r.ToGval()
+	if err != nil {
+		return
+	}
+	v, err := fingerprint.MatchSample(context.Background(), sample, expr)
+	if err != nil || v != want {
+		t.Fatalf("%s => %s: gval %v %v, dsl %v", r, expr, v, err, want)
+	}
+}
+
+func TestPrecedence(t *testing.T) {
+	for rule, want := range map[string]string{
+		`title="a" || title="b" && title="c"`:   `(contains(resp.title, "a") || (contains(resp.title, "b") && contains(resp.title, "c")))`,
+		`title="a" && title="b" || title="c"`:   `((contains(resp.title, "a") && contains(resp.title, "b")) || contains(resp.title, "c"))`,
+		`title="a" || title="b" || title="c"`:   `((contains(resp.title, "a") || contains(resp.title, "b")) || contains(resp.title, "c"))`,
+		`(title="a" || title="b") && title="c"`: `(((contains(resp.title, "a") || contains(resp.title, "b"))) && contains(resp.title, "c"))`,
+		`!title="a" && title="b"`:               `(!(contains(resp.title, "a")) && contains(resp.title, "b"))`,
+		`!(title="a" || title="b")`:             `!(((contains(resp.title, "a") || contains(resp.title, "b"))))`,
+		`!!title="a"`:                           `!(!(contains(resp.title, "a")))`,
+		`!status==404`:                          `!(resp.status == 404)`,
+	} {
+		r, err := dsl.Compile(rule)
+		if err != nil {
+			t.Fatalf("%s: %v", rule, err)
+		}
+		if got, _ := r.ToGval(); got != want {
+			t.Errorf("%s: got %s, want %s", rule, got, want)
+		}
+	}
+
+	sample := &fingerprint.Sample{StatusCode: 200, Body: "nginx", Title: "hello"}
+	config := sample.DSLConfig()
+	for rule, want := range map[string]bool{
+		`body="apache" && body="x" || body="nginx"`: true,
+		`body="nginx" || body="x" && body="apache"`: true,
+		`!(body="apache" || body="iis")`:            true,
+		`!body="nginx" || body="iis"`:               false,
+		`!status==404`:                              true,
+		`!title=="hello"`:                           false,
+		`!title==c"Hello" && !body in ["apache"]`:   true,
+	} {
+		r, err := dsl.Compile(rule)
+		if err != nil {
+			t.Fatalf("%s: %v", rule, err)
+		}
+		if got, err := r.Eval(config); err != nil || got != want {
+			t.Errorf("%s: got %v %v, want %v", rule, got, err, want)
+		}
+		gvalAgrees(t, r, sample, want)
+	}
+}
+
+// FuzzCompile 任意输入都不应 panic, 解析成功的规则可以求值, 转换后的 gval 表达式结果一致
+func FuzzCompile(f *testing.F) {
+	for _, s := range []string{
+		`status==200 && (header="nginx" || body="nginx")`,
+		`!(title~="^admin" || server in ["nginx", c"IIS"])`,
+		`header["X-Powered-By"]^=c"PHP" && content_length<=100`,
+		`body="a\"b" || icon==-247388890`,
+		`cert$=".com" && url="x" && path=="/"`,
+		`!status==404 && !title=="x"`,
+		`(((`,
+		`body="`,
+	} {
+		f.Add(s)
+	}
+	sample := &fingerprint.Sample{
+		StatusCode: 200,
+		Body:       "body",
+		Headers:    map[string]string{},
+		Meta:       map[string]string{},
+	}
+	config := sample.DSLConfig()
+	f.Fuzz(func(t *testing.T, s string) {
+		r, err := dsl.Compile(s)
+		if err != nil {
+			var se *dsl.SyntaxError
+			if !errors.As(err, &se) {
+				t.Fatalf("%q: error %v is not a syntax error", s, err)
+			}
+			return
+		}
+		got, err := r.Eval(config)
+		if err != nil {
+			t.Fatalf("%q: %v", s, err)
+		}
+		if _, err := r.Trace(config); err != nil {
+			t.Fatalf("%q: %v", s, err)
+		}
+		gvalAgrees(t, r, sample, got)
+
+		// 规范化源码可以重新解析且不再变化
+		r2, err := dsl.Compile(r.String())
+		if err != nil {
+			t.Fatalf("%q => %q: %v", s, r.String(), err)
+		}
+		if r2.String() != r.String() {
+			t.Fatalf("%q: %q != %q", s, r2.String(), r.String())
+		}
+	})
+}
@@ -1,12 +1,11 @@
 // From https://github.com/Tencent/AI-Infra-Guard
 package dsl
 
-import "errors"
-
 type Lexer struct {
 	tokens      []Token // slice of tokens to process 要处理的token切片
 	index       int     // current position in the stream 当前处理位置
 	tokenLength int     // total number of tokens 总token数量
+	end         int     // column after the last rune 规则末尾的列号
 }
 
 func NewLexer(s1 string) (lexer *Lexer, err error) {
@@ -17,6 +16,7 @@ func NewLexer(s1 string) (lexer *Lexer, err error) {
 	}
 
 	lexer.tokenLength = len(lexer.tokens)
+	lexer.end = len([]rune(s1)) + 1
 	return lexer, nil
 }
 
@@ -31,7 +31,7 @@ func (l *Lexer) rewind() {
 func (l *Lexer) next() (Token, error) {
 	// Fix the logic error: check bounds before accessing token
 	if l.index >= len(l.tokens) {
-		return Token{}, errors.New("token index great token's length")
+		return Token{}, &SyntaxError{Column: l.end, Msg: "unexpected end of expression"}
 	}
 	token := l.tokens[l.index]
 	l.index += 1
@@ -5,12 +5,15 @@ package dsl
 import (
 	"errors"
 	"fmt"
+	"io"
+	"net/textproto"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
 // Expr 定义了表达式接口
-// 所有表达式类型都需要实现 Name() 方法
+// String 返回规范化的 dsl 源码, 重新解析后得到相同的表达式
 type Expr interface {
 	String() string
 }
@@ -20,15 +23,65 @@ type Rule struct {
 	root Expr
 }
 
+// String 返回规范化的 dsl 源码: 比较运算两侧不加空格, 逻辑运算符两侧各一个空格,
+// 文本使用双引号并转义 \ 和 ", 保留原有的括号
+func (r *Rule) String() string {
+	if r == nil || r.root == nil {
+		return ""
+	}
+	return r.root.String()
+}
+
+// SyntaxError 规则语法错误, Column 为出错 token 的列号 (从 1 开始, 按字符计算)
+type SyntaxError struct {
+	Column int
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("column %d: %s", e.Column, e.Msg)
+}
+
+func syntaxError(t Token, format string, args ...any) error {
+	return &SyntaxError{Column: t.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// quoteText 将文本写成 dsl 字符串, cs 时加 c 前缀
+func quoteText(s string, cs bool) string {
+	var b strings.Builder
+	if cs {
+		b.WriteByte('c')
+	}
+	b.WriteByte('"')
+	for _, c := range s {
+		if c == '"' || c == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// fieldString 返回字段的 dsl 写法, 如 header["X-Powered-By"]
+func fieldString(field, key string) string {
+	if key == "" {
+		return field
+	}
+	return field + "[" + quoteText(key, false) + "]"
+}
+
 type matchExpr struct {
 	op        string
 	left      string
+	key       string // header["Name"] 中规范化的头名称, meta["name"] 中小写的 name
 	right     string
+	cs        bool // 区分大小写
 	cacheRegx *regexp.Regexp
 }
 
 func (m matchExpr) String() string {
-	return fmt.Sprintf("matchExpr: left: %s, op: %s, right: %s", m.left, m.op, m.right)
+	return fieldString(m.left, m.key) + m.op + quoteText(m.right, m.cs)
 }
 
 type matchNumberExpr struct {
@@ -38,7 +91,26 @@ type matchNumberExpr struct {
 }
 
 func (m matchNumberExpr) String() string {
-	return fmt.Sprintf("matchNumberExpr: left: %s, op: %s, right: %d", m.left, m.op, m.right)
+	return m.left + m.op + strconv.Itoa(m.right)
+}
+
+// inExpr 列表匹配, 文本不区分大小写, 除非写成 c"..."
+type inExpr struct {
+	left   string
+	key    string
+	values []Token // 文本或数字
+}
+
+func (in inExpr) String() string {
+	values := make([]string, len(in.values))
+	for i, v := range in.values {
+		if v.name == tokenNumber {
+			values[i] = strconv.Itoa(v.number)
+		} else {
+			values[i] = quoteText(v.content, v.cs)
+		}
+	}
+	return fieldString(in.left, in.key) + " in [" + strings.Join(values, ", ") + "]"
 }
 
 type logicExpr struct {
@@ -47,8 +119,27 @@ type logicExpr struct {
 	right Expr
 }
 
+// String 子表达式优先级更低, 或右侧为同级运算时加括号, 保证重新解析后结构不变
 func (l logicExpr) String() string {
-	return fmt.Sprintf("logicExpr: left: %s, op: %s, right: %s", l.left.String(), l.op, l.right.String())
+	left, right := l.left.String(), l.right.String()
+	if c, ok := l.left.(*logicExpr); ok && precedence[c.op] < precedence[l.op] {
+		left = "(" + left + ")"
+	}
+	if c, ok := l.right.(*logicExpr); ok && precedence[c.op] <= precedence[l.op] {
+		right = "(" + right + ")"
+	}
+	return left + " " + l.op + " " + right
+}
+
+type notExpr struct {
+	inner Expr
+}
+
+func (n notExpr) String() string {
+	if _, ok := n.inner.(*logicExpr); ok {
+		return "!(" + n.inner.String() + ")"
+	}
+	return "!" + n.inner.String()
 }
 
 type bracketExpr struct {
@@ -56,28 +147,36 @@ type bracketExpr struct {
 }
 
 func (b bracketExpr) String() string {
-	return fmt.Sprintf("bracketExpr: inner: %s", b.inner.String())
+	return "(" + b.inner.String() + ")"
+}
+
+// precedence 二元逻辑运算符的优先级, 数值越大结合越紧密. 一元运算符 ! 高于所有二元运算符
+var precedence = map[string]int{
+	tokenOr:  1,
+	tokenAnd: 2,
 }
 
 // TransFormExpr 将token序列转换为表达式规则
 // 输入tokens切片，返回Rule对象和error
 // 主要功能：解析tokens并构建DSL表达式、逻辑表达式和括号表达式
 func TransFormExpr(lexer *Lexer) (*Rule, error) {
-	root, err := parseExpr(lexer)
+	root, err := parseExpr(lexer, 1)
 	if err != nil {
 		return nil, err
 	}
 
 	if lexer.hasNext() {
-		return nil, errors.New("unexpected tokens after expression")
+		token, _ := lexer.next()
+		return nil, syntaxError(token, "unexpected token %s after expression", token.content)
 	}
 
 	return &Rule{root: root}, nil
 }
 
-// parseExpr 解析表达式
-func parseExpr(lexer *Lexer) (Expr, error) {
-	expr, err := parsePrimaryExpr(lexer)
+// parseExpr 使用优先级爬升法解析表达式, 只消费优先级不低于 minPrec 的二元运算符.
+// 同级运算符左结合, 如 a || b && c 解析为 a || (b && c)
+func parseExpr(lexer *Lexer, minPrec int) (Expr, error) {
+	expr, err := parseUnaryExpr(lexer)
 	if err != nil {
 		return nil, err
 	}
@@ -87,25 +186,56 @@ func parseExpr(lexer *Lexer) (Expr, error) {
 		if err != nil {
 			return nil, err
 		}
-		if token.name == tokenAnd || token.name == tokenOr {
-			right, err := parsePrimaryExpr(lexer)
-			if err != nil {
-				return nil, err
-			}
-			// 提高括号表达式的优先级
-			if _, ok := right.(*bracketExpr); ok {
-				expr = &logicExpr{op: token.content, left: right, right: expr}
-			} else {
-				expr = &logicExpr{op: token.content, left: expr, right: right}
-			}
-		} else {
+		prec, ok := precedence[token.name]
+		if !ok || prec < minPrec {
 			lexer.rewind()
 			break
 		}
+		right, err := parseExpr(lexer, prec+1)
+		if err != nil {
+			return nil, err
+		}
+		expr = &logicExpr{op: token.content, left: expr, right: right}
 	}
 	return expr, nil
 }
 
+// parseUnaryExpr 解析 ! 取反, 如 !(body="a" || body="b")
+func parseUnaryExpr(lexer *Lexer) (Expr, error) {
+	token, err := lexer.next()
+	if err != nil {
+		return nil, err
+	}
+	if token.name != tokenNot {
+		lexer.rewind()
+		return parsePrimaryExpr(lexer)
+	}
+	inner, err := parseUnaryExpr(lexer)
+	if err != nil {
+		return nil, err
+	}
+	return &notExpr{inner: inner}, nil
+}
+
+// 字段的类型, 文本字段支持 = == != ~= ^= $= in, 数值字段支持 == != > >= < <= in
+var (
+	textFields = map[string]bool{
+		tokenBody: true, tokenHeader: true, tokenTitle: true, tokenServer: true,
+		tokenCookie: true, tokenMeta: true, tokenURL: true, tokenPath: true, tokenCert: true,
+	}
+	numberFields = map[string]bool{
+		tokenStatus: true, tokenIcon: true, tokenContentLength: true,
+	}
+	textOps = map[string]bool{
+		tokenContains: true, tokenFullEqual: true, tokenNotEqual: true,
+		tokenRegexEqual: true, tokenStartsWith: true, tokenEndsWith: true,
+	}
+	numberOps = map[string]bool{
+		tokenFullEqual: true, tokenNotEqual: true,
+		tokenGt: true, tokenGte: true, tokenLt: true, tokenLte: true,
+	}
+)
+
 // parsePrimary 解析括号语句和基础表达式
 func parsePrimaryExpr(lexer *Lexer) (Expr, error) {
 	tmpToken, err := lexer.next()
@@ -113,76 +243,161 @@ func parsePrimaryExpr(lexer *Lexer) (Expr, error) {
 		return nil, err
 	}
 
-	switch tmpToken.name {
-	case tokenStatus, tokenIcon:
-		p2, err := lexer.next()
+	switch {
+	case numberFields[tmpToken.name]:
+		return parseNumberMatch(lexer, tmpToken)
+	case textFields[tmpToken.name]:
+		return parseTextMatch(lexer, tmpToken)
+	case tmpToken.name == tokenLeftBracket:
+		inner, err := parseExpr(lexer, 1)
 		if err != nil {
 			return nil, err
 		}
-		if !(p2.name == tokenFullEqual ||
-			p2.name == tokenNotEqual ||
-			p2.name == tokenGte ||
-			p2.name == tokenLte ||
-			p2.name == tokenGt ||
-			p2.name == tokenLt) {
-			return nil, errors.New("synax error in " + tmpToken.content + " " + p2.content)
+		closingToken, err := lexer.next()
+		if err != nil {
+			return nil, syntaxError(tmpToken, "missing closing bracket")
+		}
+		if closingToken.name != tokenRightBracket {
+			return nil, syntaxError(closingToken, "unexpected %s, want )", closingToken.content)
+		}
+		return &bracketExpr{inner: inner}, nil
+	default:
+		return nil, syntaxError(tmpToken, "unexpected token %s", tmpToken.content)
+	}
+}
+
+// parseNumberMatch 解析 status>=200、content_length<100、status in [200,302]
+func parseNumberMatch(lexer *Lexer, field Token) (Expr, error) {
+	p2, err := lexer.next()
+	if err != nil {
+		return nil, err
+	}
+	if p2.name == tokenIn {
+		values, err := parseList(lexer, tokenNumber)
+		if err != nil {
+			return nil, err
 		}
-		p3, err := lexer.next()
+		return &inExpr{left: field.content, values: values}, nil
+	}
+	if !numberOps[p2.name] {
+		return nil, syntaxError(p2, "unexpected %s after %s, want a numeric comparison", p2.content, field.content)
+	}
+	p3, err := lexer.next()
+	if err != nil {
+		return nil, err
+	}
+	if p3.name != tokenNumber {
+		return nil, syntaxError(p3, "unexpected %s after %s %s, want a number", p3.content, field.content, p2.content)
+	}
+	return &matchNumberExpr{left: field.content, op: p2.content, right: p3.number}, nil
+}
+
+// parseTextMatch 解析 title="a"、header["X-Powered-By"]^=c"PHP"、meta["generator"]^="WordPress"、
+// server in ["nginx","openresty"]
+func parseTextMatch(lexer *Lexer, field Token) (Expr, error) {
+	p2, err := lexer.next()
+	if err != nil {
+		return nil, err
+	}
+
+	var key string
+	if field.name == tokenMeta && p2.name != tokenLeftSquare {
+		return nil, syntaxError(p2, "unexpected %s after meta, want [", p2.content)
+	}
+	if (field.name == tokenHeader || field.name == tokenMeta) && p2.name == tokenLeftSquare {
+		name, err := lexer.next()
 		if err != nil {
 			return nil, err
 		}
-		if p3.name != tokenNumber {
-			return nil, errors.New("synax error in" + tmpToken.content + " " + p2.content + " " + p3.content)
+		if name.name != tokenText || name.content == "" {
+			return nil, syntaxError(name, "unexpected %s, want a quoted %s name", name.content, field.content)
 		}
-		return &matchNumberExpr{left: tmpToken.content, op: p2.content, right: p3.number}, nil
-	case tokenBody, tokenHeader:
-		p2, err := lexer.next()
+		closing, err := lexer.next()
 		if err != nil {
 			return nil, err
 		}
-		if !(p2.name == tokenContains ||
-			p2.name == tokenFullEqual ||
-			p2.name == tokenNotEqual ||
-			p2.name == tokenRegexEqual) {
-			return nil, errors.New("synax error in " + tmpToken.content + " " + p2.content)
+		if closing.name != tokenRightSquare {
+			return nil, syntaxError(closing, "unexpected %s, want ]", closing.content)
+		}
+		if field.name == tokenMeta {
+			key = strings.ToLower(name.content)
+		} else {
+			key = textproto.CanonicalMIMEHeaderKey(name.content)
 		}
-		p3, err := lexer.next()
+		if p2, err = lexer.next(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p2.name == tokenIn {
+		values, err := parseList(lexer, tokenText)
 		if err != nil {
 			return nil, err
 		}
-		if p3.name != tokenText {
-			return nil, errors.New("synax error in" + tmpToken.content + " " + p2.content + " " + p3.content)
+		return &inExpr{left: field.content, key: key, values: values}, nil
+	}
+	if !textOps[p2.name] {
+		return nil, syntaxError(p2, "unexpected %s after %s, want a text comparison", p2.content, field.content)
+	}
+	p3, err := lexer.next()
+	if err != nil {
+		return nil, err
+	}
+	if p3.name != tokenText {
+		return nil, syntaxError(p3, "unexpected %s after %s %s, want quoted text", p3.content, field.content, p2.content)
+	}
+
+	expr := &matchExpr{left: field.content, key: key, op: p2.content, right: p3.content, cs: p3.cs}
+	// 正则缓存对象, 不区分大小写时用 (?i) 编译, 匹配原始字段
+	if p2.name == tokenRegexEqual {
+		pattern := p3.content
+		if !p3.cs {
+			pattern = "(?i)" + pattern
 		}
-		// 正则缓存对象
-		var expr matchExpr
-		if p2.name == tokenRegexEqual {
-			compile, err := regexp.Compile(p3.content)
-			if err != nil {
-				return nil, err
-			}
-			expr = matchExpr{left: tmpToken.content, op: p2.content, cacheRegx: compile}
-		} else {
-			expr = matchExpr{left: tmpToken.content, op: p2.content, right: p3.content}
+		if expr.cacheRegx, err = regexp.Compile(pattern); err != nil {
+			return nil, syntaxError(p3, "%v", err)
 		}
-		return &expr, nil
-	case tokenLeftBracket:
-		inner, err := parseExpr(lexer)
+	}
+	return expr, nil
+}
+
+// parseList 解析 [a, b, ...], 元素类型必须为 kind
+func parseList(lexer *Lexer, kind string) ([]Token, error) {
+	open, err := lexer.next()
+	if err != nil {
+		return nil, err
+	}
+	if open.name != tokenLeftSquare {
+		return nil, syntaxError(open, "unexpected %s after in, want [", open.content)
+	}
+
+	var values []Token
+	for {
+		t, err := lexer.next()
 		if err != nil {
 			return nil, err
 		}
-		closingToken, err := lexer.next()
-		if err != nil || closingToken.name != tokenRightBracket {
-			return nil, errors.New("missing or invalid closing bracket")
+		if t.name != kind {
+			return nil, syntaxError(t, "unexpected %s in list, want a %s", t.content, kind)
+		}
+		values = append(values, t)
+
+		if t, err = lexer.next(); err != nil {
+			return nil, err
+		}
+		switch t.name {
+		case tokenRightSquare:
+			return values, nil
+		case tokenComma:
+		default:
+			return nil, syntaxError(t, "unexpected %s in list, want , or ]", t.content)
 		}
-		return &bracketExpr{inner: inner}, nil
-	default:
-		return nil, errors.New("unexpected token: " + tmpToken.content)
 	}
 }
 
-// PrintAST 递归打印表达式
-func (r *Rule) PrintAST() {
-	if r.root == nil {
+// PrintAST 递归打印表达式树
+func (r *Rule) PrintAST(w io.Writer) {
+	if r == nil || r.root == nil {
 		return
 	}
 
@@ -191,26 +406,18 @@ func (r *Rule) PrintAST() {
 		indent := strings.Repeat("  ", level)
 
 		switch e := expr.(type) {
-		case *matchExpr:
-			if e.cacheRegx != nil {
-				fmt.Printf("%s    dslExp: %s %s regex('%s')\n", indent, e.left, e.op, e.cacheRegx.String())
-			} else {
-				fmt.Printf("%s    dslExp: %s %s '%s'\n", indent, e.left, e.op, e.right)
-			}
-
 		case *logicExpr:
-			fmt.Printf("%s logicExp: %s\n", indent, e.op)
-			fmt.Printf("%s  - left:\n", indent)
+			fmt.Fprintf(w, "%slogic: %s\n", indent, e.op)
 			printExpr(e.left, level+1)
-			fmt.Printf("%s  - right:\n", indent)
 			printExpr(e.right, level+1)
-
+		case *notExpr:
+			fmt.Fprintf(w, "%snot:\n", indent)
+			printExpr(e.inner, level+1)
 		case *bracketExpr:
-			fmt.Printf("%s bracketExp:\n", indent)
+			fmt.Fprintf(w, "%sbracket:\n", indent)
 			printExpr(e.inner, level+1)
-
 		default:
-			fmt.Printf("%s Unknown expression type\n", indent)
+			fmt.Fprintf(w, "%s%s\n", indent, expr)
 		}
 	}
 
@@ -218,123 +425,14 @@ func (r *Rule) PrintAST() {
 }
 
 // Eval 评估规则是否匹配
-// 输入配置对象，返回布尔值表示是否匹配
-// 使用栈实现后缀表达式求值
-func (r *Rule) Eval(config *Config, debug bool) bool {
-	if r.root == nil {
-		return false
-	}
-
-	var evalExpr func(expr Expr, config *Config) bool
-	evalExpr = func(expr Expr, config *Config) bool {
-		switch next := expr.(type) {
-		case *matchExpr:
-			var s1 string
-			switch next.left {
-			case tokenBody:
-				s1 = config.Body
-			case tokenHeader:
-				s1 = config.Header
-			default:
-				panic("unknown left token")
-			}
-			s1 = strings.ToLower(s1)
-			text := strings.ToLower(next.right)
-			var r bool
-			switch next.op {
-			case tokenFullEqual:
-				r = text == s1
-			case tokenContains:
-				r = strings.Contains(s1, text)
-			case tokenNotEqual:
-				r = !strings.Contains(s1, text)
-			case tokenRegexEqual:
-				r = next.cacheRegx.MatchString(s1)
-			default:
-				panic("unknown op token")
-			}
-
-			// TODO For DEBUG
-			if debug {
-				fmt.Printf("eval: %s, %v\n", next.String(), r)
-			}
-
-			return r
-		case *matchNumberExpr:
-			var n1 int
-			switch next.left {
-			case tokenStatus:
-				n1 = config.Status
-			case tokenIcon:
-				n1 = int(config.Icon)
-			default:
-				panic("unknown left token")
-			}
-			var r bool
-			switch next.op {
-			case tokenFullEqual:
-				r = next.right == n1
-			case tokenNotEqual:
-				r = next.right != n1
-			case tokenGt:
-				r = next.right > n1
-			case tokenGte:
-				r = next.right >= n1
-			case tokenLt:
-				r = next.right < n1
-			case tokenLte:
-				r = next.right <= n1
-			default:
-				panic("unknown op token")
-			}
-
-			// TODO For DEBUG
-			if debug {
-				fmt.Printf("eval: %s, %v\n", next.String(), r)
-			}
-
-			return r
-		case *logicExpr:
-			switch next.op {
-			case tokenAnd:
-				leftVal := evalExpr(next.left, config)
-				if !leftVal { // short-circuit evaluation
-
-					if debug {
-						fmt.Printf("logicExpr: logic: %s, short-circuit evaluation, left: %v\n", next.op, leftVal)
-					}
-
-					return false
-				}
-
-				if debug {
-					fmt.Printf("logicExpr: %v %s %s\n", leftVal, next.op, next.right)
-				}
-				return evalExpr(next.right, config)
-			case tokenOr:
-				leftVal := evalExpr(next.left, config)
-				if leftVal { // short-circuit evaluation
-
-					if debug {
-						fmt.Printf("logicExpr: logic: %s, short-circuit evaluation, left: %v\n", next.op, leftVal)
-					}
-
-					return true
-				}
-
-				if debug {
-					fmt.Printf("logicExpr: %v %s %s\n", leftVal, next.op, next.right)
-				}
-
-				return evalExpr(next.right, config)
-			default:
-				panic("unknown logic type")
-			}
-		case *bracketExpr:
-			return evalExpr(next.inner, config)
-		default:
-			panic("error eval")
-		}
+// 输入配置对象，返回布尔值表示是否匹配; 规则或配置无效时返回错误, 不会 panic
+func (r *Rule) Eval(config *Config) (bool, error) {
+	if r == nil || r.root == nil {
+		return false, errors.New("empty rule")
+	}
+	if config == nil {
+		return false, errors.New("nil config")
 	}
-	return evalExpr(r.root, config)
+	ok, _, err := (&evaluator{config: config}).eval(r.root)
+	return ok, err
 }
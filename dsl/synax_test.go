@@ -1,9 +1,13 @@
 package dsl
 
-import "testing"
+import (
+	"errors"
+	"strings"
+	"testing"
+)
 
 func TestTransFormExp(t *testing.T) {
-	s := `header="123" || (body="abc" && (header="efg" || icon="123"))`
+	s := `header="123" || (body="abc" && (header="efg" || icon==123))`
 	lexer, err := NewLexer(s)
 	if err != nil {
 		t.Fatal(err)
@@ -13,5 +17,30 @@ func TestTransFormExp(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	expr.PrintAST()
+	var b strings.Builder
+	expr.PrintAST(&b)
+	t.Log("\n" + b.String())
+}
+
+func TestSyntaxErrorColumn(t *testing.T) {
+	for rule, column := range map[string]int{
+		`body="a" &&`:             12,
+		`body="a" && (title="b"`:  13,
+		`body="a" title="b"`:      10,
+		`status=="200"`:           9,
+		`body=="a" || status="1"`: 20,
+		`body~="(" `:              7,
+		`body="a" && ?`:           13,
+		`title="a" && body="b`:    19,
+	} {
+		_, err := Compile(rule)
+		var se *SyntaxError
+		if !errors.As(err, &se) {
+			t.Errorf("%s: got %v, want syntax error", rule, err)
+			continue
+		}
+		if se.Column != column {
+			t.Errorf("%s: got %v, want column %d", rule, err, column)
+		}
+	}
 }
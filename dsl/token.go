@@ -15,27 +15,41 @@ type Token struct {
 	name    string // token type name
 	content string // actual content of the token
 	number  int    // number token value
+	pos     int    // column of the token, 1-based and counted in runes
+	cs      bool   // case-sensitive text, written as c"..."
 }
 
 // Constants defining different types of tokens
 const (
 	// Content type tokens
-	tokenStatus = "status" // matches status code
-	tokenBody   = "body"   // matches body content
-	tokenHeader = "header" // matches HTTP headers
-	tokenIcon   = "icon"   // matches icon content
-	tokenText   = "text"   // matches text content
-	tokenNumber = "number" // matches number
+	tokenStatus        = "status"         // matches status code
+	tokenBody          = "body"           // matches body content
+	tokenHeader        = "header"         // matches HTTP headers, header["Name"] matches a single header
+	tokenIcon          = "icon"           // matches icon content
+	tokenTitle         = "title"          // matches html title
+	tokenServer        = "server"         // matches Server header
+	tokenCookie        = "cookie"         // matches Set-Cookie headers
+	tokenMeta          = "meta"           // meta["name"] matches the content of <meta name>
+	tokenURL           = "url"            // matches request url
+	tokenPath          = "path"           // matches request path
+	tokenCert          = "cert"           // matches certificate subject, issuer and SANs
+	tokenContentLength = "content_length" // matches body length
+	tokenText          = "text"           // matches text content
+	tokenNumber        = "number"         // matches number
 
 	// Comparison operators
 	tokenContains   = "="  // contains operator
 	tokenFullEqual  = "==" // exact match operator
 	tokenNotEqual   = "!=" // not equal operator
 	tokenRegexEqual = "~=" // regex match operator
+	tokenStartsWith = "^=" // prefix operator
+	tokenEndsWith   = "$=" // suffix operator
+	tokenIn         = "in" // list membership operator
 
 	// Logical operators
 	tokenAnd = "&&" // logical AND
 	tokenOr  = "||" // logical OR
+	tokenNot = "!"  // logical NOT
 
 	tokenGt  = ">" // greater than
 	tokenGte = ">="
@@ -45,59 +59,71 @@ const (
 	// Parentheses
 	tokenLeftBracket  = "("
 	tokenRightBracket = ")"
+
+	// Lists and header names
+	tokenLeftSquare  = "["
+	tokenRightSquare = "]"
+	tokenComma       = ","
 )
 
-// ParseTokens converts input string to token sequence, supporting text content(quoted),
-// comparison ops(=,==,!=,~=), logical ops(&&,||), parentheses and keywords(body,header,icon)
+// keywords 字段名和关键字运算符, 只匹配完整的单词
+var keywords = []string{
+	tokenStatus, tokenBody, tokenHeader, tokenIcon, tokenTitle, tokenServer, tokenCookie,
+	tokenMeta, tokenURL, tokenPath, tokenCert, tokenContentLength, tokenIn,
+}
+
+// ParseTokens converts input string to token sequence, supporting text content(quoted, c"..." for case-sensitive),
+// comparison ops(=,==,!=,~=,^=,$=,in), logical ops(&&,||,!), parentheses, lists([a,b]) and whole keywords
+// (status,body,header,icon,title,server,cookie,meta,url,path,cert,content_length)
 func ParseTokens(s1 string) ([]Token, error) {
-	return parseTokensWithOptions(s1, []string{tokenStatus, tokenBody, tokenHeader, tokenIcon})
+	return parseTokensWithOptions(s1, keywords)
 }
 
 // parseTokensWithOptions 提取Token的公共解析函数
 func parseTokensWithOptions(s1 string, validKeywords []string) ([]Token, error) {
-	brackets := map[rune]string{'(': tokenLeftBracket, ')': tokenRightBracket}
+	brackets := map[rune]string{
+		'(': tokenLeftBracket, ')': tokenRightBracket,
+		'[': tokenLeftSquare, ']': tokenRightSquare,
+		',': tokenComma,
+	}
 
 	s, tokens := []rune(s1), []Token{}
 	for i := 0; i < len(s); {
-		x := s[i]
+		var (
+			x     = s[i]
+			token Token
+			next  int
+			err   error
+		)
 		switch true {
 		case runeContains(x, '"'):
-			token, newPos, err := parseQuotedText(s, i)
-			if err != nil {
-				return nil, err
-			}
-			tokens = append(tokens, token)
-			i = newPos + 1
-		case runeContains(x, '=', '~', '!', '|', '&', '>', '<'):
-			token, skip, err := parseOperator(s[i:])
-			if err != nil {
-				return nil, err
-			}
-			tokens = append(tokens, token)
-			i += skip
-		case runeContains(x, '(', ')'):
-			tokens = append(tokens, Token{
-				name:    brackets[x],
-				content: string(x),
-			})
-			i++
-		case unicode.IsDigit(x):
-			token, newPos, err := parseNumber(s[i:])
-			if err != nil {
-				return nil, err
-			}
-			tokens = append(tokens, token)
-			i += newPos
+			token, next, err = parseQuotedText(s, i)
+			next++
+		case x == 'c' && i+1 < len(s) && s[i+1] == '"': // case-sensitive text
+			token, next, err = parseQuotedText(s, i+1)
+			token.cs = true
+			next++
+		case runeContains(x, '=', '~', '!', '|', '&', '>', '<', '^', '$'):
+			token, next, err = parseOperator(s[i:])
+			next += i
+		case runeContains(x, '(', ')', '[', ']', ','):
+			token, next = Token{name: brackets[x], content: string(x)}, i+1
+		case unicode.IsDigit(x) || (x == '-' && i+1 < len(s) && unicode.IsDigit(s[i+1])): // icon 哈希可能为负数
+			token, next, err = parseNumber(s[i:])
+			next += i
 		case runeContains(x, ' ', '\t', '\n', '\r'): // skip whitespace
 			i++
+			continue
 		default:
-			token, newPos, err := parseKeyword(s[i:], validKeywords)
-			if err != nil {
-				return nil, err
-			}
-			tokens = append(tokens, token)
-			i += newPos
+			token, next, err = parseKeyword(s[i:], validKeywords)
+			next += i
+		}
+		if err != nil {
+			return nil, &SyntaxError{Column: i + 1, Msg: err.Error()}
 		}
+		token.pos = i + 1
+		tokens = append(tokens, token)
+		i = next
 	}
 	return tokens, nil
 }
@@ -107,7 +133,7 @@ func parseQuotedText(s []rune, start int) (Token, int, error) {
 	var n []rune
 	i := start + 1
 	for i < len(s) {
-		if s[i] == '\\' { // skip escape '\"'
+		if s[i] == '\\' && i+1 < len(s) { // skip escape '\"'
 			n = append(n, s[i+1])
 			i += 2
 		} else if s[i] == '"' { // end of quoted
@@ -117,7 +143,7 @@ func parseQuotedText(s []rune, start int) (Token, int, error) {
 			i++
 		}
 	}
-	return Token{}, 0, errors.New("unknown text:" + string(s[start:]))
+	return Token{}, 0, errors.New("unterminated text: " + string(s[start:]))
 }
 
 // 辅助函数：解析操作符
@@ -129,7 +155,10 @@ func parseOperator(s []rune) (Token, int, error) {
 		{tokenFullEqual, "==", 2},
 		{tokenContains, "=", 1},
 		{tokenRegexEqual, "~=", 2},
+		{tokenStartsWith, "^=", 2},
+		{tokenEndsWith, "$=", 2},
 		{tokenNotEqual, "!=", 2},
+		{tokenNot, "!", 1},
 		{tokenOr, "||", 2},
 		{tokenAnd, "&&", 2},
 		{tokenGte, ">=", 2},
@@ -142,21 +171,21 @@ func parseOperator(s []rune) (Token, int, error) {
 			return Token{name: op.name, content: op.content}, op.skip, nil
 		}
 	}
-	return Token{}, 0, errors.New("invalid operator")
+	return Token{}, 0, errors.New("invalid operator " + string(s[0]))
 }
 
 // 辅助函数：解析数字
 func parseNumber(s []rune) (Token, int, error) {
 	var num []rune
 
-	for _, char := range s {
-		if !unicode.IsDigit(char) {
+	for i, char := range s {
+		if !unicode.IsDigit(char) && !(i == 0 && char == '-') {
 			break
 		}
 		num = append(num, char)
 	}
 
-	if len(num) == 0 {
+	if len(num) == 0 || (len(num) == 1 && num[0] == '-') {
 		return Token{}, 0, errors.New("unknown number")
 	}
 
@@ -168,18 +197,25 @@ func parseNumber(s []rune) (Token, int, error) {
 	return Token{name: tokenNumber, content: string(num), number: val}, len(num), nil
 }
 
-// 辅助函数：解析关键字
+// 辅助函数：解析关键字, 关键字必须是完整的单词, 如 bodyx 不会被识别为 body
 func parseKeyword(s []rune, validKeywords []string) (Token, int, error) {
-	textOption := string(s)
+	n := 0
+	for n < len(s) && (s[n] == '_' || unicode.IsLetter(s[n]) || (n > 0 && unicode.IsDigit(s[n]))) {
+		n++
+	}
+	if n == 0 {
+		return Token{}, 0, errors.New("unexpected character " + strconv.QuoteRune(s[0]))
+	}
+	word := string(s[:n])
 	for _, check := range validKeywords {
-		if strings.HasPrefix(textOption, check) {
+		if word == check {
 			return Token{
 				name:    check,
 				content: check,
-			}, len(check), nil
+			}, n, nil
 		}
 	}
-	return Token{}, 0, errors.New("unknown text: " + textOption)
+	return Token{}, 0, errors.New("unknown keyword " + word)
 }
 
 func runeContains(x rune, prefix ...rune) bool {
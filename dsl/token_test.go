@@ -1,6 +1,9 @@
 package dsl
 
-import "testing"
+import (
+	"strings"
+	"testing"
+)
 
 func TestParseTokens(t *testing.T) {
 	s := `body="href=\"http://www.thinkphp.cn\">thinkphp</a>" || body="thinkphp_show_page_trace" || icon="f49c4a4bde1eec6c0b80c2277c76e3dbs"`
@@ -28,3 +31,28 @@ func TestParseTokens3(t *testing.T) {
 	}
 	t.Log(tokens)
 }
+
+func TestParseKeywords(t *testing.T) {
+	tokens, err := ParseTokens(`content_length<100 && header["Server"] in [c"nginx", "iis"]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, token := range tokens {
+		names = append(names, token.name)
+	}
+	want := []string{tokenContentLength, tokenLt, tokenNumber, tokenAnd, tokenHeader, tokenLeftSquare, tokenText,
+		tokenRightSquare, tokenIn, tokenLeftSquare, tokenText, tokenComma, tokenText, tokenRightSquare}
+	if strings.Join(names, " ") != strings.Join(want, " ") {
+		t.Errorf("got %v, want %v", names, want)
+	}
+	if !tokens[10].cs || tokens[12].cs {
+		t.Errorf("case-sensitive text not recognized: %+v", tokens[10:13])
+	}
+
+	for _, s := range []string{`bodyx="a"`, `titles="a"`, `body_="a"`, `status2==1`} {
+		if _, err := ParseTokens(s); err == nil {
+			t.Errorf("%s: expected unknown keyword", s)
+		}
+	}
+}
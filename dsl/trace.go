@@ -0,0 +1,270 @@
+package dsl
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Trace 子表达式的求值结果, 用于说明规则为什么匹配或不匹配
+type Trace struct {
+	Expr     string   `json:"expr"`            // 子表达式的规范化源码
+	Field    string   `json:"field,omitempty"` // 比较的字段, 如 body、header["Server"]
+	Value    string   `json:"value,omitempty"` // 数值字段的值
+	Result   bool     `json:"result"`
+	Skipped  bool     `json:"skipped,omitempty"` // 因短路没有求值
+	Spans    []Span   `json:"spans,omitempty"`   // 字段中匹配的文本
+	Children []*Trace `json:"children,omitempty"`
+}
+
+// Span 字段中匹配的文本, Start 和 End 为字段值中的字节偏移
+type Span struct {
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	Text  string `json:"text"`
+}
+
+// String 以缩进的树形式输出, 每行为结果和子表达式
+func (t *Trace) String() string {
+	var b strings.Builder
+	var write func(t *Trace, level int)
+	write = func(t *Trace, level int) {
+		b.WriteString(strings.Repeat("  ", level))
+		switch {
+		case t.Skipped:
+			b.WriteString("skip  ")
+		case t.Result:
+			b.WriteString("true  ")
+		default:
+			b.WriteString("false ")
+		}
+		b.WriteString(t.Expr)
+		if t.Value != "" {
+			fmt.Fprintf(&b, " (%s=%s)", t.Field, t.Value)
+		}
+		for _, s := range t.Spans {
+			fmt.Fprintf(&b, " %s[%d:%d]=%q", t.Field, s.Start, s.End, s.Text)
+		}
+		b.WriteByte('\n')
+		for _, c := range t.Children {
+			write(c, level+1)
+		}
+	}
+	write(t, 0)
+	return b.String()
+}
+
+// Trace 求值并返回每个子表达式的结果, 短路未求值的子表达式标记为 Skipped
+func (r *Rule) Trace(config *Config) (*Trace, error) {
+	if r == nil || r.root == nil {
+		return nil, errors.New("empty rule")
+	}
+	if config == nil {
+		return nil, errors.New("nil config")
+	}
+	_, t, err := (&evaluator{config: config, trace: true}).eval(r.root)
+	return t, err
+}
+
+// evaluator 遍历表达式树求值, trace 为 false 时不记录结果
+type evaluator struct {
+	config *Config
+	trace  bool
+}
+
+func (e *evaluator) node(expr Expr) *Trace {
+	if !e.trace {
+		return nil
+	}
+	return &Trace{Expr: expr.String()}
+}
+
+func (e *evaluator) eval(expr Expr) (bool, *Trace, error) {
+	t := e.node(expr)
+	var (
+		r   bool
+		err error
+	)
+	switch next := expr.(type) {
+	case *matchExpr:
+		r, err = e.evalText(next, t)
+	case *matchNumberExpr:
+		r, err = e.evalNumber(next, t)
+	case *inExpr:
+		r, err = e.evalIn(next, t)
+	case *logicExpr:
+		if next.op != tokenAnd && next.op != tokenOr {
+			return false, nil, fmt.Errorf("unknown logic operator %s", next.op)
+		}
+		var left, right *Trace
+		if r, left, err = e.eval(next.left); err != nil {
+			return false, nil, err
+		}
+		// short-circuit evaluation
+		if (next.op == tokenAnd && !r) || (next.op == tokenOr && r) {
+			if t != nil {
+				right = &Trace{Expr: next.right.String(), Skipped: true}
+			}
+		} else if r, right, err = e.eval(next.right); err != nil {
+			return false, nil, err
+		}
+		if t != nil {
+			t.Children = []*Trace{left, right}
+		}
+	case *notExpr:
+		var inner *Trace
+		if r, inner, err = e.eval(next.inner); err != nil {
+			return false, nil, err
+		}
+		r = !r
+		if t != nil {
+			t.Children = []*Trace{inner}
+		}
+	case *bracketExpr:
+		var inner *Trace
+		if r, inner, err = e.eval(next.inner); err != nil {
+			return false, nil, err
+		}
+		if t != nil {
+			t.Children = []*Trace{inner}
+		}
+	default:
+		err = fmt.Errorf("unknown expression %T", expr)
+	}
+	if err != nil {
+		return false, nil, err
+	}
+	if t != nil {
+		t.Result = r
+	}
+	return r, t, nil
+}
+
+func (e *evaluator) evalText(m *matchExpr, t *Trace) (bool, error) {
+	raw, ok := e.config.text(m.left, m.key)
+	if !ok {
+		return false, fmt.Errorf("unknown text field %s", m.left)
+	}
+	// 正则已按需用 (?i) 编译, 直接匹配原始字段
+	s1, text := raw, m.right
+	if !m.cs && m.op != tokenRegexEqual {
+		s1 = strings.ToLower(s1)
+		text = strings.ToLower(text)
+	}
+
+	// start < 0 表示没有匹配的文本
+	var (
+		r          bool
+		start, end = -1, -1
+	)
+	switch m.op {
+	case tokenFullEqual:
+		if r = s1 == text; r {
+			start, end = 0, len(s1)
+		}
+	case tokenContains, tokenNotEqual:
+		if start = strings.Index(s1, text); start >= 0 {
+			end = start + len(text)
+		}
+		r = (start >= 0) == (m.op == tokenContains)
+	case tokenStartsWith:
+		if r = strings.HasPrefix(s1, text); r {
+			start, end = 0, len(text)
+		}
+	case tokenEndsWith:
+		if r = strings.HasSuffix(s1, text); r {
+			start, end = len(s1)-len(text), len(s1)
+		}
+	case tokenRegexEqual:
+		if m.cacheRegx == nil {
+			return false, fmt.Errorf("regex %q is not compiled", m.right)
+		}
+		if t == nil {
+			r = m.cacheRegx.MatchString(s1)
+			break
+		}
+		if loc := m.cacheRegx.FindStringIndex(s1); loc != nil {
+			r, start, end = true, loc[0], loc[1]
+		}
+	default:
+		return false, fmt.Errorf("unknown text operator %s", m.op)
+	}
+
+	if t != nil {
+		t.Field = fieldString(m.left, m.key)
+		if start >= 0 {
+			t.Spans = []Span{newSpan(raw, s1, start, end)}
+		}
+	}
+	return r, nil
+}
+
+// newSpan 偏移是在 s1 (可能已转为小写) 上计算的, 长度不变时取原文
+func newSpan(raw, s1 string, start, end int) Span {
+	if len(raw) == len(s1) {
+		return Span{Start: start, End: end, Text: raw[start:end]}
+	}
+	return Span{Start: start, End: end, Text: s1[start:end]}
+}
+
+func (e *evaluator) evalNumber(m *matchNumberExpr, t *Trace) (bool, error) {
+	n1, ok := e.config.number(m.left)
+	if !ok {
+		return false, fmt.Errorf("unknown numeric field %s", m.left)
+	}
+	// 字段在左, 常量在右, 如 status>200 即 config.Status > 200
+	var r bool
+	switch m.op {
+	case tokenFullEqual:
+		r = n1 == m.right
+	case tokenNotEqual:
+		r = n1 != m.right
+	case tokenGt:
+		r = n1 > m.right
+	case tokenGte:
+		r = n1 >= m.right
+	case tokenLt:
+		r = n1 < m.right
+	case tokenLte:
+		r = n1 <= m.right
+	default:
+		return false, fmt.Errorf("unknown numeric operator %s", m.op)
+	}
+
+	if t != nil {
+		t.Field, t.Value = m.left, strconv.Itoa(n1)
+	}
+	return r, nil
+}
+
+func (e *evaluator) evalIn(in *inExpr, t *Trace) (bool, error) {
+	if n1, ok := e.config.number(in.left); ok {
+		if t != nil {
+			t.Field, t.Value = in.left, strconv.Itoa(n1)
+		}
+		for _, v := range in.values {
+			if v.number == n1 {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	s1, ok := e.config.text(in.left, in.key)
+	if !ok {
+		return false, fmt.Errorf("unknown field %s", in.left)
+	}
+	if t != nil {
+		t.Field = fieldString(in.left, in.key)
+	}
+	for _, v := range in.values {
+		if v.content == s1 || (!v.cs && strings.EqualFold(v.content, s1)) {
+			if t != nil {
+				t.Spans = []Span{{Start: 0, End: len(s1), Text: s1}}
+			}
+			return true, nil
+		}
+	}
+	return false, nil
+}
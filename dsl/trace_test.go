@@ -0,0 +1,93 @@
+package dsl
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTrace(t *testing.T) {
+	r, err := Compile(`status==200 && (title="NGINX" || body~="h[0-9]") && !server^="apache"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr, err := r.Trace(evalConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `true  status==200 && (title="NGINX" || body~="h[0-9]") && !server^="apache"
+  true  status==200 && (title="NGINX" || body~="h[0-9]")
+    true  status==200 (status=200)
+    true  (title="NGINX" || body~="h[0-9]")
+      true  title="NGINX" || body~="h[0-9]"
+        true  title="NGINX" title[11:16]="Nginx"
+        skip  body~="h[0-9]"
+  true  !server^="apache"
+    false server^="apache"
+`
+	if got := tr.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	r, _ = Compile(`body~="h[0-9]" && header["x-powered-by"] in ["php/8.1.2"] && body!="nginx"`)
+	if tr, err = r.Trace(evalConfig); err != nil {
+		t.Fatal(err)
+	}
+	regex, in, not := tr.Children[0].Children[0], tr.Children[0].Children[1], tr.Children[1]
+	if s := regex.Spans; len(s) != 1 || s[0] != (Span{Start: 1, End: 3, Text: "h1"}) {
+		t.Errorf("regex spans %+v", s)
+	}
+	if in.Field != `header["X-Powered-By"]` || len(in.Spans) != 1 || in.Spans[0].Text != "PHP/8.1.2" {
+		t.Errorf("in trace %+v", in)
+	}
+	// != 不匹配时给出找到的位置
+	if not.Result || len(not.Spans) != 1 || not.Spans[0].Start != 10 {
+		t.Errorf("not-contains trace %+v", not)
+	}
+}
+
+func TestString(t *testing.T) {
+	for rule, want := range map[string]string{
+		`status  ==200&&title = "a"`:                                   `status==200 && title="a"`,
+		`(body="a"||body="b")&&!(title="c"||title="d")`:                `(body="a" || body="b") && !(title="c" || title="d")`,
+		`header["x-powered-by"]^=c"PHP" || server in ["nginx",c"IIS"]`: `header["X-Powered-By"]^=c"PHP" || server in ["nginx", c"IIS"]`,
+		`body="a\"b\\c" && status in [200,-1]`:                         `body="a\"b\\c" && status in [200, -1]`,
+		`body~="\\d+\\.\\d+"`:                                          `body~="\\d+\\.\\d+"`,
+		`!!title="x"`:                                                  `!!title="x"`,
+	} {
+		r, err := Compile(rule)
+		if err != nil {
+			t.Fatalf("%s: %v", rule, err)
+		}
+		if got := r.String(); got != want {
+			t.Errorf("%s: got %s, want %s", rule, got, want)
+		}
+	}
+
+	// 规范化源码重新解析后结果和源码都不变
+	for _, tt := range evalTests {
+		if tt.err {
+			continue
+		}
+		r, _ := Compile(tt.rule)
+		r2, err := Compile(r.String())
+		if err != nil {
+			t.Fatalf("%s => %s: %v", tt.rule, r, err)
+		}
+		if r2.String() != r.String() {
+			t.Errorf("%s: %s != %s", tt.rule, r2, r)
+		}
+		if got, _ := r2.Eval(evalConfig); got != tt.want {
+			t.Errorf("%s => %s: got %v, want %v", tt.rule, r2, got, tt.want)
+		}
+	}
+
+	// 手工构造的右结合树需要加括号
+	r := &Rule{root: &logicExpr{
+		op:    tokenOr,
+		left:  &matchExpr{left: tokenBody, op: tokenContains, right: "a"},
+		right: &logicExpr{op: tokenOr, left: &matchExpr{left: tokenBody, op: tokenContains, right: "b"}, right: &matchExpr{left: tokenBody, op: tokenContains, right: "c"}},
+	}}
+	if got := r.String(); !strings.Contains(got, `(body="b" || body="c")`) {
+		t.Errorf("got %s", got)
+	}
+}
@@ -0,0 +1,118 @@
+package fingerprint
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BreakOnCrash/opendast/pkg/httpx"
+)
+
+// Certificate 证书信息, 时间为 RFC 3339 格式的 UTC 时间
+type Certificate struct {
+	Subject    string   `fingerprint:"subject" json:"subject"`
+	SubjectCN  string   `fingerprint:"subject_cn" json:"subject_cn"`
+	Issuer     string   `fingerprint:"issuer" json:"issuer"`
+	IssuerCN   string   `fingerprint:"issuer_cn" json:"issuer_cn"`
+	SANs       []string `fingerprint:"sans" json:"sans,omitempty"` // DNS、IP、邮箱和 URI
+	Serial     string   `fingerprint:"serial" json:"serial"`       // 十六进制
+	NotBefore  string   `fingerprint:"not_before" json:"not_before"`
+	NotAfter   string   `fingerprint:"not_after" json:"not_after"`
+	KeyType    string   `fingerprint:"key_type" json:"key_type"` // 如 RSA-2048、ECDSA-P-256、Ed25519
+	Signature  string   `fingerprint:"signature" json:"signature"`
+	SelfSigned bool     `fingerprint:"self_signed" json:"self_signed"`
+	SHA256     string   `fingerprint:"sha256" json:"sha256"`
+}
+
+// Cert TLS 连接信息, 字段为服务端证书, Chain 为服务端发送的其余证书
+type Cert struct {
+	Certificate `fingerprint:",inline"`
+
+	Chain    []Certificate `fingerprint:"chain" json:"chain,omitempty"`
+	Protocol string        `fingerprint:"protocol" json:"protocol"` // 如 TLS 1.3
+	Cipher   string        `fingerprint:"cipher" json:"cipher"`
+	ALPN     string        `fingerprint:"alpn" json:"alpn,omitempty"`
+	JA3S     string        `fingerprint:"ja3s" json:"ja3s,omitempty"`
+}
+
+// text 服务端证书的主题、颁发者和 SAN, 以换行分隔, 用于 dsl 的 cert 字段
+func (c *Cert) text() string {
+	if c.SHA256 == "" {
+		return ""
+	}
+	return strings.Join(append([]string{c.Subject, c.Issuer}, c.SANs...), "\n")
+}
+
+func newCert(state *tls.ConnectionState, hello *httpx.ServerHello) Cert {
+	c := Cert{
+		Protocol: tls.VersionName(state.Version),
+		Cipher:   tls.CipherSuiteName(state.CipherSuite),
+		ALPN:     state.NegotiatedProtocol,
+	}
+	if hello != nil {
+		c.JA3S = hello.JA3S()
+	}
+	for i, x := range state.PeerCertificates {
+		if i == 0 {
+			c.Certificate = newCertificate(x)
+			continue
+		}
+		c.Chain = append(c.Chain, newCertificate(x))
+	}
+	return c
+}
+
+func newCertificate(x *x509.Certificate) Certificate {
+	c := Certificate{
+		Subject:    x.Subject.String(),
+		SubjectCN:  x.Subject.CommonName,
+		Issuer:     x.Issuer.String(),
+		IssuerCN:   x.Issuer.CommonName,
+		Serial:     x.SerialNumber.Text(16),
+		NotBefore:  x.NotBefore.UTC().Format(time.RFC3339),
+		NotAfter:   x.NotAfter.UTC().Format(time.RFC3339),
+		KeyType:    keyType(x.PublicKey),
+		Signature:  x.SignatureAlgorithm.String(),
+		SelfSigned: selfSigned(x),
+	}
+
+	c.SANs = append(c.SANs, x.DNSNames...)
+	for _, ip := range x.IPAddresses {
+		c.SANs = append(c.SANs, ip.String())
+	}
+	c.SANs = append(c.SANs, x.EmailAddresses...)
+	for _, u := range x.URIs {
+		c.SANs = append(c.SANs, u.String())
+	}
+
+	sum := sha256.Sum256(x.Raw)
+	c.SHA256 = hex.EncodeToString(sum[:])
+	return c
+}
+
+// selfSigned 颁发者与主题相同且由自身的密钥签名.
+// 不用 CheckSignatureFrom, 它要求签发者是 CA, 设备常见的自签名叶子证书没有 CA 基本约束
+func selfSigned(x *x509.Certificate) bool {
+	return bytes.Equal(x.RawIssuer, x.RawSubject) &&
+		x.CheckSignature(x.SignatureAlgorithm, x.RawTBSCertificate, x.Signature) == nil
+}
+
+func keyType(pub any) string {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return "RSA-" + strconv.Itoa(k.N.BitLen())
+	case *ecdsa.PublicKey:
+		return "ECDSA-" + k.Curve.Params().Name
+	case ed25519.PublicKey:
+		return "Ed25519"
+	}
+	return "unknown"
+}
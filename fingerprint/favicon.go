@@ -0,0 +1,92 @@
+package fingerprint
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/BreakOnCrash/opendast/pkg/mmh3"
+)
+
+const maxIconSize = 1 << 20
+
+var errIconNotFound = errors.New("icon not found")
+
+// fetchIcons 获取 <link rel=icon> 指向的图标和 /favicon.ico 并计算哈希,
+// 返回首选图标地址及其哈希, 以及 /favicon.ico 的哈希
+func fetchIcons(ctx context.Context, base *url.URL, links []string) (iconURL string, iconHash, faviconHash float64) {
+	favicon := base.ResolveReference(&url.URL{Path: "/favicon.ico"}).String()
+	if data, err := fetchIcon(ctx, favicon); err == nil {
+		faviconHash = float64(mmh3.FaviconHash(data))
+		iconURL, iconHash = favicon, faviconHash
+	}
+
+	for _, link := range links {
+		ref, err := url.Parse(strings.TrimSpace(link))
+		if err != nil {
+			continue
+		}
+		u := base.ResolveReference(ref)
+		if u.String() == favicon {
+			break
+		}
+
+		var data []byte
+		if u.Scheme == "data" {
+			data, err = decodeDataURL(u.Opaque)
+		} else {
+			data, err = fetchIcon(ctx, u.String())
+		}
+		if err != nil {
+			continue
+		}
+		if u.Scheme == "data" {
+			iconURL = "data:"
+		} else {
+			iconURL = u.String()
+		}
+		iconHash = float64(mmh3.FaviconHash(data))
+		break
+	}
+	return
+}
+
+func fetchIcon(ctx context.Context, URL string) ([]byte, error) {
+	resp, err := httpclient.Get(ctx, URL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errIconNotFound
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxIconSize))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, errIconNotFound
+	}
+	return data, nil
+}
+
+// decodeDataURL 解析 data:[<mediatype>][;base64],<data> 中 "data:" 之后的部分
+func decodeDataURL(opaque string) ([]byte, error) {
+	meta, payload, ok := strings.Cut(opaque, ",")
+	if !ok || payload == "" {
+		return nil, errIconNotFound
+	}
+	if strings.HasSuffix(meta, ";base64") {
+		return base64.StdEncoding.DecodeString(payload)
+	}
+	s, err := url.PathUnescape(payload)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(s), nil
+}
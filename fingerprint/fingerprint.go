@@ -3,36 +3,86 @@ package fingerprint
 import (
 	"context"
 	"strings"
+	"sync"
 
 	"github.com/PaesslerAG/gval"
 )
 
+// functions 表达式中可用的函数
+var functions = map[string]any{
+	"md5":      Md5,
+	"mmh3":     MMH3,
+	"iconhash": IconHash,
+	"base64":   Base64,
+
+	"regex":    Regex,
+	"find":     Find,
+	"contains": Contains,
+	"includes": strings.Contains, // 区分大小写
+	"match":    RegexCase,        // 区分大小写的正则
+	"equals":   Equals,
+	"starts":   strings.HasPrefix,
+	"ends":     strings.HasSuffix,
+	"lower":    strings.ToLower,
+	"upper":    strings.ToUpper,
+	"trim":     strings.TrimSpace,
+}
+
+var (
+	language = gval.Full()
+
+	evaluables sync.Map // expression → gval.Evaluable, 只缓存规则中的表达式
+)
+
+// Compile 编译表达式, 相同表达式只解析一次.
+// 编译结果在进程内一直缓存, 只用于规则等数量有限的表达式, MatchSample 的临时表达式不经过缓存
+func Compile(expression string) (gval.Evaluable, error) {
+	if v, ok := evaluables.Load(expression); ok {
+		return v.(gval.Evaluable), nil
+	}
+	eval, err := language.NewEvaluable(expression)
+	if err != nil {
+		return nil, err
+	}
+	evaluables.Store(expression, eval)
+	return eval, nil
+}
+
+// parameters 构造 sample 的表达式参数
+func parameters(sample *Sample) (map[string]any, error) {
+	v, err := NewSelectWrapper(sample, "fingerprint")
+	if err != nil {
+		return nil, err
+	}
+
+	params := make(map[string]any, len(functions)+1)
+	for k, f := range functions {
+		params[k] = f
+	}
+	params["resp"] = v
+	return params, nil
+}
+
 func Match(ctx context.Context, URL, expression string) (interface{}, error) {
 	sample, err := MakeSample(ctx, URL)
 	if err != nil {
 		return nil, err
 	}
 
-	v, err := NewSelectWrapper(sample, "fingerprint")
+	return MatchSample(ctx, sample, expression)
+}
+
+// MatchSample 在已获取的 sample 上计算表达式
+func MatchSample(ctx context.Context, sample *Sample, expression string) (interface{}, error) {
+	// 临时表达式不放入缓存, 避免缓存随调用无限增长
+	eval, err := language.NewEvaluable(expression)
 	if err != nil {
 		return nil, err
 	}
 
-	return gval.Evaluate(expression, map[string]any{
-		"resp": v,
-
-		"md5":    Md5,
-		"mmh3":   MMH3,
-		"base64": Base64,
-
-		"regex":    Regex,
-		"find":     Find,
-		"contains": Contains,
-		"equals":   Equals,
-		"starts":   strings.HasPrefix,
-		"ends":     strings.HasSuffix,
-		"lower":    strings.ToLower,
-		"upper":    strings.ToUpper,
-		"trim":     strings.TrimSpace,
-	})
+	params, err := parameters(sample)
+	if err != nil {
+		return nil, err
+	}
+	return eval(ctx, params)
 }
@@ -6,9 +6,11 @@ import (
 	"encoding/hex"
 	"regexp"
 	"regexp/syntax"
+	"strconv"
 	"strings"
 
 	"github.com/BreakOnCrash/opendast/pkg/bytesconv"
+	"github.com/BreakOnCrash/opendast/pkg/mmh3"
 )
 
 func Md5(strList ...string) string {
@@ -18,11 +20,15 @@ func Md5(strList ...string) string {
 	return hex.EncodeToString(h.Sum(nil))
 }
 
+// MMH3 返回 32 位有符号 MurmurHash3 的十进制字符串
 func MMH3(strList ...string) string {
 	str := strings.Join(strList, ":")
-	h := md5.New()
-	h.Write(bytesconv.StringToBytes(str))
-	return hex.EncodeToString(h.Sum(nil))
+	return strconv.Itoa(int(mmh3.Hash(bytesconv.StringToBytes(str))))
+}
+
+// IconHash 按 Shodan/FOFA 的方式计算图标哈希
+func IconHash(data string) float64 {
+	return float64(mmh3.FaviconHash(bytesconv.StringToBytes(data)))
 }
 
 func Base64(s string) string {
@@ -55,6 +61,12 @@ func Regex(s, pattern string) bool {
 	return rex.MatchString(s)
 }
 
+// RegexCase 区分大小写的正则匹配, 与 dsl 的 ~=c"..." 一致
+func RegexCase(s, pattern string) bool {
+	rex, err := regexp.Compile(pattern)
+	return err == nil && rex.MatchString(s)
+}
+
 func Find(s, pattern string, posList ...interface{}) string {
 	regex, err := syntax.Parse(pattern, syntax.DotNL|syntax.Perl|syntax.FoldCase|syntax.WasDollar)
 	if err != nil {
@@ -0,0 +1,124 @@
+package fingerprint
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultProbePool 单个目标并发探测的请求数
+const DefaultProbePool = 4
+
+// Probe 规则声明的额外探测请求, 相同的请求在一次扫描中只发送一次
+type Probe struct {
+	Method  string            `yaml:"method,omitempty" json:"method,omitempty"`
+	Path    string            `yaml:"path" json:"path"`
+	Headers map[string]string `yaml:"headers,omitempty" json:"headers,omitempty"`
+	Body    string            `yaml:"body,omitempty" json:"body,omitempty"`
+}
+
+func (p *Probe) normalize() {
+	p.Method = strings.ToUpper(p.Method)
+	if p.Method == "" {
+		p.Method = http.MethodGet
+	}
+	if !strings.HasPrefix(p.Path, "/") {
+		p.Path = "/" + p.Path
+	}
+}
+
+// Key 请求的唯一标识, 用于跨规则去重
+func (p *Probe) Key() string {
+	var b strings.Builder
+	b.WriteString(p.Method)
+	b.WriteByte(' ')
+	b.WriteString(p.Path)
+
+	keys := make([]string, 0, len(p.Headers))
+	for k := range p.Headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		b.WriteString("\n" + http.CanonicalHeaderKey(k) + ": " + p.Headers[k])
+	}
+	if p.Body != "" {
+		h := md5.Sum([]byte(p.Body))
+		b.WriteString("\n" + hex.EncodeToString(h[:]))
+	}
+	return b.String()
+}
+
+func (p *Probe) request(ctx context.Context, base *url.URL) (*http.Request, error) {
+	ref, err := url.Parse(p.Path)
+	if err != nil {
+		return nil, err
+	}
+	u := base.ResolveReference(ref)
+
+	var body io.Reader
+	if p.Body != "" {
+		body = strings.NewReader(p.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, p.Method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range p.Headers {
+		req.Header.Set(k, v)
+	}
+	return req, nil
+}
+
+// MakeProbeSamples 对 base 所在站点发送全部探测请求, 返回 Probe.Key → Sample,
+// 失败的请求不会出现在结果中
+func MakeProbeSamples(ctx context.Context, base string, probes []*Probe) (map[string]*Sample, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		mux     sync.Mutex
+		wg      sync.WaitGroup
+		samples = make(map[string]*Sample, len(probes))
+		sem     = make(chan struct{}, DefaultProbePool)
+	)
+	for _, p := range probes {
+		// 取消后不再等待空闲的并发位置
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return samples, ctx.Err()
+		}
+		wg.Add(1)
+		go func(p *Probe) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			req, err := p.request(ctx, u)
+			if err != nil {
+				return
+			}
+			sample, _, _, err := fetchSample(req)
+			if err != nil {
+				return
+			}
+			mux.Lock()
+			samples[p.Key()] = sample
+			mux.Unlock()
+		}(p)
+	}
+	wg.Wait()
+
+	return samples, ctx.Err()
+}
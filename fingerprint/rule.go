@@ -0,0 +1,590 @@
+package fingerprint
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/BreakOnCrash/opendast/dsl"
+	"github.com/PaesslerAG/gval"
+	"gopkg.in/yaml.v3"
+)
+
+// Matcher 匹配条件, expression (返回 bool 的 gval 表达式)
+// 和 dsl (FOFA/AI-Infra-Guard 风格, 如 body="x" && status==200) 二选一
+type Matcher struct {
+	Expression string `yaml:"expression,omitempty" json:"expression,omitempty"`
+	DSL        string `yaml:"dsl,omitempty" json:"dsl,omitempty"`
+
+	eval gval.Evaluable
+	dsl  *dsl.Rule
+}
+
+func (m *Matcher) empty() bool {
+	return m.Expression == "" && m.DSL == ""
+}
+
+func (m *Matcher) compile() error {
+	var err error
+	switch {
+	case m.Expression != "" && m.DSL != "":
+		return errors.New("expression and dsl are mutually exclusive")
+	case m.DSL != "":
+		if m.dsl, err = dsl.Compile(m.DSL); err != nil {
+			return fmt.Errorf("dsl: %w", err)
+		}
+	default:
+		if m.eval, err = Compile(m.Expression); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// match 计算条件, 出错 (如字段类型不符) 时返回错误
+func (m *Matcher) match(ctx context.Context, in *input) (bool, error) {
+	if m.dsl != nil && in.dsl != nil {
+		return in.dsl[m.dsl], nil
+	}
+	if m.dsl != nil {
+		return m.dsl.Eval(in.dslConfig())
+	}
+	return m.eval.EvalBool(ctx, in.params)
+}
+
+// RuleError 规则计算出错, 出错的条件视为不匹配, 其他规则的结果仍然有效
+type RuleError struct {
+	Rule string
+	Err  error
+}
+
+func (e *RuleError) Error() string {
+	return fmt.Sprintf("rule %s: %v", e.Rule, e.Err)
+}
+
+func (e *RuleError) Unwrap() error {
+	return e.Err
+}
+
+// ProbeMatcher 在额外探测请求的响应上计算的条件, versions 在该响应上提取版本
+type ProbeMatcher struct {
+	Probe    `yaml:",inline"`
+	Matcher  `yaml:",inline"`
+	Versions []*Extractor `yaml:"versions,omitempty" json:"versions,omitempty"`
+}
+
+// Rule 指纹规则, 首页条件和任意一个探测条件匹配即为命中.
+// version 为可选的返回版本字符串的 gval 表达式, 在首页上计算, 未取到时依次尝试 versions
+// 和探测请求的 versions; part 为 CPE 类型, 默认 a
+type Rule struct {
+	Name     string   `yaml:"name" json:"name"`
+	Product  string   `yaml:"product" json:"product"`
+	Vendor   string   `yaml:"vendor,omitempty" json:"vendor,omitempty"`
+	Part     string   `yaml:"part,omitempty" json:"part,omitempty"`
+	Tags     []string `yaml:"tags,omitempty" json:"tags,omitempty"`
+	Matcher  `yaml:",inline"`
+	Probes   []*ProbeMatcher `yaml:"probes,omitempty" json:"probes,omitempty"`
+	Version  string          `yaml:"version,omitempty" json:"version,omitempty"`
+	Versions []*Extractor    `yaml:"versions,omitempty" json:"versions,omitempty"`
+	Tests    []*RuleTest     `yaml:"tests,omitempty" json:"tests,omitempty"`
+
+	version gval.Evaluable
+	source  string // 规则文件路径, 用于定位测试用例的 fixture
+	fsys    fs.FS  // 内置规则所在的文件系统, 外部规则为 nil
+}
+
+// Result 规则匹配结果
+type Result struct {
+	Name    string   `json:"name"`
+	Product string   `json:"product"`
+	Vendor  string   `json:"vendor,omitempty"`
+	Version string   `json:"version,omitempty"`
+	CPE     string   `json:"cpe"`
+	Tags    []string `json:"tags,omitempty"`
+}
+
+func (r *Rule) compile() error {
+	if r.Name == "" {
+		return fmt.Errorf("rule without name: %q", r.Expression+r.DSL)
+	}
+	if r.Product == "" {
+		r.Product = r.Name
+	}
+	switch r.Part {
+	case "":
+		r.Part = "a"
+	case "a", "o", "h":
+	default:
+		return fmt.Errorf("rule %s: invalid cpe part %q", r.Name, r.Part)
+	}
+	if r.empty() && len(r.Probes) == 0 {
+		return fmt.Errorf("rule %s: no expression, dsl or probes", r.Name)
+	}
+
+	if !r.empty() {
+		if err := r.Matcher.compile(); err != nil {
+			return fmt.Errorf("rule %s: %w", r.Name, err)
+		}
+	}
+	for _, p := range r.Probes {
+		p.normalize()
+		if p.Matcher.empty() {
+			return fmt.Errorf("rule %s probe %s: no expression or dsl", r.Name, p.Path)
+		}
+		if err := p.Matcher.compile(); err != nil {
+			return fmt.Errorf("rule %s probe %s: %w", r.Name, p.Path, err)
+		}
+		for _, e := range p.Versions {
+			if err := e.compile(); err != nil {
+				return fmt.Errorf("rule %s probe %s version: %w", r.Name, p.Path, err)
+			}
+		}
+	}
+	for _, e := range r.Versions {
+		if err := e.compile(); err != nil {
+			return fmt.Errorf("rule %s version: %w", r.Name, err)
+		}
+	}
+	if r.Version != "" {
+		var err error
+		if r.version, err = Compile(r.Version); err != nil {
+			return fmt.Errorf("rule %s version: %w", r.Name, err)
+		}
+	}
+	return nil
+}
+
+// match 首页条件或任意探测条件匹配, probes 中缺失的响应视为不匹配.
+// 没有条件匹配时返回第一个计算出错的条件的 *RuleError
+func (r *Rule) match(ctx context.Context, main *input, probes map[string]*input) (bool, error) {
+	var first error
+	if !r.empty() {
+		ok, err := r.Matcher.match(ctx, main)
+		if ok {
+			return true, nil
+		}
+		if err != nil {
+			first = &RuleError{Rule: r.Name, Err: err}
+		}
+	}
+	for _, p := range r.Probes {
+		in, ok := probes[p.Key()]
+		if !ok {
+			continue
+		}
+		ok, err := p.Matcher.match(ctx, in)
+		if ok {
+			return true, nil
+		}
+		if err != nil && first == nil {
+			first = &RuleError{Rule: r.Name, Err: fmt.Errorf("probe %s: %w", p.Key(), err)}
+		}
+	}
+	return false, first
+}
+
+// input 单个响应的表达式参数和 dsl 输入, 在所有规则间共享
+type input struct {
+	sample *Sample
+	params map[string]any
+	config *dsl.Config
+	dsl    map[*dsl.Rule]bool // RuleDB 预先计算的 dsl 条件结果, 为 nil 时逐条计算
+}
+
+func newInput(sample *Sample) (*input, error) {
+	params, err := parameters(sample)
+	if err != nil {
+		return nil, err
+	}
+	return &input{sample: sample, params: params}, nil
+}
+
+func (in *input) dslConfig() *dsl.Config {
+	if in.config == nil {
+		in.config = in.sample.DSLConfig()
+	}
+	return in.config
+}
+
+// RuleDB 已编译的规则集合
+type RuleDB struct {
+	rules  []*Rule
+	names  map[string]bool
+	probes map[string]*Probe // Probe.Key → 去重后的探测请求
+	tech   *TechDB
+
+	mux      sync.Mutex
+	dslSet   *dsl.RuleSet // 所有 dsl 条件编译后的集合, Add 后重新构建
+	dslRules []*dsl.Rule  // dslSet 中下标对应的条件
+}
+
+func NewRuleDB(rules []*Rule) (*RuleDB, error) {
+	db := &RuleDB{}
+	if err := db.Add(rules...); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// LoadRules 从文件或目录加载规则, 支持 .yaml/.yml/.json, 每个文件为规则数组
+func LoadRules(paths ...string) (*RuleDB, error) {
+	db := &RuleDB{}
+	for _, p := range paths {
+		err := filepath.WalkDir(p, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() || !isRuleFile(path) {
+				return nil
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			rules, err := ParseRules(path, data)
+			if err != nil {
+				return err
+			}
+			for _, r := range rules {
+				r.source = path
+			}
+			return db.Add(rules...)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// ParseRules 按文件扩展名解析规则, yaml 文件也可以是单条 AI-Infra-Guard 格式的指纹
+func ParseRules(name string, data []byte) ([]*Rule, error) {
+	var rules []*Rule
+	if strings.EqualFold(filepath.Ext(name), ".json") {
+		if err := json.Unmarshal(data, &rules); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		return rules, nil
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+
+	var err error
+	if doc.Content[0].Kind == yaml.MappingNode {
+		var r *Rule
+		if r, err = parseAIGRule(doc.Content[0]); err == nil {
+			rules = append(rules, r)
+		}
+	} else {
+		err = doc.Content[0].Decode(&rules)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return rules, nil
+}
+
+// aigRule AI-Infra-Guard 的指纹格式
+type aigRule struct {
+	Info struct {
+		Name     string `yaml:"name"`
+		Metadata struct {
+			Product string `yaml:"product"`
+			Vendor  string `yaml:"vendor"`
+		} `yaml:"metadata"`
+	} `yaml:"info"`
+	HTTP []struct {
+		Method   string   `yaml:"method"`
+		Path     string   `yaml:"path"`
+		Matchers []string `yaml:"matchers"`
+	} `yaml:"http"`
+}
+
+// parseAIGRule 转换 AI-Infra-Guard 指纹: 首页 (GET /) 的 matchers 作为首页条件,
+// 其他请求转换为探测条件, 同一请求的多个 matcher 为或关系
+func parseAIGRule(node *yaml.Node) (*Rule, error) {
+	var a aigRule
+	if err := node.Decode(&a); err != nil {
+		return nil, err
+	}
+
+	r := &Rule{
+		Name:    a.Info.Name,
+		Product: a.Info.Metadata.Product,
+		Vendor:  a.Info.Metadata.Vendor,
+	}
+	for _, h := range a.HTTP {
+		if len(h.Matchers) == 0 {
+			continue
+		}
+		matchers := make([]string, len(h.Matchers))
+		for i, m := range h.Matchers {
+			matchers[i] = "(" + m + ")"
+		}
+		cond := strings.Join(matchers, " || ")
+
+		if (h.Method == "" || strings.EqualFold(h.Method, http.MethodGet)) && (h.Path == "" || h.Path == "/") {
+			if r.DSL != "" {
+				cond = r.DSL + " || " + cond
+			}
+			r.DSL = cond
+			continue
+		}
+		r.Probes = append(r.Probes, &ProbeMatcher{
+			Probe:   Probe{Method: h.Method, Path: h.Path},
+			Matcher: Matcher{DSL: cond},
+		})
+	}
+	if r.empty() && len(r.Probes) == 0 {
+		return nil, fmt.Errorf("rule %s: no matchers", a.Info.Name)
+	}
+	return r, nil
+}
+
+func isRuleFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
+
+// Add 编译并添加规则, 规则名不能重复
+func (db *RuleDB) Add(rules ...*Rule) error {
+	if db.names == nil {
+		db.names = make(map[string]bool)
+		db.probes = make(map[string]*Probe)
+	}
+
+	for _, r := range rules {
+		if err := r.compile(); err != nil {
+			return err
+		}
+		if db.names[r.Name] {
+			return fmt.Errorf("duplicate rule %s", r.Name)
+		}
+		db.names[r.Name] = true
+		db.rules = append(db.rules, r)
+		db.resetDSL()
+
+		for _, p := range r.Probes {
+			if _, ok := db.probes[p.Key()]; !ok {
+				db.probes[p.Key()] = &p.Probe
+			}
+		}
+	}
+	return nil
+}
+
+func (db *RuleDB) resetDSL() {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	db.dslSet, db.dslRules = nil, nil
+}
+
+// compiledDSL 返回所有 dsl 条件 (首页和探测条件) 编译后的集合, 第一次使用时构建
+func (db *RuleDB) compiledDSL() (*dsl.RuleSet, []*dsl.Rule, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	if db.dslSet != nil {
+		return db.dslSet, db.dslRules, nil
+	}
+
+	var rules []*dsl.Rule
+	for _, r := range db.rules {
+		if r.dsl != nil {
+			rules = append(rules, r.dsl)
+		}
+		for _, p := range r.Probes {
+			if p.dsl != nil {
+				rules = append(rules, p.dsl)
+			}
+		}
+	}
+	set, err := dsl.NewRuleSet(rules)
+	if err != nil {
+		return nil, nil, err
+	}
+	db.dslSet, db.dslRules = set, rules
+	return set, rules, nil
+}
+
+// prepare 一次计算 in 上的全部 dsl 条件
+func (db *RuleDB) prepare(in *input) error {
+	set, rules, err := db.compiledDSL()
+	if err != nil || set.Len() == 0 {
+		return err
+	}
+	matched, err := set.Match(in.dslConfig())
+	if err != nil {
+		return err
+	}
+	in.dsl = make(map[*dsl.Rule]bool, len(matched))
+	for _, i := range matched {
+		in.dsl[rules[i]] = true
+	}
+	return nil
+}
+
+// Probes 返回所有规则声明的探测请求, 已去重
+func (db *RuleDB) Probes() []*Probe {
+	probes := make([]*Probe, 0, len(db.probes))
+	for _, p := range db.probes {
+		probes = append(probes, p)
+	}
+	sort.Slice(probes, func(i, j int) bool {
+		return probes[i].Key() < probes[j].Key()
+	})
+	return probes
+}
+
+func (db *RuleDB) Len() int {
+	return len(db.rules)
+}
+
+func (db *RuleDB) Rules() []*Rule {
+	return db.rules
+}
+
+// Report 单个目标的扫描结果
+type Report struct {
+	URL          string       `json:"url"`
+	Results      []Result     `json:"results"`
+	Technologies []Technology `json:"technologies,omitempty"`
+
+	Sample *Sample `json:"-"`
+}
+
+// SetTechnologies 设置 Scan 使用的技术识别规则, 为空时不识别
+func (db *RuleDB) SetTechnologies(tech *TechDB) {
+	db.tech = tech
+}
+
+// Scan 获取 URL 首页和所有探测请求的响应, 计算全部规则并识别首页使用的技术
+func (db *RuleDB) Scan(ctx context.Context, URL string) (*Report, error) {
+	sample, err := MakeSample(ctx, URL)
+	if err != nil {
+		return nil, err
+	}
+	report := &Report{URL: URL, Sample: sample}
+
+	var probes map[string]*Sample
+	if len(db.probes) > 0 {
+		if probes, err = MakeProbeSamples(ctx, URL, db.Probes()); err != nil {
+			return report, err
+		}
+	}
+
+	// 规则出错时其他规则的结果仍然有效, 继续识别技术
+	var re *RuleError
+	if report.Results, err = db.MatchSamples(ctx, sample, probes); err != nil && !errors.As(err, &re) {
+		return report, err
+	}
+	if db.tech != nil {
+		report.Technologies = db.tech.Detect(sample)
+	}
+	return report, err
+}
+
+// Match 在 sample 上计算所有规则, 只有探测条件的规则不会命中
+func (db *RuleDB) Match(ctx context.Context, sample *Sample) ([]Result, error) {
+	return db.MatchSamples(ctx, sample, nil)
+}
+
+// MatchSamples 计算所有规则, probes 为 Probe.Key → 探测响应, 返回全部匹配结果.
+// 规则计算出错时仍返回其他规则的结果, 错误为各条规则的 *RuleError 的合并
+func (db *RuleDB) MatchSamples(ctx context.Context, sample *Sample, probes map[string]*Sample) ([]Result, error) {
+	main, err := newInput(sample)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.prepare(main); err != nil {
+		return nil, err
+	}
+	inputs := make(map[string]*input, len(probes))
+	for k, s := range probes {
+		if inputs[k], err = newInput(s); err != nil {
+			return nil, err
+		}
+		if err := db.prepare(inputs[k]); err != nil {
+			return nil, err
+		}
+	}
+
+	var errs []error
+	results := make([]Result, 0)
+	for _, r := range db.rules {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+		ok, err := r.match(ctx, main, inputs)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if !ok {
+			continue
+		}
+
+		res := Result{
+			Name:    r.Name,
+			Product: r.Product,
+			Vendor:  r.Vendor,
+			Version: r.extractVersion(ctx, main, inputs),
+			Tags:    r.Tags,
+		}
+		res.CPE = CPE(r.Part, r.Vendor, r.Product, res.Version)
+		results = append(results, res)
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Product < results[j].Product
+	})
+	return results, errors.Join(errs...)
+}
+
+//go:embed rules/*.yaml rules/fixtures
+var defaultRules embed.FS
+
+// DefaultRules 加载内置规则和技术识别规则
+func DefaultRules() (*RuleDB, error) {
+	db := &RuleDB{}
+	err := fs.WalkDir(defaultRules, "rules", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !isRuleFile(path) {
+			return err
+		}
+		data, err := defaultRules.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rules, err := ParseRules(path, data)
+		if err != nil {
+			return err
+		}
+		for _, r := range rules {
+			r.source, r.fsys = path, defaultRules
+		}
+		return db.Add(rules...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if db.tech, err = DefaultTechnologies(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
@@ -0,0 +1,440 @@
+package fingerprint
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/BreakOnCrash/opendast/dsl"
+)
+
+func TestRuleDB(t *testing.T) {
+	db, err := DefaultRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sample := &Sample{
+		StatusCode: 200,
+		Header:     "HTTP/1.1 200 OK\r\nServer: nginx/1.18.0\r\nX-Powered-By: PHP/7.4.3\r\n",
+		Server:     "nginx/1.18.0",
+		Body:       `<link rel="stylesheet" href="/wp-content/themes/x/style.css">`,
+		Meta:       map[string]string{"generator": "WordPress 6.4.2"},
+	}
+	results, err := db.Match(context.Background(), sample)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"nginx": "1.18.0", "php": "7.4.3", "wordpress": "6.4.2"}
+	if len(results) != len(want) {
+		t.Fatalf("got %+v, want %v", results, want)
+	}
+	for _, r := range results {
+		if v, ok := want[r.Product]; !ok || v != r.Version {
+			t.Errorf("unexpected result %+v", r)
+		}
+	}
+}
+
+func TestRuleMissingField(t *testing.T) {
+	db, err := DefaultRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 缺失的 meta/headers 字段按空字符串处理, 不影响其他条件
+	results, err := db.Match(context.Background(), &Sample{Body: `<script src="/wp-includes/js/wp-embed.min.js">`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Product != "wordpress" || results[0].Version != "" {
+		t.Fatalf("unexpected results %+v", results)
+	}
+}
+
+func TestRuleError(t *testing.T) {
+	db, err := NewRuleDB([]*Rule{
+		{Name: "bad", Matcher: Matcher{Expression: `starts(resp.status, "2")`}},
+		{Name: "good", Matcher: Matcher{Expression: `resp.status == 200`}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 出错的规则返回 *RuleError, 不影响其他规则的结果
+	results, err := db.Match(context.Background(), &Sample{StatusCode: 200})
+	var re *RuleError
+	if !errors.As(err, &re) || re.Rule != "bad" {
+		t.Fatalf("got %v, want rule error for bad", err)
+	}
+	if len(results) != 1 || results[0].Name != "good" {
+		t.Fatalf("unexpected results %+v", results)
+	}
+}
+
+func TestVersionExtractors(t *testing.T) {
+	db, err := DefaultRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := `<html><head>
+<script src="/wp-includes/js/jquery/jquery.min.js?ver=3.7.1"></script>
+<script src="/wp-includes/js/wp-embed.min.js?ver=6.4.2"></script>
+</head></html>`
+	page := parsePage(body)
+	sample := &Sample{
+		Header:  "HTTP/1.1 200 OK\r\nX-Jenkins: 2.414.1\r\n",
+		Headers: map[string]string{"X-Jenkins": "2.414.1"},
+		Body:    body,
+		Meta:    page.meta,
+		Scripts: page.scripts,
+	}
+	results, err := db.Match(context.Background(), sample)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"jenkins":   "cpe:2.3:a:jenkins:jenkins:2.414.1:*:*:*:*:*:*:*",
+		"jquery":    "cpe:2.3:a:jquery:jquery:3.7.1:*:*:*:*:*:*:*",
+		"wordpress": "cpe:2.3:a:wordpress:wordpress:6.4.2:*:*:*:*:*:*:*",
+	}
+	if len(results) != len(want) {
+		t.Fatalf("got %+v, want %v", results, want)
+	}
+	for _, r := range results {
+		if want[r.Product] != r.CPE {
+			t.Errorf("%s: got %s, want %s", r.Product, r.CPE, want[r.Product])
+		}
+	}
+
+	var e Extractor
+	if err := e.compile(); err == nil {
+		t.Error("extractor without source compiled")
+	}
+	e = Extractor{From: "body", Regex: `v(\d+)`, Group: 2}
+	if err := e.compile(); err == nil {
+		t.Error("extractor with missing group compiled")
+	}
+}
+
+func TestCPE(t *testing.T) {
+	tests := []struct {
+		part, vendor, product, version string
+		want                           string
+	}{
+		{"", "f5", "nginx", "1.18.0", "cpe:2.3:a:f5:nginx:1.18.0:*:*:*:*:*:*:*"},
+		{"a", "", "Spring Boot", "", "cpe:2.3:a:spring_boot:spring_boot:*:*:*:*:*:*:*:*"},
+		{"o", "microsoft", "windows", "10:1909", `cpe:2.3:o:microsoft:windows:10\:1909:*:*:*:*:*:*:*`},
+		{"a", "acme", "C++ Lib", "1.0", `cpe:2.3:a:acme:c\+\+_lib:1.0:*:*:*:*:*:*:*`},
+	}
+	for _, tt := range tests {
+		if got := CPE(tt.part, tt.vendor, tt.product, tt.version); got != tt.want {
+			t.Errorf("CPE(%q, %q, %q, %q) = %s, want %s", tt.part, tt.vendor, tt.product, tt.version, got, tt.want)
+		}
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules("a.json", []byte(`[{"name": "x", "expression": "resp.status == 200"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := NewRuleDB(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.Rules()[0].Product != "x" {
+		t.Fatalf("product should default to name: %+v", db.Rules()[0])
+	}
+	if err := db.Add(&Rule{Name: "x", Matcher: Matcher{Expression: "true"}}); err == nil {
+		t.Fatal("duplicate rule should fail")
+	}
+	if _, err := NewRuleDB([]*Rule{{Name: "y", Matcher: Matcher{Expression: "resp.status =="}}}); err == nil {
+		t.Fatal("invalid expression should fail")
+	}
+}
+
+func TestDSLRule(t *testing.T) {
+	rules, err := ParseRules("gradio.yaml", []byte(`
+info:
+  name: gradio
+  author: Security Team
+  severity: info
+  metadata:
+    product: gradio
+    vendor: gradio
+http:
+  - method: GET
+    path: '/'
+    matchers:
+      - body="<gradio-app" || title="gradio"
+      - header="x-gradio"
+  - method: GET
+    path: '/config'
+    matchers:
+      - body="version"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules = append(rules, &Rule{Name: "nginx-dsl", Matcher: Matcher{DSL: `server="nginx" && status==200`}})
+	db, err := NewRuleDB(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sample := &Sample{StatusCode: 200, Server: "nginx", Title: "Gradio"}
+	results, err := db.Match(context.Background(), sample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Product != "gradio" || results[1].Product != "nginx-dsl" {
+		t.Fatalf("unexpected results %+v", results)
+	}
+
+	// dsl 转换为 gval 后结果一致
+	for _, r := range db.Rules() {
+		expr, err := r.dsl.ToGval()
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := MatchSample(context.Background(), sample, expr)
+		if err != nil || v != true {
+			t.Fatalf("%s => %s: %v %v", r.DSL, expr, v, err)
+		}
+	}
+}
+
+func TestProbes(t *testing.T) {
+	var (
+		mux  sync.Mutex
+		hits = make(map[string]int)
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		hits[r.Method+" "+r.URL.Path]++
+		mux.Unlock()
+
+		switch r.URL.Path {
+		case "/actuator/health":
+			w.Header().Set("Content-Type", "application/vnd.spring-boot.actuator.v3+json")
+			w.Write([]byte(`{"status":"UP"}`))
+		case "/api/v1/version":
+			if r.Method == http.MethodPost && r.Header.Get("X-Token") == "t" {
+				w.Write([]byte(`{"version":"2.1"}`))
+				return
+			}
+			http.NotFound(w, r)
+		default:
+			w.Write([]byte("<html><title>index</title></html>"))
+		}
+	}))
+	defer srv.Close()
+
+	rules, err := ParseRules("probes.yaml", []byte(`
+- name: actuator
+  probes:
+    - path: actuator/health
+      dsl: body="\"status\""
+- name: actuator-up
+  dsl: title="nothing"
+  probes:
+    - method: get
+      path: /actuator/health
+      expression: 'contains(resp.body, "UP")'
+- name: api
+  probes:
+    - method: POST
+      path: /api/v1/version
+      headers: {x-token: t}
+      body: '{}'
+      dsl: body="version"
+- name: api-anonymous
+  probes:
+    - method: POST
+      path: /api/v1/version
+      body: '{}'
+      dsl: body="version"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := DefaultRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Add(rules...); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := db.Scan(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := report.Results
+	got := make(map[string]bool)
+	for _, r := range results {
+		got[r.Name] = true
+	}
+	for _, name := range []string{"actuator", "actuator-up", "api", "spring-boot"} {
+		if !got[name] {
+			t.Errorf("%s not matched: %+v", name, results)
+		}
+	}
+	if got["api-anonymous"] {
+		t.Errorf("api-anonymous matched")
+	}
+
+	// 三条规则共用的 /actuator/health 只请求一次
+	if n := hits["GET /actuator/health"]; n != 1 {
+		t.Errorf("GET /actuator/health fetched %d times", n)
+	}
+	if n := hits["POST /api/v1/version"]; n != 2 {
+		t.Errorf("POST /api/v1/version fetched %d times", n)
+	}
+}
+
+func TestRuleFixtures(t *testing.T) {
+	db, err := DefaultRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rep := range db.RunTests(context.Background()) {
+		if rep.Tests == 0 {
+			t.Errorf("%s: no tests", rep.Rule)
+		}
+		for _, f := range rep.Failures {
+			t.Errorf("%s: %s", rep.Rule, f)
+		}
+		for _, a := range rep.Ambiguous {
+			t.Errorf("%s: %s", rep.Rule, a)
+		}
+	}
+}
+
+func TestDSLFields(t *testing.T) {
+	sample := &Sample{
+		URL:        "https://example.com/admin/login.php",
+		Path:       "/admin/login.php",
+		StatusCode: 302,
+		Headers:    map[string]string{"X-Powered-By": "PHP/8.1.2"},
+		Meta:       map[string]string{"generator": "WordPress 6.4"},
+		Server:     "nginx/1.24.0",
+		Title:      "Admin Login",
+		Body:       "<html>Admin</html>",
+		Length:     18,
+		Cert:       Cert{Certificate: Certificate{Subject: "CN=example.com", Issuer: "CN=R3,O=Let's Encrypt", SHA256: "00"}},
+	}
+	in, err := newInput(sample)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for rule, want := range map[string]bool{
+		`status in [200,302]`:                  true,
+		`status in [200,301]`:                  false,
+		`content_length==18`:                   true,
+		`server^="NGINX/"`:                     true,
+		`server^=c"NGINX/"`:                    false,
+		`path$=".php" && url^="https://"`:      true,
+		`title==c"Admin Login"`:                true,
+		`title=c"admin"`:                       false,
+		`title!=c"admin"`:                      true,
+		`body~=c"Ad[m]in"`:                     true,
+		`body~="ADM[I]N"`:                      true,
+		`title~="^admin LOGIN$"`:               true,
+		`body~=c"ADMIN"`:                       false,
+		`header["x-powered-by"]^="php/8"`:      true,
+		`header["X-Missing"]=""`:               true,
+		`meta["generator"]^="wordpress"`:       true,
+		`server in ["apache", "NGINX/1.24.0"]`: true,
+		`server in [c"NGINX/1.24.0", "iis"]`:   false,
+		`cert="let's encrypt"`:                 true,
+	} {
+		m := &Matcher{DSL: rule}
+		if err := m.compile(); err != nil {
+			t.Fatalf("%s: %v", rule, err)
+		}
+		if got, err := m.match(context.Background(), in); err != nil || got != want {
+			t.Errorf("%s: got %v %v, want %v", rule, got, err, want)
+		}
+		set, err := dsl.NewRuleSet([]*dsl.Rule{m.dsl})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if matched, err := set.Match(sample.DSLConfig()); err != nil || (len(matched) == 1) != want {
+			t.Errorf("%s: rule set matched %v %v, want %v", rule, matched, err, want)
+		}
+
+		// cert 没有对应的 gval 表达式
+		expr, err := m.dsl.ToGval()
+		if err != nil {
+			continue
+		}
+		v, err := MatchSample(context.Background(), sample, expr)
+		if err != nil || v != want {
+			t.Errorf("%s => %s: got %v %v, want %v", rule, expr, v, err, want)
+		}
+	}
+}
+
+// TestEngineAgreement 同一规则在 dsl、RuleSet 和转换后的 gval 表达式上的结果一致
+func TestEngineAgreement(t *testing.T) {
+	samples := []*Sample{
+		{StatusCode: 200, Title: "Admin Login", Body: "a\nb", Headers: map[string]string{"X-Empty": ""}, Server: "nginx"},
+		{StatusCode: 404, Title: "", Body: "", Headers: map[string]string{}, Server: "Apache/2.4"},
+		{StatusCode: 302, Title: "hello", Body: "A.B axb", Headers: map[string]string{"X-Empty": "x"}, Server: "IIS"},
+	}
+	rules := []string{
+		`body~="a.b"`,
+		`body~="^a$"`,
+		`body~="(?s)a.b"`,
+		`body~=c"A.B"`,
+		`title~="^$"`,
+		`header["X-Empty"]~="^$"`,
+		`header["X-Empty"]~=""`,
+		`title~="LOGIN$"`,
+		`server^="ng" || !body~="b$"`,
+		`!status==404`,
+		`!title=="hello"`,
+		`!server in ["apache/2.4"]`,
+		`!title==c"Hello" && !(body="x" || status>300)`,
+		`!!title="login"`,
+	}
+	for _, rule := range rules {
+		m := &Matcher{DSL: rule}
+		if err := m.compile(); err != nil {
+			t.Fatalf("%s: %v", rule, err)
+		}
+		set, err := dsl.NewRuleSet([]*dsl.Rule{m.dsl})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expr, err := m.dsl.ToGval()
+		if err != nil {
+			t.Fatalf("%s: %v", rule, err)
+		}
+		for i, sample := range samples {
+			in, err := newInput(sample)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := m.match(context.Background(), in)
+			if err != nil {
+				t.Fatalf("%s: %v", rule, err)
+			}
+			if matched, err := set.Match(sample.DSLConfig()); err != nil || (len(matched) == 1) != want {
+				t.Errorf("%s sample %d: rule set matched %v %v, dsl %v", rule, i, matched, err, want)
+			}
+			if v, err := MatchSample(context.Background(), sample, expr); err != nil || v != want {
+				t.Errorf("%s => %s sample %d: gval %v %v, dsl %v", rule, expr, i, v, err, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package fingerprint
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultFixtureURL fixture 未指定 url 时样本使用的地址
+const defaultFixtureURL = "http://fixture.test/"
+
+// RuleTest 规则的测试用例.
+// fixture 为保存的原始 HTTP 响应 (状态行、响应头、空行和响应体), 相对路径相对于规则文件所在目录;
+// probes 为探测请求 ("[METHOD ]path") 对应的 fixture; also 列出允许同时匹配的其他规则
+type RuleTest struct {
+	Fixture string            `yaml:"fixture" json:"fixture"`
+	URL     string            `yaml:"url,omitempty" json:"url,omitempty"`
+	Probes  map[string]string `yaml:"probes,omitempty" json:"probes,omitempty"`
+	Match   bool              `yaml:"match" json:"match"`
+	Version string            `yaml:"version,omitempty" json:"version,omitempty"`
+	Also    []string          `yaml:"also,omitempty" json:"also,omitempty"`
+}
+
+// TestReport 单条规则的测试结果
+type TestReport struct {
+	Rule      string        `json:"rule"`
+	Tests     int           `json:"tests"`
+	Failures  []string      `json:"failures,omitempty"`
+	Ambiguous []string      `json:"ambiguous,omitempty"` // 应当匹配的 fixture 同时匹配了未在 also 中声明的规则
+	Eval      time.Duration `json:"eval"`                // 在所有 fixture 上计算一次的平均耗时
+}
+
+// fixture 加载后的测试用例
+type fixture struct {
+	rule   *Rule
+	test   *RuleTest
+	main   *input
+	probes map[string]*input
+}
+
+// RunTests 在所有规则的测试用例上计算全部规则, 不访问网络.
+// 每条规则都会在每个 fixture 上计算以统计耗时和发现误报, 返回按规则名排序的结果
+func (db *RuleDB) RunTests(ctx context.Context) []*TestReport {
+	reports := make(map[string]*TestReport, len(db.rules))
+	for _, r := range db.rules {
+		reports[r.Name] = &TestReport{Rule: r.Name, Tests: len(r.Tests)}
+	}
+
+	var fixtures []*fixture
+	for _, r := range db.rules {
+		for _, t := range r.Tests {
+			f, err := db.loadFixture(r, t)
+			if err != nil {
+				reports[r.Name].Failures = append(reports[r.Name].Failures, fmt.Sprintf("%s: %v", t.Fixture, err))
+				continue
+			}
+			fixtures = append(fixtures, f)
+		}
+	}
+
+	// matched[i] 为第 i 个 fixture 上匹配的规则和版本
+	matched := make([]map[string]string, len(fixtures))
+	for i := range matched {
+		matched[i] = make(map[string]string)
+	}
+	for _, r := range db.rules {
+		if len(fixtures) == 0 || ctx.Err() != nil {
+			break
+		}
+		start := time.Now()
+		for i, f := range fixtures {
+			ok, err := r.match(ctx, f.main, f.probes)
+			if err != nil {
+				reports[r.Name].Failures = append(reports[r.Name].Failures, fmt.Sprintf("%s: %v", f.test.Fixture, err))
+			}
+			if ok {
+				matched[i][r.Name] = r.extractVersion(ctx, f.main, f.probes)
+			}
+		}
+		reports[r.Name].Eval = time.Since(start) / time.Duration(len(fixtures))
+	}
+
+	for i, f := range fixtures {
+		rep := reports[f.rule.Name]
+		version, ok := matched[i][f.rule.Name]
+		switch {
+		case ok != f.test.Match:
+			rep.Failures = append(rep.Failures, fmt.Sprintf("%s: match = %v, want %v", f.test.Fixture, ok, f.test.Match))
+		case ok && f.test.Version != "" && version != f.test.Version:
+			rep.Failures = append(rep.Failures, fmt.Sprintf("%s: version = %q, want %q", f.test.Fixture, version, f.test.Version))
+		}
+
+		// 只有应当匹配的用例才检查歧义, 不匹配的用例被其他规则匹配是正常的
+		if !f.test.Match {
+			continue
+		}
+		var others []string
+		for name := range matched[i] {
+			if name != f.rule.Name && !slices.Contains(f.test.Also, name) {
+				others = append(others, name)
+			}
+		}
+		if len(others) > 0 {
+			sort.Strings(others)
+			rep.Ambiguous = append(rep.Ambiguous, fmt.Sprintf("%s: also matches %s", f.test.Fixture, strings.Join(others, ", ")))
+		}
+	}
+
+	res := make([]*TestReport, 0, len(reports))
+	for _, rep := range reports {
+		res = append(res, rep)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Rule < res[j].Rule
+	})
+	return res
+}
+
+func (db *RuleDB) loadFixture(r *Rule, t *RuleTest) (*fixture, error) {
+	URL := t.URL
+	if URL == "" {
+		URL = defaultFixtureURL
+	}
+	sample, err := readFixture(r, t.Fixture, URL)
+	if err != nil {
+		return nil, err
+	}
+	f := &fixture{rule: r, test: t, probes: make(map[string]*input)}
+	if f.main, err = newInput(sample); err != nil {
+		return nil, err
+	}
+
+	for k, name := range t.Probes {
+		method, target, ok := strings.Cut(k, " ")
+		if !ok {
+			method, target = http.MethodGet, k
+		}
+		p := &Probe{Method: method, Path: target}
+		p.normalize()
+
+		s, err := readFixture(r, name, strings.TrimSuffix(URL, "/")+p.Path)
+		if err != nil {
+			return nil, err
+		}
+		in, err := newInput(s)
+		if err != nil {
+			return nil, err
+		}
+		// 请求头和请求体不同的探测请求共用同一个 fixture
+		for _, dp := range db.probes {
+			if dp.Method == p.Method && dp.Path == p.Path {
+				f.probes[dp.Key()] = in
+			}
+		}
+	}
+	return f, nil
+}
+
+func readFixture(r *Rule, name, URL string) (*Sample, error) {
+	var (
+		data []byte
+		err  error
+	)
+	if r.fsys != nil {
+		// 内置规则的 fixture 一同嵌入
+		data, err = fs.ReadFile(r.fsys, path.Join(path.Dir(r.source), name))
+	} else {
+		if !filepath.IsAbs(name) && r.source != "" {
+			name = filepath.Join(filepath.Dir(r.source), name)
+		}
+		data, err = os.ReadFile(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ParseSample(URL, data)
+}
@@ -1,29 +1,30 @@
 package fingerprint
 
 import (
+	"bufio"
 	"bytes"
 	"context"
-	"crypto/tls"
 	"io"
 	"net/http"
 	"net/http/httputil"
+	"net/url"
+	"regexp"
 	"strings"
-	"sync"
+	"unicode/utf8"
 
+	"github.com/BreakOnCrash/opendast/dsl"
 	"github.com/BreakOnCrash/opendast/pkg/bytesconv"
+	"github.com/BreakOnCrash/opendast/pkg/httpx"
 
+	"github.com/saintfish/chardet"
 	"golang.org/x/net/html"
-	"golang.org/x/text/encoding/simplifiedchinese"
-	"golang.org/x/text/encoding/traditionalchinese"
-	"golang.org/x/text/transform"
-)
-
-const (
-	userAgent = "Mozilla/5.0 (Windows NT 6.1; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/79.0.3945.0 Safari/537.36"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/html/charset"
 )
 
 type Sample struct {
 	URL        string            `fingerprint:"url"`
+	Path       string            `fingerprint:"path"`
 	StatusCode float64           `fingerprint:"status"`
 	Header     string            `fingerprint:"header"`
 	Headers    map[string]string `fingerprint:"headers"`
@@ -32,75 +33,90 @@ type Sample struct {
 	Server     string            `fingerprint:"server"`
 	Title      string            `fingerprint:"title"`
 	Body       string            `fingerprint:"body"`
-	Meta       map[string]string `fingerprint:"meta"`
+	Length     float64           `fingerprint:"content_length"` // 响应体原始字节数
+	Charset    string            `fingerprint:"charset"`        // 响应体原始编码, 如 utf-8、gbk
+	Meta       map[string]string `fingerprint:"meta"`           // <meta name> 内容, key 为小写的 name
+	Scripts    []string          `fingerprint:"scripts"`        // <script src> 地址
+	Links      []string          `fingerprint:"links"`          // <link href> 地址
+	Comments   []string          `fingerprint:"comments"`       // HTML 注释, 如生成器信息
+	Globals    map[string]string `fingerprint:"globals"`        // 内联脚本定义的全局变量
 	Hash       string            `fingerprint:"hash"`
 	HashMMH3   string            `fingerprint:"hashmmh3"`
+
+	Cert Cert `fingerprint:"cert"` // HTTPS 的证书和握手信息
+
+	IconURL     string  `fingerprint:"icon_url"`     // 首选图标地址, 优先 <link rel=icon>
+	IconHash    float64 `fingerprint:"icon_hash"`    // 首选图标的 Shodan/FOFA 哈希
+	FaviconHash float64 `fingerprint:"favicon_hash"` // /favicon.ico 的哈希
 }
 
-var (
-	httpclient *http.Client = &http.Client{
-		Transport: &http.Transport{
-			DisableKeepAlives:   true,
-			DisableCompression:  true,
-			TLSClientConfig:     &tls.Config{InsecureSkipVerify: true},
-			MaxIdleConns:        100,
-			MaxConnsPerHost:     100,
-			MaxIdleConnsPerHost: 100,
-		},
-	}
-	bufferPool = sync.Pool{
-		New: func() any {
-			return bytes.NewBuffer(make([]byte, 1024))
-		},
-	}
-)
+var httpclient = httpx.Default()
+
+// SetClient 设置获取样本使用的 HTTP 客户端, 默认为 httpx.Default()
+func SetClient(c *httpx.Client) {
+	httpclient = c
+}
 
 func MakeSample(ctx context.Context, URL string) (*Sample, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, URL, nil)
 	if err != nil {
 		return nil, err
 	}
-	req.Close = true
-	req.Header.Set("User-Agent", userAgent)
 
-	resp, err := httpclient.Do(req)
+	sample, icons, finalURL, err := fetchSample(req)
 	if err != nil {
 		return nil, err
 	}
+	sample.IconURL, sample.IconHash, sample.FaviconHash = fetchIcons(ctx, finalURL, icons)
+	return sample, nil
+}
+
+// fetchSample 发送请求并构造 Sample, 同时返回页面中的图标地址和最终请求地址
+func fetchSample(req *http.Request) (*Sample, []string, *url.URL, error) {
+	resp, err := httpclient.Do(req)
+	if err != nil {
+		return nil, nil, nil, err
+	}
 	defer resp.Body.Close()
 
-	var (
-		transformFlag bool
-		reader        io.Reader = resp.Body
-	)
-	contentType := resp.Header.Get("Content-Type")
-	if ct := strings.ToUpper(contentType); strings.Contains(ct, "GB2312") || strings.Contains(ct, "GBK") {
-		transformFlag = true
-		reader = transform.NewReader(resp.Body, simplifiedchinese.GBK.NewDecoder())
-	} else if strings.Contains(ct, "BIG5") {
-		transformFlag = true
-		reader = transform.NewReader(resp.Body, traditionalchinese.Big5.NewDecoder())
-	}
-
-	buf := bufferPool.Get().(*bytes.Buffer)
-	buf.Reset()
-	defer func() {
-		buf.Reset()
-		bufferPool.Put(buf)
-	}()
-	if _, err := io.Copy(buf, reader); err != nil {
-		return nil, err
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, nil, err
 	}
-	body := buf.String()
+	sample, icons := newSample(req.URL.String(), resp.Response, data)
+	if resp.TLS != nil {
+		sample.Cert = newCert(resp.TLS, resp.ServerHello)
+	}
+	return sample, icons, resp.Request.URL, nil
+}
 
-	if !transformFlag &&
-		!strings.Contains(strings.ToLower(contentType), "utf-8") {
-		if lowerBody := strings.ToLower(body); strings.Contains(lowerBody, "gb2312\"") || strings.Contains(lowerBody, "gbk\"") {
-			if nBody, _, err := transform.String(simplifiedchinese.GBK.NewDecoder(), body); err == nil {
-				body = nBody
-			}
-		}
+// ParseSample 从原始 HTTP 响应 (状态行、响应头、空行和响应体) 构造 Sample, 不访问网络.
+// 响应体按原样使用, 忽略 Content-Length 和 Transfer-Encoding
+func ParseSample(URL string, raw []byte) (*Sample, error) {
+	head, body, ok := bytes.Cut(raw, []byte("\r\n\r\n"))
+	if !ok {
+		head, body, _ = bytes.Cut(raw, []byte("\n\n"))
+	}
+	// head 与 body 共用 raw 的底层数组, 不能直接 append
+	head = append(bytes.Clone(bytes.TrimRight(head, "\r\n")), "\r\n\r\n"...)
+
+	req, err := http.NewRequest(http.MethodGet, URL, nil)
+	if err != nil {
+		return nil, err
 	}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(head)), req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	sample, _ := newSample(URL, resp, body)
+	return sample, nil
+}
+
+// newSample 由响应和已读取的响应体构造 Sample, 同时返回页面中的图标地址
+func newSample(URL string, resp *http.Response, data []byte) (*Sample, []string) {
+	body, charsetName := decodeBody(data, resp.Header.Get("Content-Type"))
 
 	headers := make(map[string]string)
 	for key := range resp.Header {
@@ -114,72 +130,161 @@ func MakeSample(ctx context.Context, URL string) (*Sample, error) {
 		cookies[v.Name] = v.Value
 	}
 	headersText, _ := httputil.DumpResponse(resp, false)
+	page := parsePage(body)
+	path := "/"
+	if u, err := url.Parse(URL); err == nil && u.Path != "" {
+		path = u.Path
+	}
 	return &Sample{
 		URL:        URL,
+		Path:       path,
 		StatusCode: float64(resp.StatusCode),
 		Header:     bytesconv.BytesToString(headersText),
 		Headers:    headers,
 		Cookie:     strings.Join(cookie, "\n"),
 		Cookies:    cookies,
 		Server:     resp.Header.Get("Server"),
-		Title:      extraTitle(body),
+		Title:      page.title,
 		Body:       body,
-		Meta:       getMeta(body),
+		Length:     float64(len(data)),
+		Charset:    charsetName,
+		Meta:       page.meta,
+		Scripts:    page.scripts,
+		Links:      page.links,
+		Comments:   page.comments,
+		Globals:    page.globals,
 		Hash:       Md5(body),
 		HashMMH3:   MMH3(body),
-	}, nil
+	}, page.icons
 }
 
-const (
-	titleTagBegin = `<title>`
-	titleTagEnd   = `</title>`
-)
+// DSLConfig 转换为 dsl 规则的匹配输入
+func (s *Sample) DSLConfig() *dsl.Config {
+	return &dsl.Config{
+		Status:  int(s.StatusCode),
+		Body:    s.Body,
+		Header:  s.Header,
+		Icon:    int32(s.IconHash),
+		Title:   s.Title,
+		Server:  s.Server,
+		Cookie:  s.Cookie,
+		Headers: s.Headers,
+		Meta:    s.Meta,
+		URL:     s.URL,
+		Path:    s.Path,
+		Cert:    s.Cert.text(),
 
-func extraTitle(body string) string {
-	low := strings.ToLower(body)
-	if len(low) != len(body) {
-		return ""
+		ContentLength: int(s.Length),
 	}
-	begin := strings.Index(low, titleTagBegin)
-	if begin < 0 {
-		return ""
+}
+
+// decodeBody 将响应体转换为 UTF-8, 依次按 BOM、Content-Type、<meta charset>/http-equiv 确定编码,
+// 都没有声明且内容不是合法的 UTF-8 时按内容猜测. 返回转换后的内容和编码名称
+func decodeBody(data []byte, contentType string) (string, string) {
+	enc, name, certain := charset.DetermineEncoding(data, contentType)
+	if !certain && name == "windows-1252" && !utf8.Valid(data) {
+		if r, err := chardet.NewHtmlDetector().DetectBest(data); err == nil {
+			label := r.Charset
+			if label == "GB-18030" { // chardet 的名称不是标准标签
+				label = "gb18030"
+			}
+			if e, n := charset.Lookup(label); e != nil {
+				enc, name = e, n
+			}
+		}
 	}
-	begin += len(titleTagBegin)
-	end := strings.Index(low, titleTagEnd)
-	if end < 0 {
-		return ""
+
+	if name == "utf-8" {
+		return string(bytes.TrimPrefix(data, utf8BOM)), name
 	}
-	if begin >= end {
-		return ""
+	body, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return bytesconv.BytesToString(data), name
 	}
-	return body[begin:end]
+	return bytesconv.BytesToString(body), name
+}
+
+var utf8BOM = []byte{0xef, 0xbb, 0xbf}
+
+// page 从 HTML 中提取的信息
+type page struct {
+	title    string
+	meta     map[string]string // <meta name> → content
+	icons    []string          // <link rel=icon> 地址
+	scripts  []string          // <script src> 地址
+	links    []string          // 所有 <link href> 地址
+	comments []string          // HTML 注释
+	globals  map[string]string // 内联脚本定义的全局变量 → 字面量值
 }
 
-func getMeta(body string) map[string]string {
-	result := make(map[string]string)
+// parsePage 解析 HTML, 只取第一个不在 <svg> 中的 <title>
+func parsePage(body string) *page {
+	p := &page{meta: make(map[string]string), globals: make(map[string]string)}
 	doc, err := html.Parse(strings.NewReader(body))
 	if err != nil {
-		return nil
+		return p
 	}
 
-	var f func(*html.Node)
+	var (
+		hasTitle bool
+		f        func(*html.Node)
+	)
 	f = func(n *html.Node) {
-		switch n.Type {
-		case html.ElementNode:
-			switch n.Data {
-			case "meta":
+		if n.Type == html.CommentNode {
+			if c := strings.TrimSpace(n.Data); c != "" {
+				p.comments = append(p.comments, c)
+			}
+		}
+		if n.Type == html.ElementNode {
+			switch n.DataAtom {
+			case atom.Svg:
+				return
+			case atom.Title:
+				if !hasTitle {
+					hasTitle = true
+					p.title = strings.TrimSpace(textContent(n))
+				}
+			case atom.Meta:
 				k := ""
 				for _, a := range n.Attr {
 					if a.Key == "name" {
-						k = a.Val
+						k = strings.ToLower(a.Val)
 					}
 					if a.Key == "content" {
 						if k == "" {
 							continue
 						}
-						result[k] = a.Val
+						p.meta[k] = a.Val
+					}
+				}
+			case atom.Link:
+				var rel, href string
+				for _, a := range n.Attr {
+					switch a.Key {
+					case "rel":
+						rel = strings.ToLower(a.Val)
+					case "href":
+						href = a.Val
+					}
+				}
+				if href != "" {
+					p.links = append(p.links, href)
+					if isIconRel(rel) {
+						p.icons = append(p.icons, href)
+					}
+				}
+			case atom.Script:
+				var src string
+				for _, a := range n.Attr {
+					if a.Key == "src" {
+						src = a.Val
 					}
 				}
+				if src != "" {
+					p.scripts = append(p.scripts, src)
+				} else if n.FirstChild != nil {
+					scriptGlobals(n.FirstChild.Data, p.globals)
+				}
 			}
 		}
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
@@ -189,5 +294,43 @@ func getMeta(body string) map[string]string {
 
 	f(doc)
 
-	return result
+	return p
+}
+
+// globalRegex 匹配 var/let/const 声明和 window./self. 赋值, 值为字符串或数字字面量时一并捕获
+var globalRegex = regexp.MustCompile(`(?:\b(?:var|let|const)\s+|\b(?:window|self)\.)([A-Za-z_$][\w$]*)\s*=\s*(?:"([^"\n]*)"|'([^'\n]*)'|(\d[\w.]*)|[^=\s])`)
+
+// scriptGlobals 从内联脚本中提取全局变量, 不执行脚本; 函数内的局部变量也会被提取
+func scriptGlobals(code string, globals map[string]string) {
+	for _, m := range globalRegex.FindAllStringSubmatch(code, -1) {
+		if _, ok := globals[m[1]]; !ok || globals[m[1]] == "" {
+			globals[m[1]] = m[2] + m[3] + m[4]
+		}
+	}
+}
+
+// textContent 返回节点下所有文本, 空白折叠为一个空格
+func textContent(n *html.Node) string {
+	var b strings.Builder
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+	f(n)
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// isIconRel 判断 rel 是否为 icon 或 shortcut icon, 不包括 apple-touch-icon 等
+func isIconRel(rel string) bool {
+	for _, v := range strings.Fields(rel) {
+		if v == "icon" {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,134 @@
+package fingerprint
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+)
+
+func TestDecodeBody(t *testing.T) {
+	gbk := func(s string) []byte {
+		b, err := simplifiedchinese.GBK.NewEncoder().Bytes([]byte(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	big5, err := traditionalchinese.Big5.NewEncoder().Bytes([]byte(`<meta http-equiv="Content-Type" content="text/html; charset=big5"><title>管理後台</title>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		data        []byte
+		contentType string
+		charset     string
+		title       string
+	}{
+		{"content-type", gbk("<title>后台管理系统</title>"), "text/html; charset=GB2312", "gbk", "后台管理系统"},
+		{"meta charset", gbk(`<meta charset="gbk"><title>登录</title>`), "text/html", "gbk", "登录"},
+		{"http-equiv", big5, "", "big5", "管理後台"},
+		{"bom", append([]byte{0xef, 0xbb, 0xbf}, "<TITLE>Ünïcode</TITLE>"...), "text/html; charset=iso-8859-1", "utf-8", "Ünïcode"},
+		{"utf-8", []byte("<svg><title>icon</title></svg><title>\n  Straße\n  Ω </title>"), "", "utf-8", "Straße Ω"},
+		{"sniff", gbk("<html><head><title>欢迎访问统一身份认证平台</title></head><body>" +
+			"<p>请输入您的用户名和密码进行登录，如果忘记密码请联系系统管理员处理。</p></body></html>"), "text/html", "gb18030", "欢迎访问统一身份认证平台"},
+	}
+	for _, tt := range tests {
+		body, name := decodeBody(tt.data, tt.contentType)
+		if name != tt.charset {
+			t.Errorf("%s: charset %s, want %s", tt.name, name, tt.charset)
+		}
+		if title := parsePage(body).title; title != tt.title {
+			t.Errorf("%s: title %q, want %q", tt.name, title, tt.title)
+		}
+	}
+}
+
+func TestSelfSigned(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 设备常见的自签名叶子证书: v3 且没有 CA 基本约束
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "router.local"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  false,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !newCertificate(leaf).SelfSigned {
+		t.Error("non-CA self-signed leaf not detected")
+	}
+
+	// 主题相同但由其他密钥签名
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, err = x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, _ := x509.ParseCertificate(der)
+	if newCertificate(forged).SelfSigned {
+		t.Error("certificate signed by another key reported as self-signed")
+	}
+}
+
+func TestSampleCert(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<title>ok</title>"))
+	}))
+	defer srv.Close()
+
+	sample, err := MakeSample(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := sample.Cert
+	if c.Issuer != "O=Acme Co" || !c.SelfSigned || c.Protocol != "TLS 1.3" || len(c.JA3S) != 32 {
+		t.Errorf("unexpected cert %+v", c)
+	}
+	if !slices.Contains(c.SANs, "127.0.0.1") || !strings.HasPrefix(c.KeyType, "RSA-") {
+		t.Errorf("unexpected cert %+v", c)
+	}
+
+	for _, expr := range []string{
+		`contains(resp.cert.issuer, "acme") && resp.cert.self_signed`,
+		`"example.com" in resp.cert.sans && resp.cert.protocol == "TLS 1.3"`,
+	} {
+		v, err := MatchSample(context.Background(), sample, expr)
+		if err != nil || v != true {
+			t.Errorf("%s => %v %v", expr, v, err)
+		}
+	}
+
+	// HTTP 响应的证书字段为空
+	v, err := MatchSample(context.Background(), &Sample{}, `resp.cert.issuer == "" && !resp.cert.self_signed`)
+	if err != nil || v != true {
+		t.Errorf("empty cert => %v %v", v, err)
+	}
+}
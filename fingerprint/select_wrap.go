@@ -38,9 +38,19 @@ func NewSelectWrapper(v any, tag string) (gval.Selector, error) {
 	}
 	for i := 0; i < vv.NumField(); i++ {
 		t := vv.Type().Field(i).Tag.Get(tag)
-		if t != "" {
-			attrs[t] = vv.Field(i).Interface()
+		if t == "" {
+			continue
 		}
+		// 嵌入结构体的字段提升到当前层级
+		if t == ",inline" {
+			if w, err := NewSelectWrapper(vv.Field(i).Interface(), tag); err == nil {
+				for k, v := range w.(*SelectWrapper).attrs {
+					attrs[k] = v
+				}
+			}
+			continue
+		}
+		attrs[t] = wrapValue(vv.Field(i), tag)
 	}
 
 	// methods
@@ -51,6 +61,38 @@ func NewSelectWrapper(v any, tag string) (gval.Selector, error) {
 	return &SelectWrapper{attrs: attrs}, nil
 }
 
+// wrapValue 字符串映射和结构体 (及其切片) 包装为选择器, 使嵌套字段也按 tag 访问;
+// 字符串切片转换为 []any 以支持 in 运算符
+func wrapValue(v reflect.Value, tag string) any {
+	switch fv := v.Interface().(type) {
+	case map[string]string:
+		return stringMap(fv)
+	case []string:
+		items := make([]any, len(fv))
+		for i, s := range fv {
+			items[i] = s
+		}
+		return items
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		if w, err := NewSelectWrapper(v.Interface(), tag); err == nil {
+			return w
+		}
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.Struct {
+			break
+		}
+		items := make([]any, v.Len())
+		for i := range items {
+			items[i] = wrapValue(v.Index(i), tag)
+		}
+		return items
+	}
+	return v.Interface()
+}
+
 func (s *SelectWrapper) SelectGVal(c context.Context, key string) (interface{}, error) {
 	v, ok := s.attrs[key]
 	if !ok {
@@ -58,3 +100,13 @@ func (s *SelectWrapper) SelectGVal(c context.Context, key string) (interface{},
 	}
 	return v, nil
 }
+
+// stringMap 字符串映射的选择器, 不存在的 key 返回空字符串而不是报错,
+// 规则中可以直接写 resp.meta.generator 或 resp.headers["X-Powered-By"]
+type stringMap map[string]string
+
+var _ gval.Selector = stringMap{}
+
+func (m stringMap) SelectGVal(c context.Context, key string) (interface{}, error) {
+	return m[key], nil
+}
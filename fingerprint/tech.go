@@ -0,0 +1,321 @@
+package fingerprint
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Technology 识别出的 Web 技术
+type Technology struct {
+	Name       string   `json:"name"`
+	Version    string   `json:"version,omitempty"`
+	Categories []string `json:"categories,omitempty"`
+	Confidence int      `json:"confidence"`
+	CPE        string   `json:"cpe,omitempty"`
+}
+
+// techPattern Wappalyzer 格式的匹配模式, 如 `jquery-([\d.]+)\.js\;version:\1\;confidence:50`
+type techPattern struct {
+	re         *regexp.Regexp
+	version    string
+	confidence int
+}
+
+func parseTechPattern(s string) (*techPattern, error) {
+	parts := strings.Split(s, `\;`)
+	re, err := regexp.Compile("(?i)" + parts[0])
+	if err != nil {
+		return nil, err
+	}
+
+	p := &techPattern{re: re, confidence: 100}
+	for _, kv := range parts[1:] {
+		k, v, _ := strings.Cut(kv, ":")
+		switch k {
+		case "version":
+			p.version = v
+		case "confidence":
+			if p.confidence, err = strconv.Atoi(v); err != nil {
+				return nil, fmt.Errorf("confidence %q: %w", v, err)
+			}
+		}
+	}
+	return p, nil
+}
+
+// match 返回是否匹配和提取的版本, 版本模板支持 \1 分组引用和 \1?a:b 三元表达式
+func (p *techPattern) match(s string) (bool, string) {
+	m := p.re.FindStringSubmatch(s)
+	if m == nil {
+		return false, ""
+	}
+	if p.version == "" {
+		return true, ""
+	}
+
+	v := p.version
+	for i := len(m) - 1; i > 0; i-- {
+		v = strings.ReplaceAll(v, `\`+strconv.Itoa(i), m[i])
+	}
+	if cond, rest, ok := strings.Cut(v, "?"); ok {
+		a, b, _ := strings.Cut(rest, ":")
+		if v = b; cond != "" {
+			v = a
+		}
+	}
+	return true, strings.TrimSpace(v)
+}
+
+// patterns 字符串或字符串数组
+type patterns []string
+
+func (p *patterns) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*p = patterns{s}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(p))
+}
+
+// techSpec Wappalyzer technologies.json 中的一项, 额外支持 links (<link href>) 和 comments (HTML 注释)
+type techSpec struct {
+	Cats      []int               `json:"cats"`
+	CPE       string              `json:"cpe"`
+	HTML      patterns            `json:"html"`
+	ScriptSrc patterns            `json:"scriptSrc"`
+	Links     patterns            `json:"links"`
+	Comments  patterns            `json:"comments"`
+	URL       patterns            `json:"url"`
+	Meta      map[string]patterns `json:"meta"`
+	Headers   map[string]patterns `json:"headers"`
+	Cookies   map[string]patterns `json:"cookies"`
+	JS        map[string]patterns `json:"js"`
+	Implies   patterns            `json:"implies"`
+}
+
+type tech struct {
+	name    string
+	cats    []string
+	cpe     string
+	implies []string
+
+	html, scriptSrc, links, comments, url []*techPattern
+	meta, headers, cookies, js            map[string][]*techPattern
+}
+
+// TechDB Web 技术识别规则
+type TechDB struct {
+	techs []*tech
+	names map[string]*tech
+}
+
+// ParseTechnologies 解析 Wappalyzer 格式的规则, 支持 {"categories": ..., "technologies": ...}
+// 和只有 technologies 内容的文件. RE2 不支持的正则 (如前瞻) 会被跳过
+func ParseTechnologies(data []byte) (*TechDB, error) {
+	var file struct {
+		Categories   map[string]json.RawMessage `json:"categories"`
+		Technologies map[string]*techSpec       `json:"technologies"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	if file.Technologies == nil {
+		if err := json.Unmarshal(data, &file.Technologies); err != nil {
+			return nil, err
+		}
+	}
+
+	// 分类可以是名称字符串, 也可以是 Wappalyzer categories.json 的 {"name": ...}
+	categories := make(map[int]string, len(file.Categories))
+	for k, raw := range file.Categories {
+		id, err := strconv.Atoi(k)
+		if err != nil {
+			return nil, fmt.Errorf("category %q: %w", k, err)
+		}
+		var c struct {
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(raw, &c.Name); err != nil {
+			if err := json.Unmarshal(raw, &c); err != nil {
+				return nil, fmt.Errorf("category %q: %w", k, err)
+			}
+		}
+		categories[id] = c.Name
+	}
+
+	db := &TechDB{names: make(map[string]*tech, len(file.Technologies))}
+	for name, spec := range file.Technologies {
+		t := &tech{
+			name:      name,
+			cpe:       spec.CPE,
+			html:      compileTechPatterns(spec.HTML),
+			scriptSrc: compileTechPatterns(spec.ScriptSrc),
+			links:     compileTechPatterns(spec.Links),
+			comments:  compileTechPatterns(spec.Comments),
+			url:       compileTechPatterns(spec.URL),
+			meta:      compileTechPatternMap(spec.Meta, strings.ToLower),
+			headers:   compileTechPatternMap(spec.Headers, http.CanonicalHeaderKey),
+			cookies:   compileTechPatternMap(spec.Cookies, nil),
+			js:        compileTechPatternMap(spec.JS, nil),
+		}
+		for _, c := range spec.Cats {
+			if n, ok := categories[c]; ok {
+				t.cats = append(t.cats, n)
+			}
+		}
+		for _, i := range spec.Implies {
+			i, _, _ = strings.Cut(i, `\;`)
+			t.implies = append(t.implies, i)
+		}
+		db.techs = append(db.techs, t)
+		db.names[name] = t
+	}
+	sort.Slice(db.techs, func(i, j int) bool {
+		return db.techs[i].name < db.techs[j].name
+	})
+	return db, nil
+}
+
+func compileTechPatterns(ps patterns) []*techPattern {
+	res := make([]*techPattern, 0, len(ps))
+	for _, s := range ps {
+		if p, err := parseTechPattern(s); err == nil {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+func compileTechPatternMap(m map[string]patterns, key func(string) string) map[string][]*techPattern {
+	res := make(map[string][]*techPattern, len(m))
+	for k, ps := range m {
+		if key != nil {
+			k = key(k)
+		}
+		res[k] = compileTechPatterns(ps)
+	}
+	return res
+}
+
+//go:embed technologies/technologies.json
+var defaultTechnologies []byte
+
+// DefaultTechnologies 返回内置的技术识别规则
+func DefaultTechnologies() (*TechDB, error) {
+	return ParseTechnologies(defaultTechnologies)
+}
+
+// Len 返回规则数量
+func (db *TechDB) Len() int {
+	return len(db.techs)
+}
+
+// detection 单项技术的匹配状态
+type detection struct {
+	confidence int
+	version    string
+}
+
+func (d *detection) add(p *techPattern, s string) {
+	ok, v := p.match(s)
+	if !ok {
+		return
+	}
+	d.confidence = min(d.confidence+p.confidence, 100)
+	// 多个版本时取最长的, 如 6.4.2 优于 6.4
+	if len(v) > len(d.version) {
+		d.version = v
+	}
+}
+
+func (d *detection) addAll(ps []*techPattern, values ...string) {
+	for _, p := range ps {
+		for _, v := range values {
+			d.add(p, v)
+		}
+	}
+}
+
+// Detect 识别 sample 中的 Web 技术, 包括被其他技术隐含的技术, 按名称排序
+func (db *TechDB) Detect(sample *Sample) []Technology {
+	found := make(map[string]*detection)
+	for _, t := range db.techs {
+		d := &detection{}
+		d.addAll(t.html, sample.Body)
+		d.addAll(t.scriptSrc, sample.Scripts...)
+		d.addAll(t.links, sample.Links...)
+		d.addAll(t.comments, sample.Comments...)
+		d.addAll(t.url, sample.URL)
+		for k, v := range sample.Meta {
+			d.addAll(t.meta[strings.ToLower(k)], v)
+		}
+		for k, ps := range t.headers {
+			if v, ok := sample.Headers[k]; ok {
+				d.addAll(ps, v)
+			}
+		}
+		for k, ps := range t.cookies {
+			if v, ok := sample.Cookies[k]; ok {
+				d.addAll(ps, v)
+			}
+		}
+		for k, ps := range t.js {
+			if v, ok := sample.Globals[k]; ok {
+				d.addAll(ps, v)
+			}
+		}
+		if d.confidence > 0 {
+			found[t.name] = d
+		}
+	}
+
+	// 隐含的技术, 不带版本
+	queue := make([]string, 0, len(found))
+	for name := range found {
+		queue = append(queue, name)
+	}
+	for len(queue) > 0 {
+		t := db.names[queue[0]]
+		queue = queue[1:]
+		for _, name := range t.implies {
+			if _, ok := found[name]; ok || db.names[name] == nil {
+				continue
+			}
+			found[name] = &detection{confidence: found[t.name].confidence}
+			queue = append(queue, name)
+		}
+	}
+
+	res := make([]Technology, 0, len(found))
+	for name, d := range found {
+		t := db.names[name]
+		res = append(res, Technology{
+			Name:       name,
+			Version:    d.version,
+			Categories: t.cats,
+			Confidence: d.confidence,
+			CPE:        techCPE(t.cpe, d.version),
+		})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// techCPE 将版本填入规则的 CPE 模板
+func techCPE(cpe, version string) string {
+	parts := strings.Split(cpe, ":")
+	if len(parts) != 13 || version == "" {
+		return cpe
+	}
+	parts[5] = cpeValue(version)
+	return strings.Join(parts, ":")
+}
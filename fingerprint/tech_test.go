@@ -0,0 +1,91 @@
+package fingerprint
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDetectTechnologies(t *testing.T) {
+	db, err := DefaultTechnologies()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := `<!DOCTYPE html><html><head>
+<!-- This site is optimized with the Yoast SEO plugin v21.7 - https://yoast.com/wordpress/plugins/seo/ -->
+<meta name="generator" content="WordPress 6.4.2">
+<link rel="stylesheet" href="https://cdn.jsdelivr.net/npm/bootstrap@5.3.2/dist/css/bootstrap.min.css">
+<link rel="stylesheet" href="/wp-content/plugins/contact-form-7/includes/css/styles.css?ver=5.8.4">
+<script src="/wp-includes/js/jquery/jquery.min.js?ver=3.7.1"></script>
+<script src="https://unpkg.com/vue@3.3.4/dist/vue.global.prod.js"></script>
+<script>
+var wpcf7 = {"api":{"root":"\/wp-json\/"}};
+window._wpemojiSettings = {"baseUrl":"https:\/\/s.w.org"};
+</script>
+</head><body><div data-v-1a2b3c4d></div></body></html>`
+	page := parsePage(body)
+	sample := &Sample{
+		URL:      "https://example.com/",
+		Headers:  map[string]string{"X-Powered-By": "PHP/8.1.2"},
+		Body:     body,
+		Meta:     page.meta,
+		Scripts:  page.scripts,
+		Links:    page.links,
+		Comments: page.comments,
+		Globals:  page.globals,
+	}
+
+	got := make(map[string]string)
+	for _, tech := range db.Detect(sample) {
+		got[tech.Name] = tech.Version
+		if tech.Name == "jQuery" && tech.CPE != "cpe:2.3:a:jquery:jquery:3.7.1:*:*:*:*:*:*:*" {
+			t.Errorf("jQuery cpe %s", tech.CPE)
+		}
+		if tech.Name == "WordPress" && !reflect.DeepEqual(tech.Categories, []string{"CMS"}) {
+			t.Errorf("WordPress categories %v", tech.Categories)
+		}
+	}
+	want := map[string]string{
+		"Bootstrap":      "5.3.2",
+		"Contact Form 7": "5.8.4",
+		"PHP":            "8.1.2",
+		"Vue.js":         "3.3.4",
+		"WordPress":      "6.4.2",
+		"Yoast SEO":      "21.7",
+		"jQuery":         "3.7.1",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestTechPattern(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		ok         bool
+		version    string
+	}{
+		{`jquery-([\d.]+)\.js\;version:\1`, "/js/jQuery-1.12.4.js", true, "1.12.4"},
+		{`foo(\d)?\;version:\1?2.x:1.x`, "foo", true, "1.x"},
+		{`foo(\d)?\;version:\1?2.x:1.x`, "foo2", true, "2.x"},
+		{`bar\;confidence:50`, "foo", false, ""},
+	}
+	for _, tt := range tests {
+		p, err := parseTechPattern(tt.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok, v := p.match(tt.s); ok != tt.ok || v != tt.version {
+			t.Errorf("%s on %q: got %v %q", tt.pattern, tt.s, ok, v)
+		}
+	}
+
+	db, err := ParseTechnologies([]byte(`{"X": {"html": ["(?=lookahead)", "x\\;confidence:40"], "implies": "Y\\;confidence:50"}, "Y": {}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	techs := db.Detect(&Sample{Body: "x"})
+	if len(techs) != 2 || techs[0].Confidence != 40 || techs[1].Name != "Y" {
+		t.Errorf("unexpected %+v", techs)
+	}
+}
@@ -0,0 +1,162 @@
+package fingerprint
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// defaultVersionRegex 未指定 regex 时提取的版本号格式
+const defaultVersionRegex = `(\d+(?:\.\d+)+)`
+
+// Extractor 版本提取器, 在 from 指定的响应字段上执行 regex, 返回第 group 个分组
+//
+//	from: header (name 为空时为完整响应头), meta (name 默认 generator),
+//	      cookie (name 为空时为完整 Cookie), body, title, server, script (页面引用的 JS 路径)
+type Extractor struct {
+	From  string `yaml:"from" json:"from"`
+	Name  string `yaml:"name,omitempty" json:"name,omitempty"`
+	Regex string `yaml:"regex,omitempty" json:"regex,omitempty"`
+	Group int    `yaml:"group,omitempty" json:"group,omitempty"`
+
+	re *regexp.Regexp
+}
+
+func (e *Extractor) compile() error {
+	e.From = strings.ToLower(e.From)
+	switch e.From {
+	case "header", "meta", "cookie", "body", "title", "server", "script":
+	default:
+		return fmt.Errorf("unknown version source %q", e.From)
+	}
+	if e.From == "meta" && e.Name == "" {
+		e.Name = "generator"
+	}
+	if e.Regex == "" {
+		e.Regex = defaultVersionRegex
+	}
+
+	var err error
+	if e.re, err = regexp.Compile("(?i)" + e.Regex); err != nil {
+		return err
+	}
+	if e.Group == 0 && e.re.NumSubexp() > 0 {
+		e.Group = 1
+	}
+	if e.Group < 0 || e.Group > e.re.NumSubexp() {
+		return fmt.Errorf("regex %q has no group %d", e.Regex, e.Group)
+	}
+	return nil
+}
+
+// sources 返回 sample 中需要提取的文本
+func (e *Extractor) sources(s *Sample) []string {
+	switch e.From {
+	case "header":
+		if e.Name == "" {
+			return []string{s.Header}
+		}
+		return []string{s.Headers[http.CanonicalHeaderKey(e.Name)]}
+	case "meta":
+		for k, v := range s.Meta {
+			if strings.EqualFold(k, e.Name) {
+				return []string{v}
+			}
+		}
+	case "cookie":
+		if e.Name == "" {
+			return []string{s.Cookie}
+		}
+		return []string{s.Cookies[e.Name]}
+	case "body":
+		return []string{s.Body}
+	case "title":
+		return []string{s.Title}
+	case "server":
+		return []string{s.Server}
+	case "script":
+		return s.Scripts
+	}
+	return nil
+}
+
+// Extract 返回第一个匹配的版本号, 未匹配时返回空字符串
+func (e *Extractor) Extract(s *Sample) string {
+	for _, src := range e.sources(s) {
+		if src == "" {
+			continue
+		}
+		if m := e.re.FindStringSubmatch(src); m != nil && m[e.Group] != "" {
+			return m[e.Group]
+		}
+	}
+	return ""
+}
+
+// extractVersion 依次尝试 version 表达式、首页提取器和探测响应上的提取器
+func (r *Rule) extractVersion(ctx context.Context, main *input, probes map[string]*input) string {
+	if r.version != nil {
+		if v, err := r.version.EvalString(ctx, main.params); err == nil && v != "" {
+			return v
+		}
+	}
+	for _, e := range r.Versions {
+		if v := e.Extract(main.sample); v != "" {
+			return v
+		}
+	}
+	for _, p := range r.Probes {
+		in, ok := probes[p.Key()]
+		if !ok {
+			continue
+		}
+		for _, e := range p.Versions {
+			if v := e.Extract(in.sample); v != "" {
+				return v
+			}
+		}
+	}
+	return ""
+}
+
+// CPE 生成 CPE 2.3 格式化字符串, part 为 a (应用)、o (操作系统) 或 h (硬件),
+// 空的 vendor 使用 product, 空的 version 为 *
+func CPE(part, vendor, product, version string) string {
+	if part == "" {
+		part = "a"
+	}
+	if vendor == "" {
+		vendor = product
+	}
+	return strings.Join([]string{
+		"cpe", "2.3", part,
+		cpeValue(vendor), cpeValue(product), cpeValue(version),
+		"*", "*", "*", "*", "*", "*", "*",
+	}, ":")
+}
+
+// cpeValue 按 CPE 2.3 formatted string 规则转义属性值:
+// 小写, 空白替换为 _, 字母数字及 _ - . 以外的字符前加反斜杠, 非 ASCII 字符丢弃
+func cpeValue(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return "*"
+	}
+
+	var b strings.Builder
+	for _, c := range s {
+		switch {
+		case c == ' ' || c == '\t':
+			b.WriteByte('_')
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '_', c == '-', c == '.':
+			b.WriteRune(c)
+		case c > 0x7e || c < 0x20:
+		default:
+			b.WriteByte('\\')
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
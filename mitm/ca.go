@@ -0,0 +1,254 @@
+package mitm
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"time"
+)
+
+const (
+	CertFileName = "ca.pem"
+	KeyFileName  = "ca.key"
+
+	DefaultCAName     = "OpenDAST MITM CA"
+	DefaultCAValidity = 10 * 365 * 24 * time.Hour
+)
+
+// 根证书的密钥算法
+const (
+	AlgRSA   = "rsa"   // RSA 2048
+	AlgECDSA = "ecdsa" // ECDSA P-256
+)
+
+var ErrCANotFound = errors.New("ca not found")
+
+// CA 签发中间人证书的根证书
+type CA struct {
+	Cert *x509.Certificate
+	Key  crypto.Signer
+}
+
+// NewCA 生成自签名的根证书, alg 为 AlgRSA 或 AlgECDSA
+func NewCA(name, alg string, validity time.Duration) (*CA, error) {
+	var (
+		key crypto.Signer
+		err error
+	)
+	switch alg {
+	case AlgRSA, "":
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case AlgECDSA:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported key algorithm %q", alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	pub, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	keyID := sha1.Sum(pub)
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName:   name,
+			Organization: []string{"OpenDAST"},
+		},
+		SubjectKeyId:          keyID[:],
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	raw, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &CA{Cert: cert, Key: key}, nil
+}
+
+// LoadCA 读取 PEM 格式的证书和私钥, 文件不存在时返回 ErrCANotFound
+func LoadCA(certFile, keyFile string) (*CA, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %v", ErrCANotFound, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load ca: %w", err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("parse ca: %w", err)
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported ca key %T", pair.PrivateKey)
+	}
+	return &CA{Cert: cert, Key: key}, nil
+}
+
+// DefaultDir 返回当前用户保存根证书的目录, 如 ~/.config/opendast/mitm
+func DefaultDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "opendast", "mitm"), nil
+}
+
+// LoadCADir 读取 dir 中的根证书, 私钥可以被其他用户读取时返回错误
+func LoadCADir(dir string) (*CA, error) {
+	keyFile := filepath.Join(dir, KeyFileName)
+	if fi, err := os.Stat(keyFile); err == nil && runtime.GOOS != "windows" && fi.Mode().Perm()&0o077 != 0 {
+		return nil, fmt.Errorf("%s is accessible by other users (mode %04o), run chmod 600", keyFile, fi.Mode().Perm())
+	}
+	return LoadCA(filepath.Join(dir, CertFileName), keyFile)
+}
+
+// Save 将证书和私钥写入 dir, 目录权限为 0700, 私钥为 0600.
+// 先写入临时文件再重命名, 写入失败时不会破坏已有的文件
+func (ca *CA) Save(dir string) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	key, err := ca.KeyPEM()
+	if err != nil {
+		return err
+	}
+	if err := writeFile(filepath.Join(dir, KeyFileName), key, 0o600); err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(dir, CertFileName), ca.PEM(), 0o644)
+}
+
+// Rotate 生成新的根证书替换 dir 中的证书, 旧的证书和私钥重命名为 ca.pem.<时间>.bak 保留.
+// 保存失败时恢复旧的证书和私钥
+func Rotate(dir, name, alg string, validity time.Duration) (*CA, error) {
+	ca, err := NewCA(name, alg, validity)
+	if err != nil {
+		return nil, err
+	}
+	suffix := "." + time.Now().Format("20060102150405") + ".bak"
+	if err := replace(dir, suffix, func() error { return ca.Save(dir) }); err != nil {
+		return nil, err
+	}
+	return ca, nil
+}
+
+// replace 将 dir 中的证书和私钥加上 suffix 备份后调用 save,
+// 出错时删除写入了一部分的新文件并将备份改回原名
+func replace(dir, suffix string, save func() error) error {
+	var moved []string
+	restore := func() {
+		for _, name := range []string{CertFileName, KeyFileName} {
+			path := filepath.Join(dir, name)
+			if slices.Contains(moved, path) {
+				os.Rename(path+suffix, path)
+			} else {
+				os.Remove(path)
+			}
+		}
+	}
+
+	for _, name := range []string{CertFileName, KeyFileName} {
+		path := filepath.Join(dir, name)
+		if err := os.Rename(path, path+suffix); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			restore()
+			return err
+		}
+		moved = append(moved, path)
+	}
+	if err := save(); err != nil {
+		restore()
+		return err
+	}
+	return nil
+}
+
+// PEM 返回 PEM 格式的证书
+func (ca *CA) PEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Cert.Raw})
+}
+
+// DER 返回 DER 格式的证书, 即 Windows 和 Android 使用的 .crt/.cer
+func (ca *CA) DER() []byte {
+	return bytes.Clone(ca.Cert.Raw)
+}
+
+// KeyPEM 返回 PKCS#8 PEM 格式的私钥
+func (ca *CA) KeyPEM() ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(ca.Key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// Fingerprint 返回证书 DER 的 SHA-256 指纹, 以 : 分隔的大写十六进制
+func (ca *CA) Fingerprint() string {
+	sum := sha256.Sum256(ca.Cert.Raw)
+	var b bytes.Buffer
+	for i, c := range sum {
+		if i > 0 {
+			b.WriteByte(':')
+		}
+		fmt.Fprintf(&b, "%02X", c)
+	}
+	return b.String()
+}
+
+func writeFile(name string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := f.Chmod(perm); err != nil && runtime.GOOS != "windows" {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
@@ -0,0 +1,117 @@
+package mitm
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/pkcs12"
+)
+
+func TestCA(t *testing.T) {
+	for _, alg := range []string{AlgRSA, AlgECDSA} {
+		t.Run(alg, func(t *testing.T) {
+			ca, err := NewCA(DefaultCAName, alg, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ca.Cert.IsCA || ca.Cert.Subject.CommonName != DefaultCAName {
+				t.Fatalf("unexpected certificate %+v", ca.Cert.Subject)
+			}
+
+			dir := filepath.Join(t.TempDir(), "mitm")
+			if err := ca.Save(dir); err != nil {
+				t.Fatal(err)
+			}
+			if runtime.GOOS != "windows" {
+				for name, want := range map[string]os.FileMode{"": 0o700, KeyFileName: 0o600, CertFileName: 0o644} {
+					fi, err := os.Stat(filepath.Join(dir, name))
+					if err != nil || fi.Mode().Perm() != want {
+						t.Errorf("%s: mode %v %v, want %v", name, fi.Mode().Perm(), err, want)
+					}
+				}
+			}
+			loaded, err := LoadCADir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !loaded.Cert.Equal(ca.Cert) || ca.Fingerprint() != loaded.Fingerprint() {
+				t.Error("loaded certificate differs")
+			}
+
+			p12, err := ca.PKCS12("secret")
+			if err != nil {
+				t.Fatal(err)
+			}
+			key, cert, err := pkcs12.Decode(p12, "secret")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !cert.Equal(ca.Cert) {
+				t.Error("pkcs12 certificate differs")
+			}
+			der, _ := x509.MarshalPKCS8PrivateKey(key)
+			want, _ := x509.MarshalPKCS8PrivateKey(ca.Key)
+			if !bytes.Equal(der, want) {
+				t.Error("pkcs12 key differs")
+			}
+			if _, _, err := pkcs12.Decode(p12, "wrong"); err == nil {
+				t.Error("wrong password should fail")
+			}
+
+			rotated, err := Rotate(dir, DefaultCAName, alg, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rotated.Cert.Equal(ca.Cert) {
+				t.Error("rotate kept the old certificate")
+			}
+			backups, _ := filepath.Glob(filepath.Join(dir, "*.bak"))
+			if len(backups) != 2 {
+				t.Errorf("got backups %v", backups)
+			}
+		})
+	}
+
+	// 保存失败时恢复旧的证书和私钥
+	dir := t.TempDir()
+	ca, err := NewCA(DefaultCAName, AlgECDSA, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ca.Save(dir); err != nil {
+		t.Fatal(err)
+	}
+	failed := errors.New("disk full")
+	err = replace(dir, ".bak", func() error {
+		os.WriteFile(filepath.Join(dir, KeyFileName), []byte("partial"), 0o600)
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("got %v, want %v", err, failed)
+	}
+	if restored, err := LoadCADir(dir); err != nil || !restored.Cert.Equal(ca.Cert) {
+		t.Fatalf("old CA not restored: %v", err)
+	}
+	if backups, _ := filepath.Glob(filepath.Join(dir, "*.bak")); len(backups) != 0 {
+		t.Errorf("backups left after restore: %v", backups)
+	}
+
+	dir = t.TempDir()
+	if _, err := LoadCADir(dir); !errors.Is(err, ErrCANotFound) {
+		t.Errorf("got %v, want %v", err, ErrCANotFound)
+	}
+	if runtime.GOOS != "windows" {
+		ca, _ := NewCA(DefaultCAName, AlgECDSA, time.Hour)
+		ca.Save(dir)
+		os.Chmod(filepath.Join(dir, KeyFileName), 0o644)
+		if _, err := LoadCADir(dir); err == nil {
+			t.Error("world readable key should be rejected")
+		}
+	}
+}
@@ -0,0 +1,330 @@
+// Package capture 将代理的 HTTP 请求和响应保存到 SQLite, 作为主动扫描的输入
+package capture
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var ErrNotFound = errors.New("flow not found")
+
+const schema = `
+CREATE TABLE IF NOT EXISTS flows (
+	id                 INTEGER PRIMARY KEY AUTOINCREMENT,
+	time               INTEGER NOT NULL,
+	duration           INTEGER NOT NULL,
+	method             TEXT NOT NULL,
+	scheme             TEXT NOT NULL,
+	host               TEXT NOT NULL,
+	path               TEXT NOT NULL,
+	url                TEXT NOT NULL,
+	request_headers    TEXT NOT NULL,
+	request_body       BLOB,
+	request_truncated  INTEGER NOT NULL DEFAULT 0,
+	status             INTEGER NOT NULL,
+	response_headers   TEXT NOT NULL,
+	response_body      BLOB,
+	response_truncated INTEGER NOT NULL DEFAULT 0,
+	content_type       TEXT NOT NULL,
+	tls                TEXT
+);
+CREATE INDEX IF NOT EXISTS flows_host ON flows (host, path);
+CREATE INDEX IF NOT EXISTS flows_status ON flows (status);
+CREATE INDEX IF NOT EXISTS flows_content_type ON flows (content_type);
+`
+
+const columns = `id, time, duration, method, scheme, host, path, url,
+	request_headers, request_body, request_truncated,
+	status, response_headers, response_body, response_truncated, content_type, tls`
+
+// Flow 一次请求和响应
+type Flow struct {
+	ID       int64         `json:"id"`
+	Time     time.Time     `json:"time"`     // 收到请求的时间
+	Duration time.Duration `json:"duration"` // 到收到响应头的时间
+
+	Method string `json:"method"`
+	Scheme string `json:"scheme"`
+	Host   string `json:"host"` // 小写的主机名, 不包括端口
+	Path   string `json:"path"`
+	URL    string `json:"url"`
+
+	RequestHeader    http.Header `json:"request_headers"`
+	RequestBody      []byte      `json:"request_body,omitempty"`
+	RequestTruncated bool        `json:"request_truncated,omitempty"`
+
+	Status            int         `json:"status"`
+	ResponseHeader    http.Header `json:"response_headers"`
+	ResponseBody      []byte      `json:"response_body,omitempty"` // 已按 Content-Encoding 解压
+	ResponseTruncated bool        `json:"response_truncated,omitempty"`
+	ContentType       string      `json:"content_type"` // 小写的媒体类型, 不包括参数
+
+	TLS *TLSInfo `json:"tls,omitempty"` // 与目标服务器的 TLS 连接, HTTP 时为空
+}
+
+// TLSInfo 与目标服务器的 TLS 连接参数
+type TLSInfo struct {
+	Version     string    `json:"version"`
+	CipherSuite string    `json:"cipher_suite"`
+	ServerName  string    `json:"server_name,omitempty"`
+	ALPN        string    `json:"alpn,omitempty"`
+	Subject     string    `json:"subject,omitempty"` // 服务器证书
+	Issuer      string    `json:"issuer,omitempty"`
+	DNSNames    []string  `json:"dns_names,omitempty"`
+	NotAfter    time.Time `json:"not_after,omitempty"`
+}
+
+func newTLSInfo(state *tls.ConnectionState) *TLSInfo {
+	if state == nil {
+		return nil
+	}
+	info := &TLSInfo{
+		Version:     tls.VersionName(state.Version),
+		CipherSuite: tls.CipherSuiteName(state.CipherSuite),
+		ServerName:  state.ServerName,
+		ALPN:        state.NegotiatedProtocol,
+	}
+	if len(state.PeerCertificates) > 0 {
+		leaf := state.PeerCertificates[0]
+		info.Subject = leaf.Subject.String()
+		info.Issuer = leaf.Issuer.String()
+		info.DNSNames = leaf.DNSNames
+		info.NotAfter = leaf.NotAfter
+	}
+	return info
+}
+
+// NewRequest 根据记录的请求构造新的请求, 用于重放和主动扫描
+func (f *Flow) NewRequest(ctx context.Context) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, f.Method, f.URL, bytes.NewReader(f.RequestBody))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range f.RequestHeader {
+		req.Header[k] = append([]string(nil), v...)
+	}
+	// 请求体可能被截断, 以实际长度为准
+	req.Header.Del("Content-Length")
+	return req, nil
+}
+
+// Store 保存 Flow 的 SQLite 数据库, 可以并发使用
+type Store struct {
+	db *sql.DB
+}
+
+// Open 打开或创建数据库, path 为 :memory: 时使用内存数据库.
+// 数据库包含 Cookie 等敏感信息, 新建的文件只有当前用户可以读写
+func Open(path string) (*Store, error) {
+	if path != ":memory:" {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		f.Close()
+	}
+	db, err := sql.Open("sqlite3", "file:"+path+"?_journal_mode=WAL&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	// 单个连接串行写入, 内存数据库也只有一个连接可见
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("create schema: %w", err)
+	}
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Save 保存 f 并设置 f.ID
+func (s *Store) Save(ctx context.Context, f *Flow) error {
+	reqHeader, err := json.Marshal(f.RequestHeader)
+	if err != nil {
+		return err
+	}
+	respHeader, err := json.Marshal(f.ResponseHeader)
+	if err != nil {
+		return err
+	}
+	var info []byte
+	if f.TLS != nil {
+		if info, err = json.Marshal(f.TLS); err != nil {
+			return err
+		}
+	}
+
+	res, err := s.db.ExecContext(ctx, `INSERT INTO flows (
+	time, duration, method, scheme, host, path, url,
+	request_headers, request_body, request_truncated,
+	status, response_headers, response_body, response_truncated, content_type, tls
+) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		f.Time.UnixNano(), int64(f.Duration), f.Method, f.Scheme, f.Host, f.Path, f.URL,
+		string(reqHeader), f.RequestBody, f.RequestTruncated,
+		f.Status, string(respHeader), f.ResponseBody, f.ResponseTruncated, f.ContentType, nullString(info),
+	)
+	if err != nil {
+		return err
+	}
+	f.ID, err = res.LastInsertId()
+	return err
+}
+
+// Get 返回指定 ID 的 Flow, 不存在时返回 ErrNotFound
+func (s *Store) Get(ctx context.Context, id int64) (*Flow, error) {
+	row := s.db.QueryRowContext(ctx, "SELECT "+columns+" FROM flows WHERE id = ?", id)
+	f, err := scanFlow(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+// Query 查询条件, 为空的条件不限制
+type Query struct {
+	Host        string    // 主机名, *.example.com 只匹配子域名, .example.com 匹配域名及其子域名
+	Path        string    // 路径前缀
+	Method      string    // 请求方法
+	Status      []int     // 响应状态码, 任意一个相等即可
+	ContentType string    // 媒体类型前缀, 如 text/html、application/
+	Since       time.Time // 不早于该时间的请求
+	Limit       int       // 最多返回的数量, 0 为不限制
+	Offset      int
+}
+
+// where 返回查询条件和参数
+func (q *Query) where() (string, []any) {
+	var (
+		conds []string
+		args  []any
+	)
+	if host := strings.ToLower(q.Host); host != "" {
+		switch {
+		case strings.HasPrefix(host, "*."):
+			conds = append(conds, `host LIKE ? ESCAPE '\'`)
+			args = append(args, "%"+escapeLike(host[1:]))
+		case strings.HasPrefix(host, "."):
+			conds = append(conds, `(host = ? OR host LIKE ? ESCAPE '\')`)
+			args = append(args, host[1:], "%"+escapeLike(host))
+		default:
+			conds = append(conds, "host = ?")
+			args = append(args, host)
+		}
+	}
+	if q.Path != "" {
+		// LIKE 不区分大小写, 路径前缀用 substr 比较
+		conds = append(conds, "substr(path, 1, ?) = ?")
+		args = append(args, len(q.Path), q.Path)
+	}
+	if q.Method != "" {
+		conds = append(conds, "method = ?")
+		args = append(args, strings.ToUpper(q.Method))
+	}
+	if len(q.Status) > 0 {
+		conds = append(conds, "status IN (?"+strings.Repeat(", ?", len(q.Status)-1)+")")
+		for _, s := range q.Status {
+			args = append(args, s)
+		}
+	}
+	if q.ContentType != "" {
+		conds = append(conds, `content_type LIKE ? ESCAPE '\'`)
+		args = append(args, escapeLike(strings.ToLower(q.ContentType))+"%")
+	}
+	if !q.Since.IsZero() {
+		conds = append(conds, "time >= ?")
+		args = append(args, q.Since.UnixNano())
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// Query 返回符合条件的 Flow, 按 ID 排序
+func (s *Store) Query(ctx context.Context, q Query) ([]*Flow, error) {
+	where, args := q.where()
+	query := "SELECT " + columns + " FROM flows" + where + " ORDER BY id"
+	if q.Limit > 0 || q.Offset > 0 {
+		limit := q.Limit
+		if limit <= 0 {
+			limit = -1
+		}
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, limit, q.Offset)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var flows []*Flow
+	for rows.Next() {
+		f, err := scanFlow(rows)
+		if err != nil {
+			return nil, err
+		}
+		flows = append(flows, f)
+	}
+	return flows, rows.Err()
+}
+
+// Count 返回符合条件的 Flow 数量, 忽略 Limit 和 Offset
+func (s *Store) Count(ctx context.Context, q Query) (int, error) {
+	where, args := q.where()
+	var n int
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM flows"+where, args...).Scan(&n)
+	return n, err
+}
+
+func scanFlow(row interface{ Scan(...any) error }) (*Flow, error) {
+	var (
+		f                     Flow
+		at, duration          int64
+		reqHeader, respHeader string
+		info                  sql.NullString
+	)
+	err := row.Scan(&f.ID, &at, &duration, &f.Method, &f.Scheme, &f.Host, &f.Path, &f.URL,
+		&reqHeader, &f.RequestBody, &f.RequestTruncated,
+		&f.Status, &respHeader, &f.ResponseBody, &f.ResponseTruncated, &f.ContentType, &info)
+	if err != nil {
+		return nil, err
+	}
+	f.Time, f.Duration = time.Unix(0, at), time.Duration(duration)
+	if err := json.Unmarshal([]byte(reqHeader), &f.RequestHeader); err != nil {
+		return nil, fmt.Errorf("flow %d: %w", f.ID, err)
+	}
+	if err := json.Unmarshal([]byte(respHeader), &f.ResponseHeader); err != nil {
+		return nil, fmt.Errorf("flow %d: %w", f.ID, err)
+	}
+	if info.Valid {
+		f.TLS = new(TLSInfo)
+		if err := json.Unmarshal([]byte(info.String), f.TLS); err != nil {
+			return nil, fmt.Errorf("flow %d: %w", f.ID, err)
+		}
+	}
+	return &f, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+func nullString(b []byte) sql.NullString {
+	return sql.NullString{String: string(b), Valid: b != nil}
+}
@@ -0,0 +1,363 @@
+package capture
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BreakOnCrash/opendast/mitm"
+)
+
+func TestStore(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "flows.db")
+	s, err := Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if fi, err := os.Stat(name); err != nil {
+		t.Fatal(err)
+	} else if runtime.GOOS != "windows" && fi.Mode().Perm() != 0o600 {
+		t.Errorf("database mode %v, want 0600", fi.Mode().Perm())
+	}
+	ctx := context.Background()
+
+	start := time.Now()
+	for _, f := range []*Flow{
+		{Method: "GET", Host: "example.com", Path: "/", Status: 200, ContentType: "text/html"},
+		{Method: "GET", Host: "api.example.com", Path: "/v1/users", Status: 200, ContentType: "application/json"},
+		{Method: "POST", Host: "api.example.com", Path: "/v1/login", Status: 401, ContentType: "application/json", RequestBody: []byte("u=a")},
+		{Method: "GET", Host: "example.org", Path: "/V1/users", Status: 404, ContentType: "text/plain", TLS: &TLSInfo{Version: "TLS 1.3"}},
+	} {
+		f.Time = start
+		f.URL = "https://" + f.Host + f.Path
+		f.RequestHeader = http.Header{"Accept": {"*/*"}}
+		f.ResponseHeader = http.Header{"Content-Type": {f.ContentType}}
+		if err := s.Save(ctx, f); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range []struct {
+		q    Query
+		want []int64
+	}{
+		{Query{}, []int64{1, 2, 3, 4}},
+		{Query{Host: "example.com"}, []int64{1}},
+		{Query{Host: "*.example.com"}, []int64{2, 3}},
+		{Query{Host: ".EXAMPLE.com"}, []int64{1, 2, 3}},
+		{Query{Path: "/v1/"}, []int64{2, 3}},
+		{Query{Path: "/v1%"}, nil},
+		{Query{Status: []int{401, 404}}, []int64{3, 4}},
+		{Query{ContentType: "application/"}, []int64{2, 3}},
+		{Query{Method: "post"}, []int64{3}},
+		{Query{Host: "api.example.com", Status: []int{200}}, []int64{2}},
+		{Query{Since: start.Add(time.Second)}, nil},
+		{Query{Limit: 2, Offset: 1}, []int64{2, 3}},
+		{Query{Offset: 3}, []int64{4}},
+	} {
+		flows, err := s.Query(ctx, tt.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []int64
+		for _, f := range flows {
+			got = append(got, f.ID)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("Query(%+v) = %v, want %v", tt.q, got, tt.want)
+		}
+	}
+
+	f, err := s.Get(ctx, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(f.RequestBody) != "u=a" || f.RequestHeader.Get("Accept") != "*/*" || !f.Time.Equal(start) || f.TLS != nil {
+		t.Errorf("unexpected flow %+v", f)
+	}
+	if f, _ := s.Get(ctx, 4); f.TLS == nil || f.TLS.Version != "TLS 1.3" {
+		t.Errorf("tls not stored: %+v", f.TLS)
+	}
+	if _, err := s.Get(ctx, 100); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got %v, want %v", err, ErrNotFound)
+	}
+	if n, err := s.Count(ctx, Query{Host: ".example.com", Limit: 1}); err != nil || n != 3 {
+		t.Errorf("Count = %d %v, want 3", n, err)
+	}
+
+	req, err := f.NewRequest(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(req.Body)
+	if req.Method != "POST" || req.URL.String() != "https://api.example.com/v1/login" || string(body) != "u=a" {
+		t.Errorf("unexpected request %s %s %q", req.Method, req.URL, body)
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	page := strings.Repeat("<p>hello</p>", 100)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("X-Echo", string(body))
+		zw := gzip.NewWriter(w)
+		zw.Write([]byte(page))
+		zw.Close()
+	}))
+	defer srv.Close()
+
+	s, err := Open(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	rec := NewRecorder(s, 64)
+
+	p, err := mitm.New(&mitm.Config{Addr: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.OnRequest(rec.Request)
+	p.OnResponse(rec.Response)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := p.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:              http.ProxyURL(&url.URL{Scheme: "http", Host: p.Addr().String()}),
+		DisableCompression: true,
+	}}
+	payload := strings.Repeat("a", 100)
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/login?next=/", strings.NewReader(payload))
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	// 转发的请求和响应不受截断影响
+	zr, err := gzip.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(zr); string(got) != page || resp.Header.Get("X-Echo") != payload {
+		t.Fatalf("proxied traffic modified: %q", resp.Header.Get("X-Echo"))
+	}
+
+	// Flow 在后台写入, Close 等待写入完成
+	if err := p.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+	flows, err := s.Query(context.Background(), Query{Host: "127.0.0.1", Path: "/login", ContentType: "text/html", Status: []int{200}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(flows) != 1 {
+		t.Fatalf("got %d flows", len(flows))
+	}
+	f := flows[0]
+	if f.Method != http.MethodPost || f.URL != srv.URL+"/login?next=/" || f.Duration <= 0 {
+		t.Errorf("unexpected flow %s %s %v", f.Method, f.URL, f.Duration)
+	}
+	if string(f.RequestBody) != payload[:64] || !f.RequestTruncated {
+		t.Errorf("request body %q truncated %v", f.RequestBody, f.RequestTruncated)
+	}
+	if string(f.ResponseBody) != page[:64] || !f.ResponseTruncated || f.ResponseHeader.Get("Content-Encoding") != "gzip" {
+		t.Errorf("response body %q truncated %v", f.ResponseBody, f.ResponseTruncated)
+	}
+}
+
+func TestRecorderStream(t *testing.T) {
+	s, err := Open(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	rec := NewRecorder(s, 16)
+
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/events", strings.NewReader("subscribe"))
+	if err := rec.Request(req); err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(req.Body)
+
+	pr, pw := io.Pipe()
+	res := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"text/event-stream"}},
+		Body:       pr,
+		Request:    req,
+	}
+	// 钩子不能等待响应体, 否则流式响应在结束前不会转发
+	hooked := make(chan error, 1)
+	go func() { hooked <- rec.Response(res) }()
+	select {
+	case err := <-hooked:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("response hook blocked on body")
+	}
+
+	go func() {
+		pw.Write([]byte("data: first\n\n"))
+		pw.Write([]byte("data: second\n\n"))
+		pw.Close()
+	}()
+	buf := make([]byte, len("data: first\n\n"))
+	if _, err := io.ReadFull(res.Body, buf); err != nil || string(buf) != "data: first\n\n" {
+		t.Fatalf("got %q %v", buf, err)
+	}
+	if rest, _ := io.ReadAll(res.Body); string(rest) != "data: second\n\n" {
+		t.Fatalf("got %q", rest)
+	}
+	res.Body.Close()
+
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+	flows, err := s.Query(context.Background(), Query{ContentType: "text/event-stream"})
+	if err != nil || len(flows) != 1 {
+		t.Fatalf("got %d flows %v", len(flows), err)
+	}
+	f := flows[0]
+	if string(f.RequestBody) != "subscribe" || f.RequestTruncated {
+		t.Errorf("request body %q truncated %v", f.RequestBody, f.RequestTruncated)
+	}
+	if string(f.ResponseBody) != "data: first\n\ndat" || !f.ResponseTruncated {
+		t.Errorf("response body %q truncated %v", f.ResponseBody, f.ResponseTruncated)
+	}
+}
+
+// TestRecorderSaveError 写入失败且队列已满时不能阻塞转发和 Close
+func TestRecorderSaveError(t *testing.T) {
+	s, err := Open(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close() // 之后的 Save 都会失败
+	rec := NewRecorder(s, 0)
+
+	// 第一次写入失败后 writeLoop 停在记录错误处, 直到队列写满
+	rec.mux.Lock()
+	const workers, n = 4, queueSize
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				rec.complete(&record{flow: &Flow{Method: http.MethodGet}})
+			}
+		}()
+	}
+	for deadline := time.Now().Add(10 * time.Second); len(rec.queue) < cap(rec.queue); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			rec.mux.Unlock()
+			t.Fatalf("queue not filled: %d", len(rec.queue))
+		}
+	}
+	rec.mux.Unlock()
+
+	done := make(chan error, 1)
+	go func() {
+		wg.Wait()
+		done <- rec.Close()
+	}()
+	select {
+	case err := <-done:
+		if err == nil || !strings.HasPrefix(err.Error(), fmt.Sprintf("%d flows not saved", workers*n)) {
+			t.Errorf("got %v", err)
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatal("recorder deadlocked on save errors")
+	}
+}
+
+func TestTeeBody(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		max       int64
+		read      int // 关闭前读取的字节数, -1 表示读到 EOF
+		want      string
+		truncated bool
+	}{
+		{"eof", 64, -1, "hello world", false},
+		{"limit", 5, -1, "hello", true},
+		{"closed early", 64, 5, "hello", true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				calls     int
+				got       []byte
+				truncated bool
+			)
+			b := newTeeBody(io.NopCloser(strings.NewReader("hello world")), tt.max, func(data []byte, trunc bool) {
+				calls++
+				got, truncated = bytes.Clone(data), trunc
+			})
+			if tt.read < 0 {
+				if data, _ := io.ReadAll(b); string(data) != "hello world" {
+					t.Fatalf("forwarded %q", data)
+				}
+			} else {
+				io.ReadFull(b, make([]byte, tt.read))
+			}
+			b.Close()
+			b.Close()
+			if calls != 1 || string(got) != tt.want || truncated != tt.truncated {
+				t.Errorf("done called %d times with %q %v, want %q %v", calls, got, truncated, tt.want, tt.truncated)
+			}
+		})
+	}
+}
+
+func TestDecode(t *testing.T) {
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	zw.Write([]byte("hello"))
+	zw.Close()
+
+	r := NewRecorder(nil, 0)
+	defer r.Close()
+	for _, tt := range []struct {
+		raw      []byte
+		encoding string
+		want     string
+	}{
+		{b.Bytes(), "gzip", "hello"},
+		{b.Bytes(), "GZIP, identity", "hello"},
+		{[]byte("hello"), "", "hello"},
+		{[]byte("hello"), "compress", "hello"},
+		{[]byte("hello"), "gzip", "hello"},
+	} {
+		if got, _ := r.decode(tt.raw, tt.encoding, false); string(got) != tt.want {
+			t.Errorf("decode(%q) = %q, want %q", tt.encoding, got, tt.want)
+		}
+	}
+}
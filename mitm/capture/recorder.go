@@ -0,0 +1,310 @@
+package capture
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+// DefaultMaxBody 每个请求体和响应体最多保存的字节数
+const DefaultMaxBody = 1 << 20
+
+// queueSize 等待写入数据库的 Flow 数量, 写满时保存 Flow 的请求等待写入
+const queueSize = 1024
+
+// Recorder 通过代理的钩子记录 Flow, 使用完后需要 Close:
+//
+//	p.OnRequest(rec.Request)
+//	p.OnResponse(rec.Response)
+//
+// 请求体和响应体在转发时边读边记录, 不会延迟转发; Flow 在响应体读完或关闭后由后台协程写入数据库
+type Recorder struct {
+	store   *Store
+	maxBody int64
+	pending sync.Map // *http.Request → *record
+
+	queue     chan *record
+	closing   chan struct{} // Close 后关闭, 不再接收新的 Flow
+	closeOnce sync.Once
+	done      chan struct{}
+
+	mux    sync.Mutex
+	failed int   // 写入失败的数量
+	err    error // 第一个写入错误
+}
+
+// record 等待响应体读完的 Flow, 响应体在写入前按 encoding 解压
+type record struct {
+	flow     *Flow
+	req      *teeBody // 请求体, 没有请求体时为空
+	encoding string
+}
+
+// NewRecorder maxBody 为请求体和响应体最多保存的字节数, 超出部分照常转发但不保存
+func NewRecorder(store *Store, maxBody int64) *Recorder {
+	if maxBody <= 0 {
+		maxBody = DefaultMaxBody
+	}
+	r := &Recorder{
+		store:   store,
+		maxBody: maxBody,
+		queue:   make(chan *record, queueSize),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go r.writeLoop()
+	return r
+}
+
+// Request 请求钩子, 记录请求头, 请求体在转发时记录
+func (r *Recorder) Request(req *http.Request) error {
+	if req.Method == http.MethodConnect {
+		return nil
+	}
+	rec := &record{flow: &Flow{
+		Time:          time.Now(),
+		Method:        req.Method,
+		RequestHeader: req.Header.Clone(),
+	}}
+	if req.Body != nil && req.Body != http.NoBody {
+		rec.req = newTeeBody(req.Body, r.maxBody, nil)
+		req.Body = rec.req
+	}
+	r.pending.Store(req, rec)
+	return nil
+}
+
+// Response 响应钩子, 记录响应头, 响应体读完或关闭后保存 Flow
+func (r *Recorder) Response(res *http.Response) error {
+	req := res.Request
+	if req == nil || req.Method == http.MethodConnect {
+		return nil
+	}
+	var rec *record
+	if v, ok := r.pending.LoadAndDelete(req); ok {
+		rec = v.(*record)
+		rec.flow.Duration = time.Since(rec.flow.Time)
+	} else {
+		// 没有经过请求钩子, 如之前的钩子返回了错误
+		rec = &record{flow: &Flow{Time: time.Now(), Method: req.Method, RequestHeader: req.Header.Clone()}}
+	}
+	f := rec.flow
+
+	u := *req.URL
+	if u.Host == "" {
+		u.Host = req.Host
+	}
+	if u.Scheme == "" {
+		u.Scheme = "http"
+		if req.TLS != nil {
+			u.Scheme = "https"
+		}
+	}
+	f.Scheme, f.Host, f.Path, f.URL = u.Scheme, strings.ToLower(u.Hostname()), u.EscapedPath(), u.String()
+	if f.Path == "" {
+		f.Path = "/"
+	}
+
+	f.Status = res.StatusCode
+	f.ResponseHeader = res.Header.Clone()
+	if mt, _, err := mime.ParseMediaType(res.Header.Get("Content-Type")); err == nil {
+		f.ContentType = mt
+	}
+	f.TLS = newTLSInfo(res.TLS)
+
+	if res.Body == nil || res.Body == http.NoBody {
+		r.complete(rec)
+		return nil
+	}
+	rec.encoding = res.Header.Get("Content-Encoding")
+	res.Body = newTeeBody(res.Body, r.maxBody, func(raw []byte, truncated bool) {
+		f.ResponseBody, f.ResponseTruncated = raw, truncated
+		r.complete(rec)
+	})
+	return nil
+}
+
+// complete 补充请求体并将 Flow 放入写入队列, 队列已满时等待.
+// 服务器可能在读完请求体之前响应, 此时只保存已读取的部分并标记为截断
+func (r *Recorder) complete(rec *record) {
+	if rec.req != nil {
+		rec.flow.RequestBody, rec.flow.RequestTruncated = rec.req.snapshot()
+	}
+
+	// 不持有锁等待队列, 写入失败时 writeLoop 需要加锁
+	select {
+	case <-r.closing:
+		return
+	default:
+	}
+	select {
+	case r.queue <- rec:
+	case <-r.closing:
+	}
+}
+
+// writeLoop 解压响应体并写入数据库, 不占用转发的时间
+func (r *Recorder) writeLoop() {
+	defer close(r.done)
+	for {
+		select {
+		case rec := <-r.queue:
+			r.save(rec)
+		case <-r.closing:
+			// 写入 Close 之前已经进入队列的 Flow
+			for {
+				select {
+				case rec := <-r.queue:
+					r.save(rec)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// save 写入一个 Flow, 记录写入错误
+func (r *Recorder) save(rec *record) {
+	f := rec.flow
+	if f.ResponseBody != nil {
+		f.ResponseBody, f.ResponseTruncated = r.decode(f.ResponseBody, rec.encoding, f.ResponseTruncated)
+	}
+	if err := r.store.Save(context.Background(), f); err != nil {
+		r.mux.Lock()
+		if r.err == nil {
+			r.err = err
+		}
+		r.failed++
+		r.mux.Unlock()
+	}
+}
+
+// Close 等待队列中的 Flow 写入数据库, 之后完成的 Flow 不再保存.
+// 返回第一个写入错误, 不关闭 Store
+func (r *Recorder) Close() error {
+	r.closeOnce.Do(func() { close(r.closing) })
+	<-r.done
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.err != nil {
+		return fmt.Errorf("%d flows not saved: %w", r.failed, r.err)
+	}
+	return nil
+}
+
+// decode 按 Content-Encoding 解压, 截断的内容尽量解压, 不支持的编码或解压失败时返回原始内容
+func (r *Recorder) decode(raw []byte, encoding string, truncated bool) ([]byte, bool) {
+	encodings := strings.Split(strings.ToLower(encoding), ",")
+	data := raw
+	for i := len(encodings) - 1; i >= 0; i-- {
+		var (
+			zr  io.Reader
+			err error
+		)
+		switch strings.TrimSpace(encodings[i]) {
+		case "", "identity":
+			continue
+		case "gzip", "x-gzip":
+			zr, err = gzip.NewReader(bytes.NewReader(data))
+		case "deflate":
+			// 大多数服务器发送 zlib 格式, 少数发送原始 deflate
+			if zr, err = zlib.NewReader(bytes.NewReader(data)); err != nil {
+				zr, err = flate.NewReader(bytes.NewReader(data)), nil
+			}
+		case "br":
+			zr = brotli.NewReader(bytes.NewReader(data))
+		default:
+			return raw, truncated
+		}
+		if err != nil {
+			return raw, truncated
+		}
+		out, err := io.ReadAll(io.LimitReader(zr, r.maxBody+1))
+		if err != nil && !truncated {
+			return raw, truncated
+		}
+		if int64(len(out)) > r.maxBody {
+			out, truncated = out[:r.maxBody], true
+		}
+		data = out
+	}
+	return data, truncated
+}
+
+// teeBody 转发时记录 body 的前 max 字节, 读到 EOF、读取出错或关闭时调用一次 done.
+// 没有读到 EOF 的内容标记为截断
+type teeBody struct {
+	rc  io.ReadCloser
+	max int64
+
+	mux       sync.Mutex
+	buf       bytes.Buffer
+	truncated bool
+	finished  bool
+	done      func(data []byte, truncated bool)
+}
+
+func newTeeBody(rc io.ReadCloser, max int64, done func([]byte, bool)) *teeBody {
+	return &teeBody{rc: rc, max: max, done: done}
+}
+
+func (b *teeBody) Read(p []byte) (int, error) {
+	n, err := b.rc.Read(p)
+	b.mux.Lock()
+	if n > 0 && !b.finished {
+		if remain := b.max - int64(b.buf.Len()); int64(n) > remain {
+			b.buf.Write(p[:remain])
+			b.truncated = true
+		} else {
+			b.buf.Write(p[:n])
+		}
+	}
+	b.mux.Unlock()
+	if err != nil {
+		b.finish(err != io.EOF)
+	}
+	return n, err
+}
+
+func (b *teeBody) Close() error {
+	err := b.rc.Close()
+	b.finish(true)
+	return err
+}
+
+// finish incomplete 为 true 表示没有读到 EOF
+func (b *teeBody) finish(incomplete bool) {
+	b.mux.Lock()
+	if b.finished {
+		b.mux.Unlock()
+		return
+	}
+	b.finished = true
+	b.truncated = b.truncated || incomplete
+	data, truncated := b.buf.Bytes(), b.truncated
+	b.mux.Unlock()
+
+	if b.done != nil {
+		b.done(data, truncated)
+	}
+}
+
+// snapshot 返回已记录的内容, 还没有结束时标记为截断
+func (b *teeBody) snapshot() ([]byte, bool) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return bytes.Clone(b.buf.Bytes()), b.truncated || !b.finished
+}
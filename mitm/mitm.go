@@ -1,79 +1,256 @@
+// Package mitm 中间人代理, 解密 HTTPS 流量并调用注册的请求和响应钩子.
+// 通过代理访问 http://opendast.mitm 可以下载根证书
 package mitm
 
 import (
+	"context"
 	"crypto/tls"
-	"crypto/x509"
-	"log"
+	"errors"
+	"fmt"
 	"net"
 	"net/http"
 	"net/url"
+	"sync"
 	"time"
 
+	"github.com/BreakOnCrash/opendast/other/tire"
 	"github.com/google/martian/v3"
 	"github.com/google/martian/v3/mitm"
 )
 
-var defaultTimeout = 5 * time.Second
+const (
+	DefaultAddr        = ":8081"
+	DefaultTimeout     = 5
+	DefaultIdleTimeout = 60
+)
+
+var (
+	ErrStarted    = errors.New("proxy already started")
+	ErrNotStarted = errors.New("proxy not started")
+)
+
+type Config struct {
+	Addr     string // 监听地址
+	CA       *CA    // 根证书, 为空时读取 CertFile 和 KeyFile
+	CertFile string // PEM 格式的根证书, CA、CertFile 和 KeyFile 都为空时不解密 HTTPS, 直接转发 CONNECT
+	KeyFile  string // PEM 格式的根证书私钥
+	Upstream string // 上游代理, 如 http://127.0.0.1:8080
+
+	Verify      bool // 校验目标服务器证书, 默认不校验
+	Timeout     int  // 与目标服务器 TLS 握手、等待响应头的超时时间, 单位秒
+	IdleTimeout int  // 客户端连接空闲超时时间, 单位秒
+
+	Scope *tire.DomainTrie // 扫描范围, 范围外主机的请求直接转发, 不调用钩子
+}
 
-func RunDemo(certFile, keyFile, parentProxy string) {
-	skipTLSVerify := true
+// RequestHook 在请求发往目标服务器前调用, 可以修改请求.
+// 返回错误时跳过后续钩子, 请求仍会转发, 错误写入 Warning 请求头
+type RequestHook func(req *http.Request) error
+
+// ResponseHook 在响应返回客户端前调用, res.Request 为对应的请求
+type ResponseHook func(res *http.Response) error
+
+type Proxy struct {
+	cfg   *Config
+	proxy *martian.Proxy
+	ca    *CA
+
+	mux      sync.RWMutex
+	reqs     []RequestHook
+	resps    []ResponseHook
+	listener net.Listener
+	done     chan struct{} // Serve 返回后关闭
+	closed   chan struct{} // 已有连接处理完成后关闭
+	err      error         // Serve 返回的错误
+	close    sync.Once
+}
+
+// New 创建代理, 需要调用 Start 开始监听
+func New(cfg *Config) (*Proxy, error) {
+	if cfg.Addr == "" {
+		cfg.Addr = DefaultAddr
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = DefaultIdleTimeout
+	}
+	timeout := time.Duration(cfg.Timeout) * time.Second
 
 	proxy := martian.NewProxy()
+	proxy.SetTimeout(time.Duration(cfg.IdleTimeout) * time.Second)
 	proxy.SetRoundTripper(&http.Transport{
 		MaxIdleConns:          100,
-		TLSHandshakeTimeout:   defaultTimeout,
-		ExpectContinueTimeout: defaultTimeout,
-		ResponseHeaderTimeout: defaultTimeout,
+		TLSHandshakeTimeout:   timeout,
+		ExpectContinueTimeout: timeout,
+		ResponseHeaderTimeout: timeout,
 		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: skipTLSVerify,
+			InsecureSkipVerify: !cfg.Verify,
 		},
 	})
+	proxy.SetDial((&net.Dialer{Timeout: timeout}).Dial)
 
-	if parentProxy != "" {
-		proxyURL, err := url.Parse(parentProxy)
+	if cfg.Upstream != "" {
+		u, err := url.Parse(cfg.Upstream)
 		if err != nil {
-			log.Fatal(err)
+			return nil, fmt.Errorf("upstream: %w", err)
 		}
-		proxy.SetDownstreamProxy(proxyURL)
+		proxy.SetDownstreamProxy(u)
 	}
 
-	// config mitm cert file
-	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
-	if err != nil {
-		log.Fatal(err)
+	ca := cfg.CA
+	if ca == nil && (cfg.CertFile != "" || cfg.KeyFile != "") {
+		var err error
+		if ca, err = LoadCA(cfg.CertFile, cfg.KeyFile); err != nil {
+			return nil, err
+		}
 	}
-	x509c, err := x509.ParseCertificate(cert.Certificate[0])
-	if err != nil {
-		log.Fatal(err)
+	if ca != nil {
+		tlscnf, err := mitm.NewConfig(ca.Cert, ca.Key)
+		if err != nil {
+			return nil, err
+		}
+		tlscnf.SkipTLSVerify(!cfg.Verify)
+		proxy.SetMITM(tlscnf)
 	}
-	tlscnf, err := mitm.NewConfig(x509c, cert.PrivateKey)
-	if err != nil {
-		log.Fatal(err)
-	}
-	tlscnf.SkipTLSVerify(skipTLSVerify)
-	proxy.SetMITM(tlscnf)
-
-	// set request, response modifier
-	proxy.SetRequestModifier(martian.RequestModifierFunc(
-		func(req *http.Request) error {
-			log.Printf("[mitm] modify request - method: %s url: %s", req.Method, req.URL.String())
-			return nil
-		}))
-	proxy.SetResponseModifier(martian.ResponseModifierFunc(
-		func(res *http.Response) error {
-			log.Printf("[mitm] modify response - method: %s url: %s status: %d", res.Request.Method, res.Request.URL.String(), res.StatusCode)
-			return nil
-		}))
-
-	// start proxy server
-	listener, err := net.Listen("tcp", ":8081")
+
+	p := &Proxy{cfg: cfg, proxy: proxy, ca: ca}
+	proxy.SetRequestModifier(martian.RequestModifierFunc(p.modifyRequest))
+	proxy.SetResponseModifier(martian.ResponseModifierFunc(p.modifyResponse))
+	return p, nil
+}
+
+// OnRequest 注册请求钩子, 按注册顺序调用
+func (p *Proxy) OnRequest(hook RequestHook) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.reqs = append(p.reqs, hook)
+}
+
+// OnResponse 注册响应钩子, 按注册顺序调用
+func (p *Proxy) OnResponse(hook ResponseHook) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.resps = append(p.resps, hook)
+}
+
+func (p *Proxy) modifyRequest(req *http.Request) error {
+	if isCAHost(req) {
+		if ctx := martian.NewContext(req); ctx != nil {
+			ctx.SkipRoundTrip()
+		}
+		return nil
+	}
+	if !p.cfg.Scope.Allow(req.URL.Hostname()) {
+		return nil
+	}
+	p.mux.RLock()
+	hooks := p.reqs
+	p.mux.RUnlock()
+	for _, hook := range hooks {
+		if err := hook(req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Proxy) modifyResponse(res *http.Response) error {
+	if isCAHost(res.Request) {
+		return p.servePage(res)
+	}
+	if res.Request == nil || !p.cfg.Scope.Allow(res.Request.URL.Hostname()) {
+		return nil
+	}
+	p.mux.RLock()
+	hooks := p.resps
+	p.mux.RUnlock()
+	for _, hook := range hooks {
+		if err := hook(res); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Start 开始监听并在后台处理连接, ctx 结束时关闭代理.
+// 每个 Proxy 只能启动一次
+func (p *Proxy) Start(ctx context.Context) error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if p.listener != nil {
+		return ErrStarted
+	}
+
+	var lc net.ListenConfig
+	l, err := lc.Listen(ctx, "tcp", p.cfg.Addr)
 	if err != nil {
-		log.Fatalf("listen error: %v", err)
+		return err
+	}
+	p.listener, p.done, p.closed = l, make(chan struct{}), make(chan struct{})
+
+	go func() {
+		defer close(p.done)
+		err := p.proxy.Serve(l)
+		if errors.Is(err, net.ErrClosed) {
+			err = nil
+		}
+		p.err = err
+	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+			p.Shutdown(context.Background())
+		case <-p.done:
+		}
+	}()
+	return nil
+}
+
+// Addr 返回监听地址, 未启动时为 nil
+func (p *Proxy) Addr() net.Addr {
+	p.mux.RLock()
+	defer p.mux.RUnlock()
+	if p.listener == nil {
+		return nil
 	}
-	defer listener.Close()
+	return p.listener.Addr()
+}
+
+// Wait 等待代理停止, 返回处理连接时的错误
+func (p *Proxy) Wait() error {
+	p.mux.RLock()
+	done := p.done
+	p.mux.RUnlock()
+	if done == nil {
+		return ErrNotStarted
+	}
+	<-done
+	return p.err
+}
 
-	log.Printf("proxy server listen on %s", listener.Addr().String())
-	if err := proxy.Serve(listener); err != nil {
-		log.Fatalf("proxy serve error: %v", err)
+// Shutdown 停止接受新连接并等待已有连接处理完成, ctx 结束时不再等待
+func (p *Proxy) Shutdown(ctx context.Context) error {
+	p.mux.RLock()
+	l, done, closed := p.listener, p.done, p.closed
+	p.mux.RUnlock()
+	if l == nil {
+		return ErrNotStarted
+	}
+
+	p.close.Do(func() {
+		l.Close()
+		go func() {
+			defer close(closed)
+			p.proxy.Close()
+		}()
+	})
+	select {
+	case <-closed:
+	case <-ctx.Done():
+		return ctx.Err()
 	}
+	<-done
+	return nil
 }
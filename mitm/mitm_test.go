@@ -0,0 +1,95 @@
+package mitm
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestProxy(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("X-Hook")))
+	}))
+	defer srv.Close()
+
+	ca, err := NewCA(DefaultCAName, AlgECDSA, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := ca.Save(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := New(&Config{Addr: "127.0.0.1:0", CertFile: filepath.Join(dir, CertFileName), KeyFile: filepath.Join(dir, KeyFileName)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var responses atomic.Int32
+	p.OnRequest(func(req *http.Request) error {
+		if req.Method != http.MethodConnect {
+			req.Header.Set("X-Hook", "1")
+		}
+		return nil
+	})
+	p.OnResponse(func(res *http.Response) error {
+		if res.Request.Method != http.MethodConnect {
+			responses.Add(1)
+		}
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := p.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Start(ctx); err != ErrStarted {
+		t.Errorf("second start: got %v, want %v", err, ErrStarted)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Cert)
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyURL(&url.URL{Scheme: "http", Host: p.Addr().String()}),
+		TLSClientConfig: &tls.Config{RootCAs: pool},
+	}}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "1" || responses.Load() != 1 {
+		t.Errorf("hooks not applied: body %q, %d responses", body, responses.Load())
+	}
+
+	// 下载页面不经过上游和钩子
+	resp, err = client.Get("http://" + CAHost + "/ca.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(body, ca.Cert.Raw) || responses.Load() != 1 {
+		t.Errorf("download page: status %d, %d bytes, %d responses", resp.StatusCode, len(body), responses.Load())
+	}
+	client.CloseIdleConnections()
+
+	cancel()
+	if err := p.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Get(srv.URL); err == nil {
+		t.Error("proxy still serving after shutdown")
+	}
+}
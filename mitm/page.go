@@ -0,0 +1,75 @@
+package mitm
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// CAHost 通过代理访问 http://opendast.mitm 下载根证书, 请求不会发往上游
+const CAHost = "opendast.mitm"
+
+var pageTmpl = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>OpenDAST MITM CA</title></head>
+<body>
+<h1>OpenDAST MITM CA</h1>
+{{if .}}
+<p>{{.Cert.Subject.CommonName}}, valid until {{.Cert.NotAfter.Format "2006-01-02"}}<br>
+SHA-256: <code>{{.Fingerprint}}</code></p>
+<ul>
+<li><a href="/ca.pem">ca.pem</a> - Firefox, Linux, macOS</li>
+<li><a href="/ca.crt">ca.crt</a> - Windows, Android, iOS</li>
+</ul>
+<p>Only install this certificate on browsers used for testing.</p>
+{{else}}
+<p>HTTPS interception is disabled, there is no certificate to install.</p>
+{{end}}
+</body>
+</html>
+`))
+
+func isCAHost(req *http.Request) bool {
+	return req != nil && req.Method != http.MethodConnect && strings.EqualFold(req.URL.Hostname(), CAHost)
+}
+
+// servePage 用下载页面替换跳过了上游请求的响应
+func (p *Proxy) servePage(res *http.Response) error {
+	status, contentType := http.StatusOK, "text/html; charset=utf-8"
+	var (
+		body     []byte
+		filename string
+	)
+	switch path := res.Request.URL.Path; {
+	case path == "/" || path == "":
+		var b bytes.Buffer
+		if err := pageTmpl.Execute(&b, p.ca); err != nil {
+			return err
+		}
+		body = b.Bytes()
+	case path == "/ca.pem" && p.ca != nil:
+		contentType, body, filename = "application/x-pem-file", p.ca.PEM(), "ca.pem"
+	case path == "/ca.crt" && p.ca != nil:
+		contentType, body, filename = "application/x-x509-ca-cert", p.ca.DER(), "ca.crt"
+	default:
+		status, contentType, body = http.StatusNotFound, "text/plain; charset=utf-8", []byte("404 page not found\n")
+	}
+
+	res.StatusCode = status
+	res.Status = fmt.Sprintf("%d %s", status, http.StatusText(status))
+	res.Header = http.Header{
+		"Content-Type":   {contentType},
+		"Content-Length": {strconv.Itoa(len(body))},
+		"Cache-Control":  {"no-store"},
+	}
+	if filename != "" {
+		res.Header.Set("Content-Disposition", "attachment; filename="+filename)
+	}
+	res.ContentLength = int64(len(body))
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	return nil
+}
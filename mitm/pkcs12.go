@@ -0,0 +1,262 @@
+package mitm
+
+import (
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"unicode/utf16"
+)
+
+// PKCS#12 使用兼容性最好的算法: 私钥以 pbeWithSHAAnd3-KeyTripleDES-CBC 加密, 证书不加密, 完整性为 HMAC-SHA1.
+// Windows、macOS 钥匙串、Firefox、Java keytool 和 Burp 都可以导入
+var (
+	oidDataContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidCertBag           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidShroudedKeyBag    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidCertTypeX509      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidFriendlyName      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidLocalKeyID        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+	oidPBEWithSHAAnd3DES = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+	oidSHA1              = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+)
+
+const (
+	pkcs12Iterations = 2048
+	pkcs12SaltLen    = 8
+)
+
+type pfx struct {
+	Version  int
+	AuthSafe contentInfo
+	MacData  macData
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"tag:0,explicit,optional"`
+}
+
+type macData struct {
+	Mac        digestInfo
+	MacSalt    []byte
+	Iterations int `asn1:"optional,default:1"`
+}
+
+type digestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type safeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue     `asn1:"tag:0,explicit"`
+	Attributes []pkcs12Attribute `asn1:"set,optional"`
+}
+
+type pkcs12Attribute struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"set"`
+}
+
+type certBag struct {
+	ID   asn1.ObjectIdentifier
+	Data []byte `asn1:"tag:0,explicit"`
+}
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Data      []byte
+}
+
+type pbeParams struct {
+	Salt       []byte
+	Iterations int
+}
+
+// PKCS12 返回包含证书和私钥的 PKCS#12 (.p12), 用于导入其他代理工具
+func (ca *CA) PKCS12(password string) ([]byte, error) {
+	pass := append(bmpString(password), 0, 0)
+	keyID := sha1.Sum(ca.Cert.Raw)
+	localKeyID, err := attribute(oidLocalKeyID, keyID[:])
+	if err != nil {
+		return nil, err
+	}
+	friendlyName, err := attribute(oidFriendlyName, asn1.RawValue{Tag: asn1.TagBMPString, Bytes: bmpString(ca.Cert.Subject.CommonName)})
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := asn1.Marshal(certBag{ID: oidCertTypeX509, Data: ca.Cert.Raw})
+	if err != nil {
+		return nil, err
+	}
+	certs, err := asn1.Marshal([]safeBag{{
+		ID:         oidCertBag,
+		Value:      explicit(cert),
+		Attributes: []pkcs12Attribute{friendlyName, localKeyID},
+	}})
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.MarshalPKCS8PrivateKey(ca.Key)
+	if err != nil {
+		return nil, err
+	}
+	shrouded, err := encryptKey(key, pass)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := asn1.Marshal([]safeBag{{
+		ID:         oidShroudedKeyBag,
+		Value:      explicit(shrouded),
+		Attributes: []pkcs12Attribute{localKeyID},
+	}})
+	if err != nil {
+		return nil, err
+	}
+
+	var safes []contentInfo
+	for _, data := range [][]byte{certs, keys} {
+		octets, err := asn1.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		safes = append(safes, contentInfo{ContentType: oidDataContentType, Content: explicit(octets)})
+	}
+	authSafe, err := asn1.Marshal(safes)
+	if err != nil {
+		return nil, err
+	}
+	octets, err := asn1.Marshal(authSafe)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, pkcs12SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha1.New, pkcs12KDF(salt, pass, pkcs12Iterations, 3, sha1.Size))
+	mac.Write(authSafe)
+
+	return asn1.Marshal(pfx{
+		Version:  3,
+		AuthSafe: contentInfo{ContentType: oidDataContentType, Content: explicit(octets)},
+		MacData: macData{
+			Mac: digestInfo{
+				Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA1, Parameters: asn1.NullRawValue},
+				Digest:    mac.Sum(nil),
+			},
+			MacSalt:    salt,
+			Iterations: pkcs12Iterations,
+		},
+	})
+}
+
+// encryptKey 以 pbeWithSHAAnd3-KeyTripleDES-CBC 加密 PKCS#8 私钥
+func encryptKey(key, pass []byte) ([]byte, error) {
+	salt := make([]byte, pkcs12SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	block, err := des.NewTripleDESCipher(pkcs12KDF(salt, pass, pkcs12Iterations, 1, 24))
+	if err != nil {
+		return nil, err
+	}
+	iv := pkcs12KDF(salt, pass, pkcs12Iterations, 2, block.BlockSize())
+
+	// PKCS#7 填充
+	pad := block.BlockSize() - len(key)%block.BlockSize()
+	data := append(append([]byte(nil), key...), make([]byte, pad)...)
+	for i := len(key); i < len(data); i++ {
+		data[i] = byte(pad)
+	}
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+
+	params, err := asn1.Marshal(pbeParams{Salt: salt, Iterations: pkcs12Iterations})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidPBEWithSHAAnd3DES, Parameters: asn1.RawValue{FullBytes: params}},
+		Data:      data,
+	})
+}
+
+// pkcs12KDF RFC 7292 附录 B.2 的密钥派生函数, id 为 1 加密密钥、2 IV、3 MAC 密钥
+func pkcs12KDF(salt, pass []byte, iterations int, id byte, size int) []byte {
+	const v = 64 // SHA-1 的块大小
+
+	fill := func(b []byte) []byte {
+		if len(b) == 0 {
+			return nil
+		}
+		out := make([]byte, v*((len(b)+v-1)/v))
+		for i := range out {
+			out[i] = b[i%len(b)]
+		}
+		return out
+	}
+	d := make([]byte, v)
+	for i := range d {
+		d[i] = id
+	}
+	I := append(fill(salt), fill(pass)...)
+
+	var out []byte
+	one := big.NewInt(1)
+	for len(out) < size {
+		h := sha1.New()
+		h.Write(d)
+		h.Write(I)
+		a := h.Sum(nil)
+		for i := 1; i < iterations; i++ {
+			sum := sha1.Sum(a)
+			a = sum[:]
+		}
+		out = append(out, a...)
+
+		// I_j = (I_j + B + 1) mod 2^(v*8), B 为 A 重复到 v 字节
+		b := new(big.Int).SetBytes(fill(a)[:v])
+		b.Add(b, one)
+		for j := 0; j < len(I); j += v {
+			ij := new(big.Int).SetBytes(I[j : j+v])
+			ij.Add(ij, b)
+			sum := ij.Bytes()
+			if len(sum) > v {
+				sum = sum[len(sum)-v:]
+			}
+			clear(I[j : j+v])
+			copy(I[j+v-len(sum):j+v], sum)
+		}
+	}
+	return out[:size]
+}
+
+// bmpString 以 UTF-16BE 编码, PKCS#12 的密码还需要以两个 0 字节结尾
+func bmpString(s string) []byte {
+	var b []byte
+	for _, r := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(r>>8), byte(r))
+	}
+	return b
+}
+
+func explicit(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+}
+
+func attribute(id asn1.ObjectIdentifier, value any) (pkcs12Attribute, error) {
+	der, err := asn1.Marshal(value)
+	if err != nil {
+		return pkcs12Attribute{}, err
+	}
+	return pkcs12Attribute{ID: id, Value: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: der}}, nil
+}
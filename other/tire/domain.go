@@ -0,0 +1,329 @@
+package tire
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// 域名规则的匹配范围
+const (
+	domainExact     uint8 = 1 << iota // 域名本身
+	domainSub                         // 子域名
+	domainNotExact                    // 排除域名本身
+	domainNotSub                      // 排除子域名
+	suffixException                   // 公共后缀列表的 ! 例外规则
+)
+
+// DomainTrie 域名范围, 规则按标签反转后存入 Trie, 如 www.example.com 存为 com.example.www.,
+// 查找时只需遍历主机名的前缀, 与规则数量无关.
+//
+// 规则格式:
+//
+//	example.com     只匹配 example.com
+//	*.example.com   只匹配子域名
+//	.example.com    匹配 example.com 及其子域名
+//	!*.example.com  排除, 任意排除规则命中时不匹配, 也可以使用 - 前缀
+type DomainTrie struct {
+	trie    *Trie
+	rules   map[string]uint8 // 反转的域名 → 规则
+	include int              // 包含规则数量
+}
+
+func NewDomainTrie() *DomainTrie {
+	return &DomainTrie{
+		trie:  NewTrie(),
+		rules: make(map[string]uint8),
+	}
+}
+
+// ParseDomains 读取规则, 每行一条, 忽略空行和 # 注释
+func ParseDomains(r io.Reader) (*DomainTrie, error) {
+	d := NewDomainTrie()
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := s.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		if err := d.Add(line); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+	}
+	return d, s.Err()
+}
+
+// LoadDomains 从文件读取规则
+func LoadDomains(name string) (*DomainTrie, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	d, err := ParseDomains(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return d, nil
+}
+
+// Add 添加一条规则
+func (d *DomainTrie) Add(rule string) error {
+	domain := strings.TrimSpace(rule)
+	exclude := strings.HasPrefix(domain, "!") || strings.HasPrefix(domain, "-")
+	if exclude {
+		domain = domain[1:]
+	}
+
+	flag := domainExact
+	switch {
+	case strings.HasPrefix(domain, "*."):
+		flag, domain = domainSub, domain[2:]
+	case strings.HasPrefix(domain, "."):
+		flag, domain = domainExact|domainSub, domain[1:]
+	}
+	domain = normalizeHost(domain)
+	if domain == "" || strings.Contains(domain, "*") || strings.Contains(domain, "..") {
+		return fmt.Errorf("invalid domain rule %q", rule)
+	}
+
+	if exclude {
+		flag <<= 2
+	} else {
+		d.include++
+	}
+	d.insert(domain, flag)
+	return nil
+}
+
+func (d *DomainTrie) insert(domain string, flag uint8) {
+	key := reverseLabels(domain)
+	if _, ok := d.rules[key]; !ok {
+		d.trie.Insert(key)
+	}
+	d.rules[key] |= flag
+}
+
+// Match 返回 host 命中的最具体的包含规则, 命中排除规则或没有命中时返回 false.
+// host 可以是主机名、host:port 或 URL
+func (d *DomainTrie) Match(host string) (string, bool) {
+	if d == nil {
+		return "", false
+	}
+	key := reverseLabels(normalizeHost(host))
+	if key == "" {
+		return "", false
+	}
+
+	var matched string
+	excluded := false
+	d.walk(key, func(prefix string, exact bool) {
+		flag := d.rules[prefix]
+		if exact {
+			excluded = excluded || flag&domainNotExact != 0
+			if flag&domainExact != 0 {
+				matched = ruleString(prefix, flag)
+			}
+			return
+		}
+		excluded = excluded || flag&domainNotSub != 0
+		if flag&domainSub != 0 {
+			matched = ruleString(prefix, flag)
+		}
+	})
+	if excluded || matched == "" {
+		return "", false
+	}
+	return matched, true
+}
+
+// Contains host 是否命中包含规则且没有被排除
+func (d *DomainTrie) Contains(host string) bool {
+	_, ok := d.Match(host)
+	return ok
+}
+
+// Allow 作为扫描范围使用, nil 或没有包含规则时允许所有未被排除的主机
+func (d *DomainTrie) Allow(host string) bool {
+	if d == nil {
+		return true
+	}
+	if d.include == 0 {
+		return !d.Excluded(host)
+	}
+	return d.Contains(host)
+}
+
+// Excluded host 是否命中排除规则
+func (d *DomainTrie) Excluded(host string) bool {
+	if d == nil {
+		return false
+	}
+	excluded := false
+	d.walk(reverseLabels(normalizeHost(host)), func(prefix string, exact bool) {
+		if exact {
+			excluded = excluded || d.rules[prefix]&domainNotExact != 0
+		} else {
+			excluded = excluded || d.rules[prefix]&domainNotSub != 0
+		}
+	})
+	return excluded
+}
+
+// walk 按从短到长的顺序遍历是 key 的前缀的规则, exact 表示规则就是 key 本身
+func (d *DomainTrie) walk(key string, fn func(prefix string, exact bool)) {
+	d.trie.Prefixes(key, func(prefix string) bool {
+		fn(prefix, len(prefix) == len(key))
+		return true
+	})
+}
+
+func ruleString(key string, flag uint8) string {
+	domain := strings.TrimSuffix(reverseLabels(key), ".")
+	switch {
+	case flag&domainExact != 0 && flag&domainSub != 0:
+		return "." + domain
+	case flag&domainSub != 0:
+		return "*." + domain
+	}
+	return domain
+}
+
+// SuffixList 公共后缀列表 (https://publicsuffix.org/list/), 用于提取可注册域名 (eTLD+1)
+type SuffixList struct {
+	trie  *Trie
+	rules map[string]uint8 // 反转的后缀 → domainExact、domainSub 或 suffixException
+}
+
+// ParseSuffixList 读取 public_suffix_list.dat 格式的列表
+func ParseSuffixList(r io.Reader) (*SuffixList, error) {
+	l := &SuffixList{trie: NewTrie(), rules: make(map[string]uint8)}
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "//") {
+			continue
+		}
+		// 规则到第一个空白字符为止
+		line = strings.Fields(line)[0]
+
+		flag := domainExact
+		switch {
+		case strings.HasPrefix(line, "!"):
+			flag, line = suffixException, line[1:]
+		case strings.HasPrefix(line, "*."):
+			flag, line = domainSub, line[2:]
+		}
+		key := reverseLabels(strings.ToLower(line))
+		if _, ok := l.rules[key]; !ok {
+			l.trie.Insert(key)
+		}
+		l.rules[key] |= flag
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// PublicSuffix 返回 domain 的公共后缀, 没有规则命中时为顶级域名.
+// l 为 nil 时使用 golang.org/x/net/publicsuffix 内置的列表
+func (l *SuffixList) PublicSuffix(domain string) string {
+	domain = normalizeHost(domain)
+	if l == nil {
+		suffix, _ := publicsuffix.PublicSuffix(domain)
+		return suffix
+	}
+
+	key := reverseLabels(domain)
+	labels := strings.Count(key, ".")
+	// 默认规则 * 即顶级域名
+	n, exception := 1, 0
+	l.trie.Prefixes(key, func(prefix string) bool {
+		depth := strings.Count(prefix, ".")
+		flag := l.rules[prefix]
+		if flag&suffixException != 0 {
+			// 例外规则优先, 后缀为去掉最左边标签的部分
+			exception = depth - 1
+			return false
+		}
+		if flag&domainExact != 0 {
+			n = max(n, depth)
+		}
+		if flag&domainSub != 0 && labels > depth {
+			n = max(n, depth+1)
+		}
+		return true
+	})
+	if exception > 0 {
+		n = exception
+	}
+	return lastLabels(domain, n)
+}
+
+// EffectiveTLDPlusOne 返回可注册域名, 如 www.example.co.uk 返回 example.co.uk.
+// domain 本身是公共后缀时返回错误, l 为 nil 时使用内置的列表
+func (l *SuffixList) EffectiveTLDPlusOne(domain string) (string, error) {
+	domain = normalizeHost(domain)
+	if l == nil {
+		return publicsuffix.EffectiveTLDPlusOne(domain)
+	}
+	suffix := l.PublicSuffix(domain)
+	if len(domain) <= len(suffix) {
+		return "", fmt.Errorf("cannot derive eTLD+1 for domain %q", domain)
+	}
+	i := strings.LastIndexByte(domain[:len(domain)-len(suffix)-1], '.')
+	return domain[i+1:], nil
+}
+
+// lastLabels 返回 domain 最后 n 个标签
+func lastLabels(domain string, n int) string {
+	i := len(domain)
+	for ; n > 0 && i > 0; n-- {
+		i = strings.LastIndexByte(domain[:i], '.')
+		if i < 0 {
+			return domain
+		}
+	}
+	return domain[i+1:]
+}
+
+// reverseLabels 反转域名的标签并以 . 结尾, 使 Trie 中的前缀都落在标签边界上
+func reverseLabels(domain string) string {
+	domain = strings.TrimSuffix(domain, ".")
+	if domain == "" {
+		return ""
+	}
+	labels := strings.Split(domain, ".")
+	var b strings.Builder
+	b.Grow(len(domain) + 1)
+	for i := len(labels) - 1; i >= 0; i-- {
+		b.WriteString(labels[i])
+		b.WriteByte('.')
+	}
+	return b.String()
+}
+
+// normalizeHost 从主机名、host:port 或 URL 中取出小写的主机名, 去掉结尾的 .
+func normalizeHost(host string) string {
+	host = strings.TrimSpace(host)
+	if strings.Contains(host, "://") {
+		if u, err := url.Parse(host); err == nil {
+			host = u.Hostname()
+		}
+	} else if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
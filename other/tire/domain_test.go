@@ -0,0 +1,131 @@
+package tire
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDomainTrie(t *testing.T) {
+	d, err := ParseDomains(strings.NewReader(`
+# 扫描范围
+example.com
+*.corp.example.com
+.test.org        # 包括 test.org
+!admin.corp.example.com
+-*.prod.test.org
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for host, want := range map[string]string{
+		"example.com":                "example.com",
+		"EXAMPLE.COM.":               "example.com",
+		"https://example.com:8443/a": "example.com",
+		"example.com:80":             "example.com",
+		"www.example.com":            "",
+		"corp.example.com":           "",
+		"a.corp.example.com":         "*.corp.example.com",
+		"a.b.corp.example.com":       "*.corp.example.com",
+		"admin.corp.example.com":     "",
+		"x.admin.corp.example.com":   "*.corp.example.com",
+		"test.org":                   ".test.org",
+		"www.test.org":               ".test.org",
+		"prod.test.org":              ".test.org",
+		"db.prod.test.org":           "",
+		"atest.org":                  "",
+		"org":                        "",
+		"":                           "",
+	} {
+		got, ok := d.Match(host)
+		if got != want || ok != (want != "") {
+			t.Errorf("Match(%q) = %q %v, want %q", host, got, ok, want)
+		}
+	}
+
+	if !d.Allow("a.corp.example.com") || d.Allow("google.com") {
+		t.Error("Allow should follow include rules")
+	}
+
+	// 只有排除规则时作为黑名单使用
+	block := NewDomainTrie()
+	if err := block.Add("!.gov"); err != nil {
+		t.Fatal(err)
+	}
+	if block.Allow("www.whitehouse.gov") || !block.Allow("example.com") || block.Contains("example.com") {
+		t.Error("exclude-only trie should act as a blocklist")
+	}
+
+	var none *DomainTrie
+	if !none.Allow("example.com") || none.Contains("example.com") {
+		t.Error("nil trie should allow everything and contain nothing")
+	}
+
+	for _, rule := range []string{"", "*.", "a.*.com", "a..com", "!"} {
+		if err := NewDomainTrie().Add(rule); err == nil {
+			t.Errorf("Add(%q) should fail", rule)
+		}
+	}
+}
+
+const suffixList = `
+// ===BEGIN ICANN DOMAINS===
+com
+uk
+co.uk
+*.ck
+!www.ck
+// 日本
+jp
+*.kawasaki.jp
+!city.kawasaki.jp
+`
+
+func TestSuffixList(t *testing.T) {
+	l, err := ParseSuffixList(strings.NewReader(suffixList))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for domain, want := range map[string][2]string{
+		"www.example.com":           {"com", "example.com"},
+		"example.co.uk":             {"co.uk", "example.co.uk"},
+		"a.b.example.co.uk":         {"co.uk", "example.co.uk"},
+		"co.uk":                     {"co.uk", ""},
+		"a.b.ck":                    {"b.ck", "a.b.ck"},
+		"b.ck":                      {"b.ck", ""},
+		"www.ck":                    {"ck", "www.ck"},
+		"x.www.ck":                  {"ck", "www.ck"},
+		"a.city.kawasaki.jp":        {"kawasaki.jp", "city.kawasaki.jp"},
+		"a.b.kawasaki.jp":           {"b.kawasaki.jp", "a.b.kawasaki.jp"},
+		"http://www.example.dev:80": {"dev", "example.dev"},
+	} {
+		if got := l.PublicSuffix(domain); got != want[0] {
+			t.Errorf("PublicSuffix(%q) = %q, want %q", domain, got, want[0])
+		}
+		got, err := l.EffectiveTLDPlusOne(domain)
+		if got != want[1] || (err != nil) != (want[1] == "") {
+			t.Errorf("EffectiveTLDPlusOne(%q) = %q %v, want %q", domain, got, err, want[1])
+		}
+	}
+
+	// nil 使用内置的列表
+	var builtin *SuffixList
+	if got, err := builtin.EffectiveTLDPlusOne("www.bbc.co.uk"); err != nil || got != "bbc.co.uk" {
+		t.Errorf("builtin EffectiveTLDPlusOne = %q %v", got, err)
+	}
+}
+
+func BenchmarkDomainTrie(b *testing.B) {
+	d := NewDomainTrie()
+	for i := 0; i < 100000; i++ {
+		if err := d.Add(fmt.Sprintf(".h%d.example%d.com", i, i%100)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d.Contains("www.h4242.example42.com")
+	}
+}
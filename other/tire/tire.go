@@ -1,12 +1,22 @@
 package tire
 
+import (
+	"sort"
+	"unicode/utf8"
+)
+
 type TrieNode struct {
 	children map[rune]*TrieNode
 	isEnd    bool
+	word     string // 以该节点结尾的单词
+
+	fail *TrieNode // 最长的真后缀对应的节点
+	out  *TrieNode // fail 链上最近的单词结尾节点
 }
 
 type Trie struct {
-	root *TrieNode
+	root  *TrieNode
+	built bool // 失效指针是否为最新
 }
 
 func newTrieNode() *TrieNode {
@@ -43,5 +53,159 @@ func (t *Trie) Insert(word string) {
 		node = node.children[char]
 	}
 	node.isEnd = true
-	node.children = nil
+	node.word = word
+	t.built = false
+}
+
+// find 返回 prefix 对应的节点, 不存在时返回 nil
+func (t *Trie) find(prefix string) *TrieNode {
+	node := t.root
+	for _, char := range prefix {
+		if node = node.children[char]; node == nil {
+			return nil
+		}
+	}
+	return node
+}
+
+// HasPrefix 是否有以 prefix 开头的单词
+func (t *Trie) HasPrefix(prefix string) bool {
+	return t.find(prefix) != nil
+}
+
+// WithPrefix 返回以 prefix 开头的所有单词, 按字典序排序
+func (t *Trie) WithPrefix(prefix string) []string {
+	var words []string
+	var walk func(node *TrieNode)
+	walk = func(node *TrieNode) {
+		if node.isEnd {
+			words = append(words, node.word)
+		}
+		for _, child := range node.children {
+			walk(child)
+		}
+	}
+	if node := t.find(prefix); node != nil {
+		walk(node)
+	}
+	sort.Strings(words)
+	return words
+}
+
+// LongestPrefix 返回是 s 的前缀的最长单词, 如域名或路径的最长匹配
+func (t *Trie) LongestPrefix(s string) (string, bool) {
+	var (
+		node    = t.root
+		longest *TrieNode
+	)
+	if node.isEnd {
+		longest = node
+	}
+	for _, char := range s {
+		if node = node.children[char]; node == nil {
+			break
+		}
+		if node.isEnd {
+			longest = node
+		}
+	}
+	if longest == nil {
+		return "", false
+	}
+	return longest.word, true
+}
+
+// Prefixes 按从短到长的顺序遍历是 s 的前缀的单词, fn 返回 false 时停止
+func (t *Trie) Prefixes(s string, fn func(word string) bool) {
+	node := t.root
+	if node.isEnd && !fn(node.word) {
+		return
+	}
+	for _, char := range s {
+		if node = node.children[char]; node == nil {
+			return
+		}
+		if node.isEnd && !fn(node.word) {
+			return
+		}
+	}
+}
+
+// Build 按 Aho-Corasick 算法计算失效指针, Insert 之后需要重新 Build
+func (t *Trie) Build() {
+	t.root.fail, t.root.out = nil, nil
+	queue := make([]*TrieNode, 0, len(t.root.children))
+	for _, child := range t.root.children {
+		child.fail, child.out = t.root, nil
+		queue = append(queue, child)
+	}
+
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		for char, child := range node.children {
+			fail := node.fail
+			for fail != nil && fail.children[char] == nil {
+				fail = fail.fail
+			}
+			if fail == nil {
+				child.fail = t.root
+			} else {
+				child.fail = fail.children[char]
+			}
+			if child.fail.isEnd {
+				child.out = child.fail
+			} else {
+				child.out = child.fail.out
+			}
+			queue = append(queue, child)
+		}
+	}
+	t.built = true
+}
+
+// Match 文本中出现的单词, Start 和 End 为字节偏移
+type Match struct {
+	Word       string
+	Start, End int
+}
+
+// Scan 一次扫描找出 text 中出现的所有单词 (包括重叠的), fn 返回 false 时停止.
+// 未 Build 时会先构建失效指针, 此时不能与其他 Scan 并发调用
+func (t *Trie) Scan(text string, fn func(m Match) bool) {
+	if !t.built {
+		t.Build()
+	}
+
+	node := t.root
+	for end := 0; end < len(text); {
+		char, size := utf8.DecodeRuneInString(text[end:])
+		end += size
+
+		for node != t.root && node.children[char] == nil {
+			node = node.fail
+		}
+		if next := node.children[char]; next != nil {
+			node = next
+		}
+
+		for out := node; out != nil; out = out.out {
+			if !out.isEnd {
+				continue
+			}
+			if !fn(Match{Word: out.word, Start: end - len(out.word), End: end}) {
+				return
+			}
+		}
+	}
+}
+
+// FindAll 返回 text 中出现的所有单词, 按结束位置排序
+func (t *Trie) FindAll(text string) []Match {
+	var matches []Match
+	t.Scan(text, func(m Match) bool {
+		matches = append(matches, m)
+		return true
+	})
+	return matches
 }
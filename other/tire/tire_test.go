@@ -2,6 +2,7 @@ package tire
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -15,9 +16,93 @@ func TestTrie(t *testing.T) {
 	trie.Insert("app")
 	trie.Insert("banana")
 
-	fmt.Println(trie.Search("apple"))  // true
-	fmt.Println(trie.Search("app"))    // true
-	fmt.Println(trie.Search("appl"))   // false
-	fmt.Println(trie.Search("banana")) // true
-	fmt.Println(trie.Search("你好"))     // true
+	for word, want := range map[string]bool{
+		"apple":  true,
+		"app":    true,
+		"appl":   false,
+		"banana": true,
+		"你好":     true,
+		"你们":     false,
+		"":       false,
+	} {
+		if got := trie.Search(word); got != want {
+			t.Errorf("Search(%q) = %v, want %v", word, got, want)
+		}
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	trie := NewTrie()
+	for _, w := range []string{"app", "apple", "application", "banana", "你好", "你们好"} {
+		trie.Insert(w)
+	}
+
+	if !trie.HasPrefix("appl") || !trie.HasPrefix("你") || trie.HasPrefix("apq") {
+		t.Error("HasPrefix")
+	}
+	if got := strings.Join(trie.WithPrefix("appl"), " "); got != "apple application" {
+		t.Errorf("WithPrefix(appl) = %s", got)
+	}
+	if got := strings.Join(trie.WithPrefix("你"), " "); got != "你们好 你好" {
+		t.Errorf("WithPrefix(你) = %s", got)
+	}
+	if got := trie.WithPrefix("x"); got != nil {
+		t.Errorf("WithPrefix(x) = %v", got)
+	}
+
+	for s, want := range map[string]string{
+		"applications": "application",
+		"apply":        "app",
+		"apple pie":    "apple",
+		"ap":           "",
+		"你好吗":          "你好",
+	} {
+		got, ok := trie.LongestPrefix(s)
+		if got != want || ok != (want != "") {
+			t.Errorf("LongestPrefix(%q) = %q %v, want %q", s, got, ok, want)
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	trie := NewTrie()
+	for _, w := range []string{"abc", "ab", "bc", "c", "你好"} {
+		trie.Insert(w)
+	}
+	if !trie.Search("abc") || !trie.Search("ab") {
+		t.Fatal("inserting a prefix should keep longer words")
+	}
+
+	var got []string
+	for _, m := range trie.FindAll("xabc你好") {
+		got = append(got, fmt.Sprintf("%s@%d-%d", m.Word, m.Start, m.End))
+	}
+	want := "ab@1-3 abc@1-4 bc@2-4 c@3-4 你好@4-10"
+	if strings.Join(got, " ") != want {
+		t.Errorf("got %v, want %s", got, want)
+	}
+}
+
+func TestScanInsertAfterBuild(t *testing.T) {
+	trie := NewTrie()
+	trie.Insert("he")
+	trie.Insert("she")
+	if n := len(trie.FindAll("ushers")); n != 2 {
+		t.Fatalf("got %d matches", n)
+	}
+
+	// Insert 后下一次 Scan 重新构建失效指针
+	trie.Insert("hers")
+	trie.Insert("his")
+	var words []string
+	trie.Scan("ushers", func(m Match) bool {
+		words = append(words, m.Word)
+		return m.Word != "he"
+	})
+	if strings.Join(words, " ") != "she he" {
+		t.Errorf("got %v", words)
+	}
+	if n := len(trie.FindAll("ushers his")); n != 4 {
+		t.Errorf("got %d matches, want 4", n)
+	}
 }
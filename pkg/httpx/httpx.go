@@ -0,0 +1,370 @@
+// Package httpx 扫描器共用的 HTTP 客户端:
+// 代理 (HTTP/SOCKS5)、默认请求头和 Cookie、重定向链记录、响应体大小限制、
+// 按主机限速、重试、扫描范围以及 TLS/SNI 设置
+package httpx
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BreakOnCrash/opendast/other/tire"
+)
+
+const (
+	// UserAgent 未配置时使用的 User-Agent
+	UserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36"
+
+	DefaultTimeout      = 10
+	DefaultMaxRedirects = 10
+	DefaultMaxBodySize  = 10 << 20
+	DefaultRetryDelay   = 500 // ms
+
+	maxRetryDelay = 10 * time.Second
+)
+
+var (
+	ErrTooManyRedirects = errors.New("too many redirects")
+	ErrUnsupportedProxy = errors.New("unsupported proxy scheme")
+	ErrOutOfScope       = errors.New("host out of scope")
+)
+
+type Config struct {
+	Proxy     string            // http://, https://, socks5:// 或 socks5h:// 代理地址
+	ProxyEnv  bool              // Proxy 为空时使用 HTTP_PROXY/HTTPS_PROXY/NO_PROXY 环境变量, 默认直连
+	Headers   map[string]string // 请求未设置时添加的请求头
+	Cookie    string            // 请求未设置时添加的 Cookie 头
+	UserAgent string
+
+	Timeout      int   // 单次请求超时时间, 单位秒, 包括重定向
+	NoRedirect   bool  // 不跟随重定向, 直接返回 3xx 响应
+	MaxRedirects int   // 最多跟随的重定向次数
+	MaxBodySize  int64 // 响应体最多读取的字节数, 超出部分丢弃
+	RateLimit    int   // 每个主机每秒最多请求数, 0 为不限制
+	Retries      int   // 连接错误或 429/502/503/504 时的重试次数
+	RetryDelay   int   // 第一次重试前的等待时间, 单位毫秒, 之后每次翻倍并加入随机抖动
+
+	Verify     bool   // 校验证书, 扫描器默认不校验
+	ServerName string // TLS SNI, 为空时使用请求的主机名
+	KeepAlive  bool   // 复用连接, 默认每个请求使用新连接
+
+	Scope *tire.DomainTrie // 扫描范围, 请求或重定向到范围外的主机时返回 ErrOutOfScope
+}
+
+// Redirect 一次重定向
+type Redirect struct {
+	URL        string `json:"url"`
+	StatusCode int    `json:"status"`
+	Location   string `json:"location"`
+}
+
+// Response 响应, Body 最多读取 MaxBodySize 字节, 调用方负责关闭
+type Response struct {
+	*http.Response
+	Chain []Redirect // 按顺序记录的重定向, 不跟随重定向时为空
+
+	// ServerHello 最终响应所在 TLS 连接的握手参数, 非 HTTPS 或复用连接时为空
+	ServerHello *ServerHello
+}
+
+type Client struct {
+	cfg     *Config
+	client  *http.Client
+	limiter *hostLimiter
+}
+
+func New(cfg *Config) (*Client, error) {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if cfg.MaxRedirects <= 0 {
+		cfg.MaxRedirects = DefaultMaxRedirects
+	}
+	if cfg.MaxBodySize <= 0 {
+		cfg.MaxBodySize = DefaultMaxBodySize
+	}
+	if cfg.UserAgent == "" {
+		cfg.UserAgent = UserAgent
+	}
+	if cfg.RetryDelay <= 0 {
+		cfg.RetryDelay = DefaultRetryDelay
+	}
+
+	transport := &http.Transport{
+		DialContext: recordDial((&net.Dialer{
+			Timeout:   time.Duration(cfg.Timeout) * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext),
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: !cfg.Verify,
+			ServerName:         cfg.ServerName,
+		},
+		TLSHandshakeTimeout: time.Duration(cfg.Timeout) * time.Second,
+		DisableKeepAlives:   !cfg.KeepAlive,
+		DisableCompression:  true,
+		MaxIdleConnsPerHost: 100,
+	}
+	if cfg.ProxyEnv {
+		transport.Proxy = http.ProxyFromEnvironment
+	}
+	if cfg.Proxy != "" {
+		u, err := url.Parse(cfg.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("proxy: %w", err)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedProxy, u.Scheme)
+		}
+		transport.Proxy = http.ProxyURL(u)
+	}
+
+	c := &Client{cfg: cfg}
+	if cfg.RateLimit > 0 {
+		c.limiter = newHostLimiter(time.Second / time.Duration(cfg.RateLimit))
+	}
+	c.client = &http.Client{
+		Transport:     transport,
+		Timeout:       time.Duration(cfg.Timeout) * time.Second,
+		CheckRedirect: c.checkRedirect,
+	}
+	return c, nil
+}
+
+var (
+	defaultOnce   sync.Once
+	defaultClient *Client
+)
+
+// Default 返回使用默认配置的共享客户端
+func Default() *Client {
+	defaultOnce.Do(func() {
+		defaultClient, _ = New(&Config{})
+	})
+	return defaultClient
+}
+
+type chainKey struct{}
+
+func (c *Client) checkRedirect(req *http.Request, via []*http.Request) error {
+	if c.cfg.NoRedirect {
+		return http.ErrUseLastResponse
+	}
+	if len(via) >= c.cfg.MaxRedirects {
+		return ErrTooManyRedirects
+	}
+	if !c.cfg.Scope.Allow(req.URL.Hostname()) {
+		return fmt.Errorf("redirect to %s: %w", req.URL.Host, ErrOutOfScope)
+	}
+	if chain, ok := req.Context().Value(chainKey{}).(*[]Redirect); ok && req.Response != nil {
+		*chain = append(*chain, Redirect{
+			URL:        req.Response.Request.URL.String(),
+			StatusCode: req.Response.StatusCode,
+			Location:   req.URL.String(),
+		})
+	}
+	if err := c.wait(req); err != nil {
+		return err
+	}
+	c.prepare(req)
+	return nil
+}
+
+// prepare 补充配置的默认请求头
+func (c *Client) prepare(req *http.Request) {
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", c.cfg.UserAgent)
+	}
+	for k, v := range c.cfg.Headers {
+		if req.Header.Get(k) == "" {
+			req.Header.Set(k, v)
+		}
+	}
+	if c.cfg.Cookie != "" && req.Header.Get("Cookie") == "" {
+		req.Header.Set("Cookie", c.cfg.Cookie)
+	}
+}
+
+func (c *Client) wait(req *http.Request) error {
+	if c.limiter == nil {
+		return nil
+	}
+	return c.limiter.wait(req.Context(), req.URL.Host)
+}
+
+// Do 发送请求, 按配置退避重试; 只有可以重放请求体 (GetBody 不为空) 的请求才会重试
+func (c *Client) Do(req *http.Request) (*Response, error) {
+	if !c.cfg.Scope.Allow(req.URL.Hostname()) {
+		return nil, fmt.Errorf("%s: %w", req.URL.Host, ErrOutOfScope)
+	}
+
+	var (
+		chain []Redirect
+		hello = &helloCapture{}
+		ctx   = context.WithValue(req.Context(), chainKey{}, &chain)
+	)
+	req = req.WithContext(context.WithValue(ctx, helloKey{}, hello))
+	c.prepare(req)
+
+	retries := c.cfg.Retries
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		retries = 0
+	}
+
+	for i := 0; ; i++ {
+		if i > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+		chain = chain[:0]
+
+		if err := c.wait(req); err != nil {
+			return nil, err
+		}
+		resp, err := c.client.Do(req)
+		if i < retries && retryable(req.Context(), resp, err) {
+			d := c.backoff(i, resp)
+			if resp != nil {
+				resp.Body.Close()
+			}
+			if err := sleep(req.Context(), d); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		resp.Body = &limitedBody{
+			Reader: io.LimitReader(resp.Body, c.cfg.MaxBodySize),
+			Closer: resp.Body,
+		}
+		r := &Response{Response: resp, Chain: chain}
+		if resp.TLS != nil {
+			r.ServerHello = hello.hello()
+		}
+		return r, nil
+	}
+}
+
+// retryable 只重试传输错误和临时性的状态码. 范围、重定向次数的错误和 ctx 取消或超时重试也不会改变;
+// Config.Timeout 的单次请求超时也匹配 context.DeadlineExceeded, 所以检查的是请求的 ctx 而不是错误
+func retryable(ctx context.Context, resp *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		return !errors.Is(err, ErrOutOfScope) && !errors.Is(err, ErrTooManyRedirects)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// backoff 第 i 次重试前的等待时间: 指数退避并在 [d/2, d) 内随机抖动,
+// 响应带有 Retry-After 秒数时至少等待该时间, 最多等待 maxRetryDelay
+func (c *Client) backoff(i int, resp *http.Response) time.Duration {
+	d := time.Duration(c.cfg.RetryDelay) * time.Millisecond << i
+	if d <= 0 || d > maxRetryDelay {
+		d = maxRetryDelay
+	}
+	d = d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+
+	if resp != nil {
+		if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s > 0 {
+			if after := time.Duration(s) * time.Second; after > d {
+				d = after
+			}
+		}
+	}
+	if d > maxRetryDelay {
+		d = maxRetryDelay
+	}
+	return d
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// Get 发送 GET 请求
+func (c *Client) Get(ctx context.Context, URL string) (*Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// ReadBody 读取并关闭响应体
+func ReadBody(resp *Response) ([]byte, error) {
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+type limitedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// hostLimiter 限制每个主机的请求间隔
+type hostLimiter struct {
+	interval time.Duration
+
+	mux  sync.Mutex
+	next map[string]time.Time
+}
+
+func newHostLimiter(interval time.Duration) *hostLimiter {
+	return &hostLimiter{interval: interval, next: make(map[string]time.Time)}
+}
+
+func (l *hostLimiter) wait(ctx context.Context, host string) error {
+	host = strings.ToLower(host)
+
+	l.mux.Lock()
+	now := time.Now()
+	at := l.next[host]
+	if at.Before(now) {
+		at = now
+	}
+	l.next[host] = at.Add(l.interval)
+	l.mux.Unlock()
+
+	d := at.Sub(now)
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,273 @@
+package httpx
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/BreakOnCrash/opendast/other/tire"
+)
+
+func TestClient(t *testing.T) {
+	var flaky, redirects atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/a":
+			redirects.Add(1)
+			http.Redirect(w, r, "/b", http.StatusFound)
+		case "/b":
+			http.Redirect(w, r, "/echo", http.StatusMovedPermanently)
+		case "/echo":
+			w.Write([]byte(r.UserAgent() + "|" + r.Header.Get("X-Scan") + "|" + r.Header.Get("Cookie")))
+		case "/big":
+			w.Write([]byte(strings.Repeat("x", 100)))
+		case "/flaky":
+			if flaky.Add(1) < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte("ok"))
+		}
+	}))
+	defer srv.Close()
+
+	c, err := New(&Config{
+		Headers:     map[string]string{"X-Scan": "1"},
+		Cookie:      "sid=x",
+		MaxBodySize: 10,
+		Retries:     2,
+		RetryDelay:  1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	resp, err := c.Get(ctx, srv.URL+"/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ReadBody(resp)
+	if len(resp.Chain) != 2 || resp.Chain[0].StatusCode != http.StatusFound || resp.Chain[1].Location != srv.URL+"/echo" {
+		t.Errorf("unexpected chain %+v", resp.Chain)
+	}
+	if string(body) != "Mozilla/5." {
+		t.Errorf("body not limited: %q", body)
+	}
+
+	c.cfg.MaxBodySize = DefaultMaxBodySize
+	resp, err = c.Get(ctx, srv.URL+"/echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = ReadBody(resp)
+	if want := UserAgent + "|1|sid=x"; string(body) != want {
+		t.Errorf("got %q, want %q", body, want)
+	}
+
+	resp, err = c.Get(ctx, srv.URL+"/flaky")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || flaky.Load() != 3 {
+		t.Errorf("status %d after %d requests", resp.StatusCode, flaky.Load())
+	}
+
+	c, _ = New(&Config{NoRedirect: true})
+	resp, err = c.Get(ctx, srv.URL+"/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound || len(resp.Chain) != 0 {
+		t.Errorf("redirect followed: %d %+v", resp.StatusCode, resp.Chain)
+	}
+
+	// 重定向次数超出限制时不重试
+	c, _ = New(&Config{MaxRedirects: 1, Retries: 2, RetryDelay: 1})
+	redirects.Store(0)
+	if _, err := c.Get(ctx, srv.URL+"/a"); !errors.Is(err, ErrTooManyRedirects) {
+		t.Errorf("got %v, want %v", err, ErrTooManyRedirects)
+	}
+	if n := redirects.Load(); n != 1 {
+		t.Errorf("requested %d times", n)
+	}
+}
+
+func TestScope(t *testing.T) {
+	var (
+		srv *httptest.Server
+		out atomic.Int32
+	)
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/out" {
+			out.Add(1)
+			http.Redirect(w, r, strings.Replace(srv.URL, "127.0.0.1", "localhost", 1), http.StatusFound)
+		}
+	}))
+	defer srv.Close()
+
+	scope := tire.NewDomainTrie()
+	if err := scope.Add("127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(&Config{Scope: scope, Retries: 2, RetryDelay: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	resp, err := c.Get(ctx, srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if _, err := c.Get(ctx, srv.URL+"/out"); !errors.Is(err, ErrOutOfScope) {
+		t.Errorf("redirect: got %v, want %v", err, ErrOutOfScope)
+	}
+	if n := out.Load(); n != 1 {
+		t.Errorf("out of scope redirect requested %d times", n)
+	}
+	if _, err := c.Get(ctx, strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)); !errors.Is(err, ErrOutOfScope) {
+		t.Errorf("request: got %v, want %v", err, ErrOutOfScope)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	c, err := New(&Config{RateLimit: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		resp, err := c.Get(context.Background(), srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if d := time.Since(start); d < 200*time.Millisecond {
+		t.Errorf("5 requests at 20/s took %v", d)
+	}
+}
+
+func TestProxy(t *testing.T) {
+	if _, err := New(&Config{Proxy: "ftp://127.0.0.1:21"}); !errors.Is(err, ErrUnsupportedProxy) {
+		t.Errorf("got %v, want %v", err, ErrUnsupportedProxy)
+	}
+
+	var hits atomic.Int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Write([]byte(r.URL.String()))
+	}))
+	defer proxy.Close()
+
+	c, err := New(&Config{Proxy: proxy.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.Get(context.Background(), "http://example.invalid/x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ReadBody(resp)
+	if hits.Load() != 1 || string(body) != "http://example.invalid/x" {
+		t.Errorf("proxy not used: %q", body)
+	}
+
+	// 环境变量中的代理需要显式启用
+	c, _ = New(&Config{})
+	if c.client.Transport.(*http.Transport).Proxy != nil {
+		t.Error("environment proxy used by default")
+	}
+	c, _ = New(&Config{ProxyEnv: true})
+	if c.client.Transport.(*http.Transport).Proxy == nil {
+		t.Error("environment proxy not used with ProxyEnv")
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	var hits, slow atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			if slow.Add(1) == 1 {
+				time.Sleep(1500 * time.Millisecond)
+			}
+			return
+		}
+		hits.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	c, err := New(&Config{Retries: 2, RetryDelay: 40})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	resp, err := c.Get(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	// 两次重试分别等待 [20ms, 40ms) 和 [40ms, 80ms)
+	if d := time.Since(start); hits.Load() != 3 || d < 60*time.Millisecond {
+		t.Errorf("%d requests in %v", hits.Load(), d)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	c, _ = New(&Config{Retries: 2, RetryDelay: 1000})
+	if _, err := c.Get(ctx, srv.URL); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// 单次请求超时与 ctx 超时不同, 需要重试
+	c, _ = New(&Config{Timeout: 1, Retries: 1, RetryDelay: 1})
+	resp, err = c.Get(context.Background(), srv.URL+"/slow")
+	if err != nil {
+		t.Fatalf("timeout not retried: %v", err)
+	}
+	resp.Body.Close()
+	if n := slow.Load(); n != 2 {
+		t.Errorf("slow requested %d times", n)
+	}
+
+	after := &http.Response{Header: http.Header{"Retry-After": {"2"}}}
+	if d := c.backoff(0, after); d != 2*time.Second {
+		t.Errorf("backoff with Retry-After = %v, want 2s", d)
+	}
+}
+
+func TestServerHello(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	resp, err := Default().Get(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	h := resp.ServerHello
+	if h == nil {
+		t.Fatal("no server hello")
+	}
+	if h.Version != tls.VersionTLS12 || h.Cipher != resp.TLS.CipherSuite || len(h.Extensions) == 0 {
+		t.Errorf("unexpected server hello %+v, state %x", h, resp.TLS.CipherSuite)
+	}
+	if len(h.JA3S()) != 32 {
+		t.Errorf("invalid ja3s %s", h.JA3S())
+	}
+}
@@ -0,0 +1,151 @@
+package httpx
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxHelloSize 每个连接最多记录的读取字节数, 足够容纳 ServerHello 及其前面的代理握手
+const maxHelloSize = 16 << 10
+
+// ServerHello TLS 握手中服务端选择的参数, 用于计算 JA3S
+type ServerHello struct {
+	Version    uint16   // legacy_version, TLS 1.3 中固定为 0x0303
+	Cipher     uint16   // 选择的密码套件
+	Extensions []uint16 // 按出现顺序的扩展类型
+}
+
+// JA3S 返回 md5("版本,套件,扩展1-扩展2...")
+func (h *ServerHello) JA3S() string {
+	exts := make([]string, len(h.Extensions))
+	for i, e := range h.Extensions {
+		exts[i] = strconv.Itoa(int(e))
+	}
+	s := strconv.Itoa(int(h.Version)) + "," + strconv.Itoa(int(h.Cipher)) + "," + strings.Join(exts, "-")
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+type helloKey struct{}
+
+// helloCapture 记录请求最后建立的连接
+type helloCapture struct {
+	mux  sync.Mutex
+	conn *recordConn
+}
+
+func (h *helloCapture) set(c *recordConn) {
+	h.mux.Lock()
+	h.conn = c
+	h.mux.Unlock()
+}
+
+func (h *helloCapture) hello() *ServerHello {
+	h.mux.Lock()
+	c := h.conn
+	h.mux.Unlock()
+	if c == nil {
+		return nil
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return parseServerHello(c.buf)
+}
+
+// recordConn 记录连接开头读取的数据
+type recordConn struct {
+	net.Conn
+
+	mux sync.Mutex
+	buf []byte
+}
+
+func (c *recordConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.mux.Lock()
+		if rest := maxHelloSize - len(c.buf); rest > 0 {
+			c.buf = append(c.buf, p[:min(n, rest)]...)
+		}
+		c.mux.Unlock()
+	}
+	return n, err
+}
+
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// recordDial 请求上下文中有 helloCapture 时记录新连接读取的数据
+func recordDial(dial dialFunc) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		if h, ok := ctx.Value(helloKey{}).(*helloCapture); ok {
+			rc := &recordConn{Conn: conn}
+			h.set(rc)
+			return rc, nil
+		}
+		return conn, nil
+	}
+}
+
+// parseServerHello 在 data 中查找第一个 ServerHello 记录并解析, 经过代理时前面可能有代理的握手数据
+func parseServerHello(data []byte) *ServerHello {
+	for i := 0; i+9 < len(data); i++ {
+		// record: type(1) version(2) length(2), handshake: type(1) length(3)
+		if data[i] != 0x16 || data[i+1] != 0x03 || data[i+5] != 0x02 {
+			continue
+		}
+		n := int(binary.BigEndian.Uint16(data[i+3:]))
+		if i+5+n > len(data) {
+			return nil
+		}
+		if h := parseHelloBody(data[i+9 : i+5+n]); h != nil {
+			return h
+		}
+	}
+	return nil
+}
+
+func parseHelloBody(b []byte) *ServerHello {
+	// version(2) random(32) session_id(1+n) cipher(2) compression(1)
+	if len(b) < 35 {
+		return nil
+	}
+	h := &ServerHello{Version: binary.BigEndian.Uint16(b)}
+	b = b[34:]
+	sid := int(b[0])
+	if len(b) < 1+sid+3 {
+		return nil
+	}
+	b = b[1+sid:]
+	h.Cipher = binary.BigEndian.Uint16(b)
+	b = b[3:]
+
+	if len(b) < 2 {
+		return h
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	b = b[2:]
+	if n > len(b) {
+		return nil
+	}
+	b = b[:n]
+	for len(b) >= 4 {
+		h.Extensions = append(h.Extensions, binary.BigEndian.Uint16(b))
+		l := int(binary.BigEndian.Uint16(b[2:]))
+		if 4+l > len(b) {
+			return nil
+		}
+		b = b[4+l:]
+	}
+	return h
+}
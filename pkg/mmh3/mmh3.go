@@ -0,0 +1,83 @@
+// Package mmh3 实现 32 位 MurmurHash3 (x86_32), 与 python mmh3.hash 结果一致
+package mmh3
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"math/bits"
+)
+
+const (
+	c1 = 0xcc9e2d51
+	c2 = 0x1b873593
+)
+
+// Sum32 计算 data 的 MurmurHash3 值
+func Sum32(data []byte, seed uint32) uint32 {
+	h := seed
+	n := len(data)
+
+	for len(data) >= 4 {
+		k := binary.LittleEndian.Uint32(data)
+		data = data[4:]
+
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+
+		h ^= k
+		h = bits.RotateLeft32(h, 13)
+		h = h*5 + 0xe6546b64
+	}
+
+	var k uint32
+	switch len(data) {
+	case 3:
+		k ^= uint32(data[2]) << 16
+		fallthrough
+	case 2:
+		k ^= uint32(data[1]) << 8
+		fallthrough
+	case 1:
+		k ^= uint32(data[0])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+	}
+
+	h ^= uint32(n)
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
+
+// Hash 返回有符号结果, 与 python mmh3.hash(data) 一致
+func Hash(data []byte) int32 {
+	return int32(Sum32(data, 0))
+}
+
+// FaviconHash 计算 Shodan/FOFA 使用的图标哈希:
+// mmh3.hash(base64.encodebytes(data)), 即每 76 个字符换行且末尾带换行的 base64
+func FaviconHash(data []byte) int32 {
+	return Hash(encodeBytes(data))
+}
+
+func encodeBytes(data []byte) []byte {
+	const lineLen = 76
+
+	enc := make([]byte, base64.StdEncoding.EncodedLen(len(data)))
+	base64.StdEncoding.Encode(enc, data)
+
+	out := make([]byte, 0, len(enc)+len(enc)/lineLen+1)
+	for len(enc) > 0 {
+		n := min(lineLen, len(enc))
+		out = append(out, enc[:n]...)
+		out = append(out, '\n')
+		enc = enc[n:]
+	}
+	return out
+}
@@ -0,0 +1,29 @@
+package mmh3
+
+import "testing"
+
+func TestHash(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want int32
+	}{
+		{"", 0},
+		{"foo", -156908512},
+		{"hello", 613153351},
+		{"Hello, world!", -1070186941},
+		{"The quick brown fox jumps over the lazy dog", 776992547},
+	} {
+		if got := Hash([]byte(tt.in)); got != tt.want {
+			t.Errorf("Hash(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestEncodeBytes(t *testing.T) {
+	data := make([]byte, 100)
+	got := string(encodeBytes(data))
+	want := "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA\nAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA==\n"
+	if got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}